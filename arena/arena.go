@@ -0,0 +1,369 @@
+package arena
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// 基于预分配字节数组的缓存，参考freecache/bigcache
+// key和value都存储在一个大的[]byte中，当作环形缓冲区使用；索引是hash到偏移量的map[uint64]uint64
+// 索引和数据都不包含指针，GC扫描的代价与元素个数无关
+//
+// 淘汰策略是CLOCK：从队头开始淘汰，如果元素在上次经过之后被访问过，清除访问标记并移动到队尾，给一次机会
+//
+// 偏移量是逻辑位置，一直递增，物理位置是对容量取余；head到tail之间的数据是有效的
+// 元素的布局：| keyLen(4) | valueLen(4) | flags(1) | 保留(3) | key | value |
+
+const headerSize = 12
+
+const (
+	flagDeleted  = 1 << 0 // 已经被删除或者覆盖，等待淘汰时跳过
+	flagAccessed = 1 << 1 // CLOCK的访问标记
+)
+
+// CLOCK最多给多少个元素第二次机会，之后直接淘汰，避免所有元素都被访问过时循环太久
+const maxSecondChances = 16
+
+type HandleFunc func(key string, value []byte)
+
+type Cache struct {
+	buf   []byte
+	index map[uint64]uint64 // key的hash -> 元素的逻辑偏移量
+
+	head uint64 // 最早的元素的逻辑偏移量
+	tail uint64 // 下一个元素写入的逻辑偏移量
+
+	length int   // 有效元素个数
+	used   int64 // 有效元素的key和value的长度之和
+
+	// 淘汰、删除、覆盖时，回调
+	delete HandleFunc
+}
+
+// capacity是字节数组的大小，即最大容量
+func New(capacity int64) *Cache {
+	if capacity < headerSize {
+		capacity = headerSize
+	}
+	return &Cache{
+		buf:   make([]byte, capacity),
+		index: make(map[uint64]uint64),
+	}
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+type header struct {
+	keyLen   uint32
+	valueLen uint32
+	flags    byte
+}
+
+func (h header) size() uint64 {
+	return headerSize + uint64(h.keyLen) + uint64(h.valueLen)
+}
+
+// 读写时处理环形缓冲区的回绕
+func (c *Cache) readAt(p []byte, off uint64) {
+	size := uint64(len(c.buf))
+	start := off % size
+	n := copy(p, c.buf[start:])
+	if n < len(p) {
+		copy(p[n:], c.buf)
+	}
+}
+
+func (c *Cache) writeAt(p []byte, off uint64) {
+	size := uint64(len(c.buf))
+	start := off % size
+	n := copy(c.buf[start:], p)
+	if n < len(p) {
+		copy(c.buf, p[n:])
+	}
+}
+
+func (c *Cache) readHeader(off uint64) header {
+	var b [headerSize]byte
+	c.readAt(b[:], off)
+	return header{
+		keyLen:   binary.LittleEndian.Uint32(b[0:4]),
+		valueLen: binary.LittleEndian.Uint32(b[4:8]),
+		flags:    b[8],
+	}
+}
+
+func (c *Cache) writeHeader(h header, off uint64) {
+	var b [headerSize]byte
+	binary.LittleEndian.PutUint32(b[0:4], h.keyLen)
+	binary.LittleEndian.PutUint32(b[4:8], h.valueLen)
+	b[8] = h.flags
+	c.writeAt(b[:], off)
+}
+
+func (c *Cache) setFlags(off uint64, flags byte) {
+	c.writeAt([]byte{flags}, off+8)
+}
+
+func (c *Cache) readKey(h header, off uint64) string {
+	key := make([]byte, h.keyLen)
+	c.readAt(key, off+headerSize)
+	return string(key)
+}
+
+func (c *Cache) readValue(h header, off uint64) []byte {
+	value := make([]byte, h.valueLen)
+	c.readAt(value, off+headerSize+uint64(h.keyLen))
+	return value
+}
+
+// 根据key找到元素的偏移量，hash冲突时key不相等，视为不存在
+func (c *Cache) lookup(key string) (uint64, header, bool) {
+	off, ok := c.index[hashKey(key)]
+	if !ok || off < c.head {
+		return 0, header{}, false
+	}
+	h := c.readHeader(off)
+	if h.flags&flagDeleted != 0 || int(h.keyLen) != len(key) || c.readKey(h, off) != key {
+		return 0, header{}, false
+	}
+	return off, h, true
+}
+
+// 查找，设置访问标记；返回的value是拷贝
+func (c *Cache) Get(key string) ([]byte, bool) {
+	off, h, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	if h.flags&flagAccessed == 0 {
+		c.setFlags(off, h.flags|flagAccessed)
+	}
+	return c.readValue(h, off), true
+}
+
+// 查找，不影响淘汰
+func (c *Cache) Peek(key string) ([]byte, bool) {
+	off, h, ok := c.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	return c.readValue(h, off), true
+}
+
+func (c *Cache) Contains(key string) bool {
+	_, _, ok := c.lookup(key)
+	return ok
+}
+
+// 新增、修改，元素超过整个字节数组时拒绝，返回是否被缓存
+func (c *Cache) Set(key string, value []byte) bool {
+	h := header{keyLen: uint32(len(key)), valueLen: uint32(len(value))}
+	if h.size() > uint64(len(c.buf)) {
+		c.Remove(key)
+		return false
+	}
+
+	hash := hashKey(key)
+	if off, old, ok := c.lookup(key); ok {
+		// 长度相同，直接覆盖
+		if old.valueLen == h.valueLen {
+			c.writeAt(value, off+headerSize+uint64(old.keyLen))
+			c.setFlags(off, old.flags|flagAccessed)
+			return true
+		}
+		c.removeAt(off, old, hash, false)
+	} else if off, ok := c.index[hash]; ok && off >= c.head {
+		// hash冲突，覆盖之前的key
+		old := c.readHeader(off)
+		if old.flags&flagDeleted == 0 {
+			c.removeAt(off, old, hash, true)
+		}
+	}
+
+	c.evict(h.size())
+	c.append(key, value, h, hash)
+	return true
+}
+
+func (c *Cache) append(key string, value []byte, h header, hash uint64) {
+	off := c.tail
+	c.writeHeader(h, off)
+	c.writeAt([]byte(key), off+headerSize)
+	c.writeAt(value, off+headerSize+uint64(h.keyLen))
+	c.tail += h.size()
+
+	c.index[hash] = off
+	c.length++
+	c.used += int64(h.keyLen) + int64(h.valueLen)
+}
+
+// 淘汰队头的元素，直到有need大小的空间
+func (c *Cache) evict(need uint64) {
+	chances := 0
+	for uint64(len(c.buf))-(c.tail-c.head) < need {
+		h := c.readHeader(c.head)
+		if h.flags&flagDeleted != 0 {
+			c.head += h.size()
+			continue
+		}
+		if h.flags&flagAccessed != 0 && chances < maxSecondChances {
+			chances++
+			c.moveToTail(h)
+			continue
+		}
+		c.removeOldest(h)
+	}
+}
+
+// 把队头的元素移动到队尾，清除访问标记
+// 队尾写入的物理区域不会超过队头元素所在的区域，先读出来再写，因此重叠也没有问题
+func (c *Cache) moveToTail(h header) {
+	entry := make([]byte, h.size())
+	c.readAt(entry, c.head)
+	entry[8] = h.flags &^ flagAccessed
+
+	key := string(entry[headerSize : headerSize+h.keyLen])
+	c.writeAt(entry, c.tail)
+	c.index[hashKey(key)] = c.tail
+
+	c.head += h.size()
+	c.tail += h.size()
+}
+
+func (c *Cache) removeOldest(h header) {
+	off := c.head
+	c.removeAt(off, h, hashKey(c.readKey(h, off)), true)
+	c.head += h.size()
+}
+
+// 标记为删除，空间等到淘汰时回收
+func (c *Cache) removeAt(off uint64, h header, hash uint64, notify bool) {
+	var key string
+	var value []byte
+	if notify && c.delete != nil {
+		key, value = c.readKey(h, off), c.readValue(h, off)
+	}
+
+	c.setFlags(off, h.flags|flagDeleted)
+	if cur, ok := c.index[hash]; ok && cur == off {
+		delete(c.index, hash)
+	}
+	c.length--
+	c.used -= int64(h.keyLen) + int64(h.valueLen)
+
+	if notify && c.delete != nil {
+		c.delete(key, value)
+	}
+}
+
+// 删除指定的key，返回key是否存在
+func (c *Cache) Remove(key string) bool {
+	off, h, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	c.removeAt(off, h, hashKey(key), true)
+	return true
+}
+
+// 淘汰最早的元素，不考虑访问标记
+func (c *Cache) RemoveOldElement() {
+	for c.head < c.tail {
+		h := c.readHeader(c.head)
+		if h.flags&flagDeleted != 0 {
+			c.head += h.size()
+			continue
+		}
+		c.removeOldest(h)
+		return
+	}
+}
+
+// 遍历有效的元素，从最早到最新
+func (c *Cache) each(fn func(key string, value []byte)) {
+	for off := c.head; off < c.tail; {
+		h := c.readHeader(off)
+		if h.flags&flagDeleted == 0 {
+			fn(c.readKey(h, off), c.readValue(h, off))
+		}
+		off += h.size()
+	}
+}
+
+// 所有的key，从最早到最新
+func (c *Cache) Keys() []string {
+	keys := make([]string, 0, c.length)
+	c.each(func(key string, _ []byte) {
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+// 清空缓存，每个元素都会回调删除方法
+func (c *Cache) Clear() {
+	if c.delete != nil {
+		c.each(c.delete)
+	}
+	c.reset()
+}
+
+func (c *Cache) reset() {
+	c.index = make(map[uint64]uint64)
+	c.head, c.tail = 0, 0
+	c.length, c.used = 0, 0
+}
+
+// 调整容量，重新分配字节数组，按照从最早到最新的顺序重新写入，放不下的会被淘汰
+func (c *Cache) Resize(capacity int64) {
+	if capacity < headerSize {
+		capacity = headerSize
+	}
+	if capacity == int64(len(c.buf)) {
+		return
+	}
+
+	type entry struct {
+		key   string
+		value []byte
+	}
+	entries := make([]entry, 0, c.length)
+	c.each(func(key string, value []byte) {
+		entries = append(entries, entry{key, value})
+	})
+
+	c.buf = make([]byte, capacity)
+	c.reset()
+	for _, e := range entries {
+		if !c.Set(e.key, e.value) && c.delete != nil {
+			c.delete(e.key, e.value)
+		}
+	}
+}
+
+// 有效元素个数
+func (c *Cache) Len() int {
+	return c.length
+}
+
+// 有效元素的key和value的长度之和
+func (c *Cache) UsedCapacity() int64 {
+	return c.used
+}
+
+// 字节数组中已经占用的部分，包括元素的header和等待回收的空间
+func (c *Cache) OccupiedCapacity() int64 {
+	return int64(c.tail - c.head)
+}
+
+// 字节数组的大小
+func (c *Cache) MaxCapacity() int64 {
+	return int64(len(c.buf))
+}
+
+func (c *Cache) SetDeleteHandler(handler HandleFunc) {
+	c.delete = handler
+}
@@ -0,0 +1,224 @@
+package arena
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gy0117/gocache/lru"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestSetAndGet(t *testing.T) {
+	convey.Convey("TestSetAndGet", t, func() {
+		cache := New(1024)
+
+		convey.So(cache.Set("username", []byte("marsxingzhi")), convey.ShouldBeTrue)
+		data, ok := cache.Get("username")
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(string(data), convey.ShouldEqual, "marsxingzhi")
+
+		convey.Convey("update with same and different length", func() {
+			cache.Set("username", []byte("xingzhimars"))
+			data, _ := cache.Get("username")
+			convey.So(string(data), convey.ShouldEqual, "xingzhimars")
+
+			cache.Set("username", []byte("mars"))
+			data, _ = cache.Get("username")
+			convey.So(string(data), convey.ShouldEqual, "mars")
+			convey.So(cache.Len(), convey.ShouldEqual, 1)
+			convey.So(cache.UsedCapacity(), convey.ShouldEqual, 12)
+		})
+
+		convey.Convey("remove", func() {
+			var deleted []string
+			cache.SetDeleteHandler(func(key string, value []byte) {
+				deleted = append(deleted, key)
+			})
+			convey.So(cache.Remove("username"), convey.ShouldBeTrue)
+			convey.So(cache.Remove("username"), convey.ShouldBeFalse)
+			convey.So(cache.Contains("username"), convey.ShouldBeFalse)
+			convey.So(deleted, convey.ShouldResemble, []string{"username"})
+			convey.So(cache.Len(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("too large", func() {
+			convey.So(cache.Set("big", make([]byte, 1024)), convey.ShouldBeFalse)
+			convey.So(cache.Contains("big"), convey.ShouldBeFalse)
+		})
+	})
+}
+
+func TestEvict(t *testing.T) {
+	convey.Convey("TestEvict", t, func() {
+		// 每个元素 12 + 2 + 2 = 16，可以存放4个
+		cache := New(64)
+		var evicted []string
+		cache.SetDeleteHandler(func(key string, value []byte) {
+			evicted = append(evicted, key)
+		})
+
+		for i := 0; i < 4; i++ {
+			cache.Set(fmt.Sprintf("k%d", i), []byte(fmt.Sprintf("v%d", i)))
+		}
+		convey.So(cache.Keys(), convey.ShouldResemble, []string{"k0", "k1", "k2", "k3"})
+
+		convey.Convey("oldest is evicted first", func() {
+			cache.Set("k4", []byte("v4"))
+			convey.So(evicted, convey.ShouldResemble, []string{"k0"})
+			convey.So(cache.Keys(), convey.ShouldResemble, []string{"k1", "k2", "k3", "k4"})
+		})
+
+		convey.Convey("accessed entries get a second chance", func() {
+			cache.Get("k0")
+			cache.Set("k4", []byte("v4"))
+			convey.So(evicted, convey.ShouldResemble, []string{"k1"})
+			convey.So(cache.Keys(), convey.ShouldResemble, []string{"k2", "k3", "k0", "k4"})
+
+			data, ok := cache.Get("k0")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(string(data), convey.ShouldEqual, "v0")
+		})
+
+		convey.Convey("resize", func() {
+			cache.Resize(32)
+			convey.So(cache.Keys(), convey.ShouldResemble, []string{"k2", "k3"})
+			convey.So(evicted, convey.ShouldResemble, []string{"k0", "k1"})
+
+			cache.Resize(128)
+			convey.So(cache.MaxCapacity(), convey.ShouldEqual, 128)
+			convey.So(cache.Keys(), convey.ShouldResemble, []string{"k2", "k3"})
+		})
+	})
+}
+
+// 随机操作，与map对比，覆盖环形缓冲区的回绕
+func TestRandomOps(t *testing.T) {
+	cache := New(1000)
+	ref := make(map[string][]byte)
+	cache.SetDeleteHandler(func(key string, value []byte) {
+		delete(ref, key)
+	})
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		key := fmt.Sprintf("key-%d", r.Intn(200))
+		switch r.Intn(4) {
+		case 0, 1:
+			value := make([]byte, r.Intn(60))
+			r.Read(value)
+			if cache.Set(key, value) {
+				ref[key] = value
+			}
+		case 2:
+			value, ok := cache.Get(key)
+			want, exist := ref[key]
+			if ok != exist || string(value) != string(want) {
+				t.Fatalf("Get(%v) = %v, %v; want %v, %v", key, value, ok, want, exist)
+			}
+		case 3:
+			cache.Remove(key)
+			delete(ref, key)
+		}
+
+		if cache.Len() != len(ref) {
+			t.Fatalf("Len() = %v, want %v", cache.Len(), len(ref))
+		}
+		if cache.OccupiedCapacity() > cache.MaxCapacity() {
+			t.Fatalf("occupied %v > capacity %v", cache.OccupiedCapacity(), cache.MaxCapacity())
+		}
+	}
+}
+
+const benchEntries = 1 << 20
+
+func benchKey(i int) string {
+	return fmt.Sprintf("key-%08d", i)
+}
+
+func BenchmarkArenaSet(b *testing.B) {
+	cache := New(benchEntries * 64)
+	value := make([]byte, 32)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Set(benchKey(i%benchEntries), value)
+	}
+}
+
+func BenchmarkLRUSet(b *testing.B) {
+	cache := lru.New(benchEntries * 64)
+	value := make([]byte, 32)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Add(benchKey(i%benchEntries), bytesValue(value))
+	}
+}
+
+func BenchmarkArenaGet(b *testing.B) {
+	cache := New(benchEntries * 64)
+	value := make([]byte, 32)
+	for i := 0; i < benchEntries; i++ {
+		cache.Set(benchKey(i), value)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Get(benchKey(i % benchEntries))
+	}
+}
+
+func BenchmarkLRUGet(b *testing.B) {
+	cache := lru.New(benchEntries * 64)
+	value := make([]byte, 32)
+	for i := 0; i < benchEntries; i++ {
+		cache.Add(benchKey(i), bytesValue(append([]byte(nil), value...)))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Get(benchKey(i % benchEntries))
+	}
+}
+
+// 缓存中有大量元素时，一次完整GC的耗时
+func benchmarkGC(b *testing.B, fill func(n int)) {
+	fill(benchEntries)
+	runtime.GC()
+
+	var total time.Duration
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		runtime.GC()
+		total += time.Since(start)
+	}
+	b.ReportMetric(float64(total.Nanoseconds())/float64(b.N), "gc-ns/op")
+}
+
+func BenchmarkArenaGC(b *testing.B) {
+	var cache *Cache
+	benchmarkGC(b, func(n int) {
+		cache = New(int64(n) * 64)
+		value := make([]byte, 32)
+		for i := 0; i < n; i++ {
+			cache.Set(benchKey(i), value)
+		}
+	})
+	runtime.KeepAlive(cache)
+}
+
+func BenchmarkLRUGC(b *testing.B) {
+	var cache *lru.Cache
+	benchmarkGC(b, func(n int) {
+		cache = lru.New(int64(n) * 64)
+		for i := 0; i < n; i++ {
+			cache.Add(benchKey(i), bytesValue(make([]byte, 32)))
+		}
+	})
+	runtime.KeepAlive(cache)
+}
+
+type bytesValue []byte
+
+func (b bytesValue) Len() int {
+	return len(b)
+}
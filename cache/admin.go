@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ADMIN_BASE_PATH = "/_admin/"
+
+// 管理接口，只操作本节点的缓存
+//
+//	GET    /_admin/groups                         所有Group的容量和统计数据
+//	GET    /_admin/groups/<group>                 单个Group
+//	GET    /_admin/groups/<group>/keys/<key>      查看本地内存缓存中的key，不影响淘汰顺序
+//	DELETE /_admin/groups/<group>/keys/<key>      删除本地的key，包括磁盘缓存
+//	POST   /_admin/groups/<group>/flush           清空本地缓存
+//	POST   /_admin/groups/<group>/resize?capacity= 调整容量
+type Admin struct {
+	basepath string
+	auth     AuthFunc
+	registry *Registry
+}
+
+// 鉴权，返回false时请求被拒绝
+type AuthFunc func(r *http.Request) bool
+
+// auth为nil时拒绝所有请求
+func NewAdmin(auth AuthFunc) *Admin {
+	return &Admin{
+		basepath: ADMIN_BASE_PATH,
+		auth:     auth,
+		registry: defaultRegistry,
+	}
+}
+
+// 设置管理的Registry，默认使用包级别的Registry；需要在开始服务之前调用
+func (a *Admin) SetRegistry(registry *Registry) {
+	a.registry = registry
+}
+
+// 校验请求头 Authorization: Bearer <token>
+func TokenAuth(token string) AuthFunc {
+	return func(r *http.Request) bool {
+		return checkBearer(r.Header.Get("Authorization"), token)
+	}
+}
+
+// authorization是 Bearer <token> 格式，token为空时总是返回false
+func checkBearer(authorization, token string) bool {
+	got, ok := strings.CutPrefix(authorization, "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+type GroupInfo struct {
+	Name     string `json:"name"`
+	Capacity int64  `json:"capacity"` // 指定的容量
+	TTL      string `json:"ttl,omitempty"`
+	Stats    Stats  `json:"stats"`
+}
+
+type KeyInfo struct {
+	Group  string     `json:"group"`
+	Key    string     `json:"key"`
+	Value  []byte     `json:"value"`
+	Size   int        `json:"size"`
+	Expire *time.Time `json:"expire,omitempty"`
+}
+
+func groupInfo(g *Group) GroupInfo {
+	info := GroupInfo{
+		Name:     g.Name(),
+		Capacity: g.Capacity(),
+		Stats:    g.Stats(),
+	}
+	if ttl := g.TTL(); ttl > 0 {
+		info.TTL = ttl.String()
+	}
+	return info
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.auth == nil || !a.auth(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, a.basepath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// groups/<group>/keys/<key>
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, a.basepath), "/", 4)
+	if parts[0] != "groups" {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 1 || parts[1] == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		infos := []GroupInfo{}
+		for _, g := range a.registry.Groups() {
+			infos = append(infos, groupInfo(g))
+		}
+		writeJSON(w, infos)
+		return
+	}
+
+	g := a.registry.GetGroup(parts[1])
+	if g == nil {
+		http.Error(w, "no such group: "+parts[1], http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		writeJSON(w, groupInfo(g))
+	case len(parts) == 3 && parts[2] == "flush" && r.Method == http.MethodPost:
+		g.Flush()
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && parts[2] == "resize" && r.Method == http.MethodPost:
+		capacity, err := strconv.ParseInt(r.URL.Query().Get("capacity"), 10, 64)
+		if err != nil || capacity < 0 {
+			http.Error(w, "invalid capacity", http.StatusBadRequest)
+			return
+		}
+		g.Resize(capacity)
+		writeJSON(w, groupInfo(g))
+	case len(parts) == 4 && parts[2] == "keys" && parts[3] != "":
+		a.serveKey(w, r, g, parts[3])
+	default:
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}
+}
+
+func (a *Admin) serveKey(w http.ResponseWriter, r *http.Request, g *Group, key string) {
+	switch r.Method {
+	case http.MethodGet:
+		value, ok := g.mainCache.peek(key)
+		if !ok || value.expired(time.Now()) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		info := KeyInfo{Group: g.Name(), Key: key, Value: value.ByteSlice(), Size: value.Len()}
+		if expire := value.Expire(); !expire.IsZero() {
+			info.Expire = &expire
+		}
+		writeJSON(w, info)
+	case http.MethodDelete:
+		g.removeLocally(key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func adminDo(server *httptest.Server, method, path, token string, v interface{}) int {
+	req, _ := http.NewRequest(method, server.URL+ADMIN_BASE_PATH+path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		json.NewDecoder(resp.Body).Decode(v)
+	}
+	return resp.StatusCode
+}
+
+func TestAdmin(t *testing.T) {
+	convey.Convey("TestAdmin", t, func() {
+		g := NewGroup("admin", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key + "-db"), nil
+		}), WithTTL(time.Minute))
+		g.Get("zhangsan")
+		g.Get("lisi")
+
+		server := httptest.NewServer(NewAdmin(TokenAuth("secret")))
+		defer server.Close()
+
+		convey.Convey("requests without the token are rejected", func() {
+			convey.So(adminDo(server, http.MethodGet, "groups", "", nil), convey.ShouldEqual, http.StatusUnauthorized)
+			convey.So(adminDo(server, http.MethodGet, "groups", "wrong", nil), convey.ShouldEqual, http.StatusUnauthorized)
+
+			open := httptest.NewServer(NewAdmin(nil))
+			defer open.Close()
+			convey.So(adminDo(open, http.MethodGet, "groups", "secret", nil), convey.ShouldEqual, http.StatusUnauthorized)
+		})
+
+		convey.Convey("list and inspect groups", func() {
+			var infos []GroupInfo
+			convey.So(adminDo(server, http.MethodGet, "groups", "secret", &infos), convey.ShouldEqual, http.StatusOK)
+			var found bool
+			for _, info := range infos {
+				if info.Name == "admin" {
+					found = true
+					convey.So(info.Capacity, convey.ShouldEqual, 1<<20)
+					convey.So(info.TTL, convey.ShouldEqual, "1m0s")
+					convey.So(info.Stats.Gets, convey.ShouldEqual, 2)
+				}
+			}
+			convey.So(found, convey.ShouldBeTrue)
+
+			var info GroupInfo
+			convey.So(adminDo(server, http.MethodGet, "groups/admin", "secret", &info), convey.ShouldEqual, http.StatusOK)
+			convey.So(info.Stats.CacheBytes, convey.ShouldBeGreaterThan, 0)
+			convey.So(adminDo(server, http.MethodGet, "groups/missing", "secret", nil), convey.ShouldEqual, http.StatusNotFound)
+		})
+
+		convey.Convey("get and delete a key", func() {
+			var key KeyInfo
+			convey.So(adminDo(server, http.MethodGet, "groups/admin/keys/zhangsan", "secret", &key), convey.ShouldEqual, http.StatusOK)
+			convey.So(string(key.Value), convey.ShouldEqual, "zhangsan-db")
+			convey.So(key.Expire, convey.ShouldNotBeNil)
+
+			convey.So(adminDo(server, http.MethodDelete, "groups/admin/keys/zhangsan", "secret", nil), convey.ShouldEqual, http.StatusNoContent)
+			convey.So(adminDo(server, http.MethodGet, "groups/admin/keys/zhangsan", "secret", nil), convey.ShouldEqual, http.StatusNotFound)
+			convey.So(g.mainCache.keys(), convey.ShouldResemble, []string{"lisi"})
+		})
+
+		convey.Convey("flush and resize", func() {
+			convey.So(adminDo(server, http.MethodPost, "groups/admin/flush", "secret", nil), convey.ShouldEqual, http.StatusNoContent)
+			convey.So(g.mainCache.keys(), convey.ShouldBeEmpty)
+
+			var info GroupInfo
+			convey.So(adminDo(server, http.MethodPost, "groups/admin/resize?capacity=100", "secret", &info), convey.ShouldEqual, http.StatusOK)
+			convey.So(info.Capacity, convey.ShouldEqual, 100)
+			convey.So(info.Stats.CacheCapacity, convey.ShouldEqual, 100)
+			convey.So(adminDo(server, http.MethodPost, "groups/admin/resize?capacity=-1", "secret", nil), convey.ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
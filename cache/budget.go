@@ -0,0 +1,34 @@
+package cache
+
+// 进程级别的内存预算，默认的Registry中所有Group共享，由默认的Manager管理，每个Group的权重相同
+var defaultManager = NewManager(0)
+
+// 设置进程级别的内存预算，为0时取消预算，每个Group恢复创建时的容量
+// 建议配合WithMemoryAccounting使用，使预算接近真实的内存占用
+func SetMemoryBudget(total int64) {
+	all := defaultRegistry.Groups()
+
+	if total <= 0 {
+		for _, g := range all {
+			defaultManager.Unregister(g)
+		}
+		defaultManager.SetTotal(0)
+		return
+	}
+
+	defaultManager.SetTotal(total)
+	for _, g := range all {
+		if g.manager.Load() == nil {
+			defaultManager.Register(g, 1)
+		}
+	}
+}
+
+func MemoryBudget() int64 {
+	return defaultManager.Total()
+}
+
+// 进程级别的内存预算下，已经使用的内存
+func MemoryUsage() int64 {
+	return defaultManager.Usage()
+}
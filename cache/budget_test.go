@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	convey.Convey("TestMemoryBudget", t, func() {
+		getter := GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key + key), nil
+		})
+
+		g1 := NewGroup("budget1", 0, getter, WithMemoryAccounting())
+		g2 := NewGroup("budget2", 0, getter, WithMemoryAccounting())
+
+		n := int64(len(Groups()))
+
+		total := n * 10 * (entryOverhead + 12)
+		SetMemoryBudget(total)
+		defer SetMemoryBudget(0)
+
+		convey.So(MemoryBudget(), convey.ShouldEqual, total)
+
+		for i := 0; i < 100; i++ {
+			g1.Get(fmt.Sprintf("k%03d", i))
+			g2.Get(fmt.Sprintf("k%03d", i))
+		}
+
+		// 权重相同，两个Group的使用量接近均分
+		convey.So(MemoryUsage(), convey.ShouldBeLessThanOrEqualTo, total)
+		convey.So(g1.mainCache.bytes(), convey.ShouldBeGreaterThan, 0)
+		convey.So(g2.mainCache.bytes(), convey.ShouldBeGreaterThan, 0)
+		convey.So(g1.mainCache.bytes()-g2.mainCache.bytes(), convey.ShouldBeBetweenOrEqual, -(entryOverhead + 12), entryOverhead+12)
+
+		// 取消预算后，恢复创建时的容量
+		SetMemoryBudget(0)
+		for i := 0; i < 100; i++ {
+			g1.Get(fmt.Sprintf("k%03d", i))
+		}
+		convey.So(g1.mainCache.bytes(), convey.ShouldEqual, 100*(entryOverhead+12))
+	})
+}
@@ -1,8 +1,14 @@
 package cache
 
+import (
+	"encoding/binary"
+	"time"
+)
+
 // 缓存数据
 type ByteData struct {
-	data []byte
+	data   []byte
+	expire int64 // 过期时间，UnixNano，为0表示不过期
 }
 
 func (db ByteData) Len() int {
@@ -22,3 +28,33 @@ func cloneBytes(in []byte) []byte {
 func (db ByteData) String() string {
 	return string(db.data)
 }
+
+// 过期时间，不过期时返回零值
+func (db ByteData) Expire() time.Time {
+	if db.expire == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, db.expire)
+}
+
+func (db ByteData) expired(now time.Time) bool {
+	return db.expire != 0 && now.UnixNano() >= db.expire
+}
+
+// 存储到arena或者磁盘时的编码：| expire(8) | data |
+func encodeByteData(db ByteData) []byte {
+	buf := make([]byte, 8+len(db.data))
+	binary.LittleEndian.PutUint64(buf, uint64(db.expire))
+	copy(buf[8:], db.data)
+	return buf
+}
+
+func decodeByteData(b []byte) ByteData {
+	if len(b) < 8 {
+		return ByteData{}
+	}
+	return ByteData{
+		data:   b[8:],
+		expire: int64(binary.LittleEndian.Uint64(b)),
+	}
+}
@@ -2,37 +2,397 @@ package cache
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
+	"github.com/gy0117/gocache/disk"
 	"github.com/gy0117/gocache/lru"
 )
 
-// 封装lru，提供并发能力
+// 按内存统计时，每个元素的额外开销：lru中的开销，加上ByteData装箱到接口后的header
+const entryOverhead = lru.EntryOverhead + int64(unsafe.Sizeof(ByteData{}))
+
+// 封装存储后端，提供并发能力
+// 磁盘的读写不在mutex中进行，内存淘汰的数据先放入pending，释放锁之后再写入磁盘
 type cacheInner struct {
 	mutex         sync.Mutex
-	lru           *lru.Cache
+	store         store
+	storage       Storage
 	cacheCapacity int64
+	// 每个元素额外计入的开销，为0时只统计key和value
+	overhead int64
+
+	// 磁盘缓存，存放内存淘汰的数据，为nil时不启用
+	disk *disk.Store
+	// 磁盘的写入和删除按照内存中操作的顺序执行，持有时可以再获取mutex，反之不行
+	diskMutex sync.Mutex
+	// 内存淘汰、还没有写入磁盘的数据，由mutex保护
+	pending map[string]spilled
+	// 本次操作淘汰的key，释放锁之后写入磁盘，由mutex保护
+	spilled []string
+	// pending中数据的序号，由mutex保护
+	seq uint64
+	// 每次写入、删除时加一，从磁盘读取之后没有变化才放回内存，由mutex保护
+	version uint64
+	// 主动删除时为true，此时不写入磁盘
+	removing bool
+	// 为true时过期的数据留在内存中直到被淘汰，get视为不存在，peek仍然可以读到
+	keepStale bool
+	// 共享内存预算时，使用量的变化计入Manager的总量，由mutex保护
+	usage *atomic.Int64
 }
 
-func (c *cacheInner) add(key string, value ByteData) {
+// 等待写入磁盘的数据
+type spilled struct {
+	value ByteData
+	seq   uint64
+}
+
+func (c *cacheInner) lazyInit() {
+	if c.store != nil {
+		return
+	}
+	c.store = newStore(c.storage, c.cacheCapacity, c.overhead)
+	if c.disk != nil {
+		c.store.setDeleteHandler(c.spill)
+	}
+}
+
+// 已经使用的容量，调用时已经持有锁
+func (c *cacheInner) bytesLocked() int64 {
+	if c.store == nil {
+		return 0
+	}
+	return c.store.bytes()
+}
+
+// 把操作前后使用量的变化计入Manager，调用时已经持有锁
+func (c *cacheInner) account(before int64) {
+	if c.usage != nil {
+		c.usage.Add(c.bytesLocked() - before)
+	}
+}
+
+// 切换使用量计入的总量，已经使用的容量从旧的总量转移到新的总量
+func (c *cacheInner) setUsage(usage *atomic.Int64) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheCapacity)
+	used := c.bytesLocked()
+	if c.usage != nil {
+		c.usage.Add(-used)
+	}
+	c.usage = usage
+	if c.usage != nil {
+		c.usage.Add(used)
 	}
+}
 
-	c.lru.Add(key, value)
+// 内存淘汰的数据放入pending，调用时已经持有锁
+func (c *cacheInner) spill(key string, value ByteData) {
+	if c.removing {
+		return
+	}
+	if value.expired(time.Now()) {
+		return
+	}
+	if c.pending == nil {
+		c.pending = make(map[string]spilled)
+	}
+	c.seq++
+	c.pending[key] = spilled{value: value, seq: c.seq}
+	c.spilled = append(c.spilled, key)
 }
 
+// 取出本次操作淘汰的key，调用时已经持有锁
+func (c *cacheInner) takeSpilled() []string {
+	keys := c.spilled
+	c.spilled = nil
+	return keys
+}
+
+// 把淘汰的数据写入磁盘，调用时没有持有mutex
+// 写入之前已经被删除的数据不再写入，写入之后再删除的数据，删除在diskMutex上等待写入完成
+func (c *cacheInner) flush(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	c.diskMutex.Lock()
+	defer c.diskMutex.Unlock()
+
+	for _, key := range keys {
+		c.mutex.Lock()
+		entry, ok := c.pending[key]
+		c.mutex.Unlock()
+		if !ok {
+			continue
+		}
+
+		if err := c.disk.Put(key, encodeByteData(entry.value)); err != nil {
+			errorf("cacheInner.flush | disk.Put | key: %v, err: %+v\n", key, err)
+		}
+
+		c.mutex.Lock()
+		if cur, ok := c.pending[key]; ok && cur.seq == entry.seq {
+			delete(c.pending, key)
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// 把Manager淘汰或者修改容量时淘汰的数据写入磁盘
+func (c *cacheInner) flushSpilled() {
+	c.mutex.Lock()
+	keys := c.takeSpilled()
+	c.mutex.Unlock()
+
+	c.flush(keys)
+}
+
+// 删除磁盘中的数据，调用时没有持有mutex
+func (c *cacheInner) removeFromDisk(key string) {
+	if c.disk == nil {
+		return
+	}
+
+	c.diskMutex.Lock()
+	defer c.diskMutex.Unlock()
+	c.disk.Remove(key)
+}
+
+func (c *cacheInner) add(key string, value ByteData) {
+	c.mutex.Lock()
+	before := c.bytesLocked()
+	c.lazyInit()
+	c.version++
+	ok := c.store.add(key, value)
+	if !ok {
+		delete(c.pending, key)
+	}
+	c.account(before)
+	keys := c.takeSpilled()
+	c.mutex.Unlock()
+
+	c.flush(keys)
+	// 内存拒绝时，磁盘上旧的数据也不能再读到
+	if !ok {
+		c.removeFromDisk(key)
+	}
+}
+
+// 内存中没有时，再从磁盘中查找，找到后放回内存；过期的数据视为不存在，并删除
 func (c *cacheInner) get(key string) (value ByteData, ok bool) {
+	now := time.Now()
+
+	c.mutex.Lock()
+	if c.store != nil {
+		if value, ok = c.store.get(key); ok {
+			if !value.expired(now) {
+				c.mutex.Unlock()
+				return
+			}
+			if c.keepStale {
+				c.mutex.Unlock()
+				return ByteData{}, false
+			}
+			before := c.bytesLocked()
+			c.removeLocked(key)
+			c.account(before)
+			c.mutex.Unlock()
+
+			c.removeFromDisk(key)
+			return ByteData{}, false
+		}
+	}
+	if c.disk == nil {
+		c.mutex.Unlock()
+		return
+	}
+
+	// 还没有写入磁盘时直接从pending中读取
+	entry, pending := c.pending[key]
+	version := c.version
+	c.mutex.Unlock()
+
+	if pending {
+		value = entry.value
+	} else {
+		data, err := c.disk.Get(key)
+		if err != nil {
+			return
+		}
+		value = decodeByteData(data)
+	}
+	if value.expired(now) {
+		c.mutex.Lock()
+		if cur, ok := c.pending[key]; ok && cur.seq == entry.seq {
+			delete(c.pending, key)
+		}
+		c.mutex.Unlock()
+
+		c.removeFromDisk(key)
+		return ByteData{}, false
+	}
+
+	// 读取期间有写入或者删除时，不再放回内存，避免覆盖更新的数据
+	c.mutex.Lock()
+	if c.version != version {
+		c.mutex.Unlock()
+		return value, true
+	}
+	before := c.bytesLocked()
+	c.lazyInit()
+	c.store.add(key, value)
+	c.account(before)
+	keys := c.takeSpilled()
+	c.mutex.Unlock()
+
+	c.flush(keys)
+	return value, true
+}
+
+// 查找，不影响淘汰顺序，不查找磁盘，包括已经过期的数据
+func (c *cacheInner) peek(key string) (value ByteData, ok bool) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if c.lru == nil {
+	if c.store == nil {
 		return
 	}
-	if val, ok := c.lru.Get(key); ok {
-		return val.(ByteData), ok
+	return c.store.peek(key)
+}
+
+// 是否命中，不影响淘汰顺序，不删除过期的数据，也不读取磁盘中的数据
+// 磁盘中的数据只检查是否存在，不检查是否过期
+func (c *cacheInner) contains(key string) bool {
+	now := time.Now()
+
+	c.mutex.Lock()
+	if c.store != nil {
+		if value, ok := c.store.peek(key); ok {
+			c.mutex.Unlock()
+			return !value.expired(now)
+		}
+	}
+	if entry, ok := c.pending[key]; ok {
+		c.mutex.Unlock()
+		return !entry.value.expired(now)
+	}
+	c.mutex.Unlock()
+
+	return c.disk != nil && c.disk.Has(key)
+}
+
+// 内存中所有的key，从最近最少使用到最近使用
+func (c *cacheInner) keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.store == nil {
+		return nil
+	}
+	return c.store.keys()
+}
+
+// 删除内存和磁盘中的数据
+func (c *cacheInner) remove(key string) bool {
+	c.mutex.Lock()
+	before := c.bytesLocked()
+	ok := c.removeLocked(key)
+	c.account(before)
+	c.mutex.Unlock()
+
+	c.removeFromDisk(key)
+	return ok
+}
+
+// 删除内存和pending中的数据，调用时已经持有锁，释放锁之后还需要删除磁盘中的数据
+func (c *cacheInner) removeLocked(key string) bool {
+	c.version++
+	delete(c.pending, key)
+
+	ok := false
+	if c.store != nil {
+		c.removing = true
+		ok = c.store.remove(key)
+		c.removing = false
+	}
+	return ok
+}
+
+// 清空内存和磁盘中的数据
+func (c *cacheInner) clear() {
+	c.mutex.Lock()
+	before := c.bytesLocked()
+	c.version++
+	c.pending = nil
+	c.spilled = nil
+	if c.store != nil {
+		c.removing = true
+		c.store.clear()
+		c.removing = false
+	}
+	c.account(before)
+	c.mutex.Unlock()
+
+	if c.disk == nil {
+		return
+	}
+	c.diskMutex.Lock()
+	defer c.diskMutex.Unlock()
+	if err := c.disk.Clear(); err != nil {
+		errorf("cacheInner.clear | disk.Clear | err: %+v\n", err)
+	}
+}
+
+// 调整容量，超出时淘汰
+func (c *cacheInner) resize(capacity int64) {
+	c.setCapacity(capacity)
+	c.flushSpilled()
+}
+
+// 修改容量，淘汰的数据留在pending中，由调用方释放自己的锁之后调用flushSpilled写入磁盘
+func (c *cacheInner) setCapacity(capacity int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	before := c.bytesLocked()
+	c.cacheCapacity = capacity
+	if c.store != nil {
+		c.store.resize(capacity)
+	}
+	c.account(before)
+}
+
+// 已经使用的容量
+func (c *cacheInner) bytes() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.bytesLocked()
+}
+
+func (c *cacheInner) capacity() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.cacheCapacity
+}
+
+// 淘汰最近最少使用的元素，返回释放的容量
+// 淘汰的数据留在pending中，由调用方释放自己的锁之后调用flushSpilled写入磁盘
+func (c *cacheInner) removeOldest() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.store == nil {
+		return 0
+	}
+	used := c.store.bytes()
+	c.store.removeOldest()
+	freed := used - c.store.bytes()
+	if c.usage != nil {
+		c.usage.Add(-freed)
 	}
-	return
+	return freed
 }
@@ -0,0 +1,346 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gy0117/gocache/consistenthash"
+	"github.com/gy0117/gocache/pb"
+	"github.com/gy0117/gocache/peers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// 请求其他节点的超时时间
+const GRPC_TIMEOUT = 5 * time.Second
+
+// 基于gRPC的节点间通信，与HttpPool的区别：
+// 节点的地址是host:port；协议中只有Get，所以Group.Set和Group.Remove只作用于本节点，
+// 有其他节点的副本时返回ErrReplicaNotWritable
+type GrpcPool struct {
+	self string
+
+	mutex     sync.Mutex
+	opts      []grpc.DialOption
+	placement consistenthash.PlacementFunc
+	peersMap  consistenthash.Placement
+	getters   map[string]*grpcGetter
+	replicas  int
+	peerToken string // 节点之间共享的令牌，写入authorization元数据
+
+	subsets subsets // 命名的节点子集
+}
+
+// opts用于连接其他节点，例如 grpc.WithTransportCredentials
+func NewGrpcPool(self string, opts ...grpc.DialOption) *GrpcPool {
+	return &GrpcPool{
+		self:     self,
+		opts:     opts,
+		getters:  make(map[string]*grpcGetter),
+		replicas: 1,
+	}
+}
+
+func (gp *GrpcPool) SetReplicas(n int) {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+
+	if n < 1 {
+		n = 1
+	}
+	gp.replicas = n
+}
+
+// 设置节点之间共享的令牌，需要在SetWeighted之前调用
+func (gp *GrpcPool) SetPeerToken(token string) {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+
+	gp.peerToken = token
+}
+
+// 设置放置算法，之后SetWeighted的节点生效
+func (gp *GrpcPool) SetPlacement(placement consistenthash.PlacementFunc) {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+
+	gp.placement = placement
+}
+
+// 设置节点及其权重，保留已有的连接，关闭被移除的节点的连接
+func (gp *GrpcPool) SetWeighted(weights map[string]int) error {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+
+	getters := make(map[string]*grpcGetter, len(weights))
+	for peer := range weights {
+		if peer == gp.self {
+			continue
+		}
+		if getter, ok := gp.getters[peer]; ok {
+			getters[peer] = getter
+			continue
+		}
+		conn, err := grpc.Dial(peer, gp.opts...)
+		if err != nil {
+			for p, getter := range getters {
+				if _, ok := gp.getters[p]; !ok {
+					getter.conn.Close()
+				}
+			}
+			return err
+		}
+		getters[peer] = &grpcGetter{
+			self:   gp.self,
+			token:  gp.peerToken,
+			conn:   conn,
+			client: pb.NewGroupCacheClient(conn),
+			health: &peerHealth{},
+		}
+	}
+	for peer, getter := range gp.getters {
+		if _, ok := getters[peer]; !ok {
+			getter.conn.Close()
+		}
+	}
+	gp.getters = getters
+
+	if gp.placement == nil {
+		gp.peersMap = newRing(weights)
+	} else {
+		gp.peersMap = gp.placement(weights)
+	}
+	return nil
+}
+
+// 实现PeerPicker接口
+func (gp *GrpcPool) PickPeer(key string) (peers.PeerGetter, bool) {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+
+	if gp.peersMap == nil {
+		return nil, false
+	}
+	peer := gp.peersMap.Get(key)
+	if peer != "" && peer != gp.self {
+		return gp.getters[peer], true
+	}
+	return nil, false
+}
+
+// 实现ownerPicker接口，使用不依赖负载的放置
+func (gp *GrpcPool) owner(key string) string {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+
+	if gp.peersMap == nil {
+		return ""
+	}
+	return consistenthash.Stable(gp.peersMap).Get(key)
+}
+
+// 实现ReplicaPicker接口，不健康的节点排在后面，本节点对应nil
+func (gp *GrpcPool) PickReplicas(key string) []peers.PeerGetter {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+
+	if gp.peersMap == nil {
+		return nil
+	}
+	return orderReplicas(gp.self, gp.peersMap.GetN(key, gp.replicas), gp.getterLocked)
+}
+
+// 调用时已经持有锁
+func (gp *GrpcPool) getterLocked(peer string) (peers.PeerGetter, *peerHealth, bool) {
+	getter, ok := gp.getters[peer]
+	if !ok {
+		return nil, nil, false
+	}
+	return getter, getter.health, true
+}
+
+// 设置命名的节点子集，已经存在时更新其中的节点，节点的地址是host:port
+func (gp *GrpcPool) SetSubset(name string, weights map[string]int) *Subset {
+	return gp.subsets.set(gp, name, weights)
+}
+
+// 命名的节点子集，不存在时返回nil
+func (gp *GrpcPool) Subset(name string) *Subset {
+	return gp.subsets.get(name)
+}
+
+// 以下实现peerSource接口
+func (gp *GrpcPool) selfPeer() string {
+	return gp.self
+}
+
+func (gp *GrpcPool) placeSubset(weights map[string]int) consistenthash.Placement {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+	if gp.placement == nil {
+		return newRing(weights)
+	}
+	return gp.placement(weights)
+}
+
+func (gp *GrpcPool) subsetGetter(peer string) (peers.PeerGetter, *peerHealth, bool) {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+	return gp.getterLocked(peer)
+}
+
+func (gp *GrpcPool) replicaCount() int {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+	return gp.replicas
+}
+
+// peer是否在节点列表中，不包括本节点；用于GrpcServer识别来自其他节点的请求
+func (gp *GrpcPool) IsPeer(peer string) bool {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+	_, ok := gp.getters[peer]
+	return ok
+}
+
+// 关闭所有的连接
+func (gp *GrpcPool) Close() {
+	gp.mutex.Lock()
+	defer gp.mutex.Unlock()
+
+	for _, getter := range gp.getters {
+		getter.conn.Close()
+	}
+	gp.getters = make(map[string]*grpcGetter)
+	gp.peersMap = nil
+}
+
+// 客户端实现PeerGetter接口
+type grpcGetter struct {
+	self   string // 本节点，写入PEER_HEADER元数据
+	token  string // 节点之间共享的令牌，写入authorization元数据
+	conn   *grpc.ClientConn
+	client pb.GroupCacheClient
+	health *peerHealth
+}
+
+func (gg *grpcGetter) Get(in *pb.Request, out *pb.Response) error {
+	ctx, cancel := context.WithTimeout(context.Background(), GRPC_TIMEOUT)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, PEER_HEADER, gg.self)
+	if gg.token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+gg.token)
+	}
+
+	resp, err := gg.client.Get(ctx, in)
+	// 连接失败和超时视为节点不健康，其他错误例如key不存在不影响
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		gg.health.report(err)
+	default:
+		gg.health.report(nil)
+	}
+	if err != nil {
+		return err
+	}
+	out.Value = resp.GetValue()
+	return nil
+}
+
+// 服务端实现GroupCacheServer接口，与HttpPool相同，只在本节点加载，不再转发
+type GrpcServer struct {
+	pb.UnimplementedGroupCacheServer
+	registry  *Registry
+	limiter   *Limiter
+	isPeer    func(peer string) bool
+	peerToken string
+}
+
+func NewGrpcServer() *GrpcServer {
+	return &GrpcServer{registry: defaultRegistry}
+}
+
+// 设置查找Group的Registry，默认使用包级别的Registry；需要在开始服务之前调用
+func (s *GrpcServer) SetRegistry(registry *Registry) {
+	s.registry = registry
+}
+
+// 设置节点之间共享的令牌，用于校验元数据中的节点；需要在开始服务之前调用
+// 使用mTLS时校验过客户端证书的节点不需要令牌
+func (s *GrpcServer) SetPeerToken(token string) {
+	s.peerToken = token
+}
+
+// 设置限流，isPeer判断通过校验的节点是否在节点列表中，例如GrpcPool.IsPeer，为nil时都按照客户端处理
+// 需要在开始服务之前调用
+func (s *GrpcServer) SetLimiter(limiter *Limiter, isPeer func(peer string) bool) {
+	s.limiter = limiter
+	s.isPeer = isPeer
+}
+
+func (s *GrpcServer) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	g := s.registry.GetGroup(in.GetGroup())
+	if g == nil {
+		return nil, status.Errorf(codes.NotFound, "no such group: %v", in.GetGroup())
+	}
+	if err := s.admit(ctx, g, in.GetKey()); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	item, err := g.getLocally(in.GetKey())
+	if err != nil {
+		errorf("GrpcServer.Get | g.getLocally | key: %v, err: %+v\n", in.GetKey(), err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.Response{Value: item.ByteSlice()}, nil
+}
+
+// 限流和过载保护，都返回RESOURCE_EXHAUSTED
+func (s *GrpcServer) admit(ctx context.Context, g *Group, key string) error {
+	if s.limiter == nil {
+		return nil
+	}
+	source, fromPeer := "", false
+	if p, ok := grpcpeer.FromContext(ctx); ok {
+		source = clientIP(p.Addr.String())
+	}
+	if peer, ok := s.authenticatedPeer(ctx); ok && s.isPeer != nil && s.isPeer(peer) {
+		source, fromPeer = peer, true
+	}
+	miss := func() bool { return !g.cached(key) }
+	return s.limiter.admit(source, fromPeer, g.Name(), miss, s.registry.Inflight)
+}
+
+// 元数据中的节点，只有请求通过校验时才返回
+// 使用客户端证书时，该节点的主机名需要在证书中；使用令牌时，持有令牌的节点都是可信的
+func (s *GrpcServer) authenticatedPeer(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get(PEER_HEADER)
+	if len(values) == 0 || values[0] == "" {
+		return "", false
+	}
+	peer := values[0]
+
+	if p, ok := grpcpeer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			host, _, err := net.SplitHostPort(peer)
+			if err == nil && info.State.VerifiedChains[0][0].VerifyHostname(host) == nil {
+				return peer, true
+			}
+		}
+	}
+	for _, authorization := range md.Get("authorization") {
+		if checkBearer(authorization, s.peerToken) {
+			return peer, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gy0117/gocache/pb"
+	"github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcPool(t *testing.T) {
+	convey.Convey("TestGrpcPool", t, func() {
+		loads := 0
+		registry := NewRegistry()
+		g := registry.NewGroup("grpc", 0, GetterFunc(func(key string) ([]byte, error) {
+			if key == "missing" {
+				return nil, fmt.Errorf("%s not exist", key)
+			}
+			loads++
+			return []byte(key + "-db"), nil
+		}))
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		convey.So(err, convey.ShouldBeNil)
+		server := grpc.NewServer()
+		grpcServer := NewGrpcServer()
+		grpcServer.SetRegistry(registry)
+		grpcServer.SetPeerToken("peer-secret")
+		self := "127.0.0.1:1"
+		limiter := NewLimiter(Limits{})
+		grpcServer.SetLimiter(limiter, func(peer string) bool { return peer == self })
+		pb.RegisterGroupCacheServer(server, grpcServer)
+		go server.Serve(lis)
+		defer server.Stop()
+
+		remote := lis.Addr().String()
+		pool := NewGrpcPool(self, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		pool.SetPeerToken("peer-secret")
+		defer pool.Close()
+		convey.So(pool.SetWeighted(map[string]int{self: 1, remote: 1}), convey.ShouldBeNil)
+
+		convey.Convey("remote keys are loaded through the peer", func() {
+			var key string
+			for i := 0; ; i++ {
+				key = fmt.Sprintf("key-%d", i)
+				if newRing(map[string]int{self: 1, remote: 1}).Get(key) == remote {
+					break
+				}
+			}
+			getter, ok := pool.PickPeer(key)
+			convey.So(ok, convey.ShouldBeTrue)
+
+			out := &pb.Response{}
+			convey.So(getter.Get(&pb.Request{Group: g.Name(), Key: key}, out), convey.ShouldBeNil)
+			convey.So(string(out.Value), convey.ShouldEqual, key+"-db")
+			convey.So(loads, convey.ShouldEqual, 1)
+
+			err := getter.Get(&pb.Request{Group: "no-such-group", Key: key}, out)
+			convey.So(err, convey.ShouldNotBeNil)
+			err = getter.Get(&pb.Request{Group: g.Name(), Key: "missing"}, out)
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(getter.(*grpcGetter).health.healthy(time.Now()), convey.ShouldBeTrue)
+		})
+
+		convey.Convey("only peers with the token use the peer bucket", func() {
+			limiter.SetLimits(Limits{Client: RateLimit{Rate: 1, Burst: 1}})
+			getter := pool.getters[remote]
+			for i := 0; i < 2; i++ {
+				convey.So(getter.Get(&pb.Request{Group: g.Name(), Key: "a"}, &pb.Response{}), convey.ShouldBeNil)
+			}
+
+			// 没有令牌时元数据中的节点不可信，按照客户端限流
+			stranger := &grpcGetter{self: self, conn: getter.conn, client: getter.client, health: &peerHealth{}}
+			convey.So(stranger.Get(&pb.Request{Group: g.Name(), Key: "a"}, &pb.Response{}), convey.ShouldBeNil)
+			err := stranger.Get(&pb.Request{Group: g.Name(), Key: "a"}, &pb.Response{})
+			convey.So(status.Code(err), convey.ShouldEqual, codes.ResourceExhausted)
+		})
+
+		convey.Convey("writes report replicas that cannot be written", func() {
+			pool.SetReplicas(2)
+			defer pool.SetReplicas(1)
+			local := registry.NewGroup("grpc-writer", 0, GetterFunc(func(key string) ([]byte, error) {
+				return nil, fmt.Errorf("%s not exist", key)
+			}))
+			local.RegisterPeerPicker(pool)
+
+			err := local.Set("zhangsan", []byte("100"))
+			convey.So(errors.Is(err, ErrReplicaNotWritable), convey.ShouldBeTrue)
+			value, ok := local.mainCache.get("zhangsan")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(value.String(), convey.ShouldEqual, "100")
+
+			err = local.Remove("zhangsan")
+			convey.So(errors.Is(err, ErrReplicaNotWritable), convey.ShouldBeTrue)
+			_, ok = local.mainCache.get("zhangsan")
+			convey.So(ok, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("requests over the limit get RESOURCE_EXHAUSTED", func() {
+			limiter.SetLimits(Limits{Group: RateLimit{Rate: 1, Burst: 1}})
+			getter := pool.getters[remote]
+			convey.So(getter.Get(&pb.Request{Group: g.Name(), Key: "a"}, &pb.Response{}), convey.ShouldBeNil)
+			err := getter.Get(&pb.Request{Group: g.Name(), Key: "a"}, &pb.Response{})
+			convey.So(status.Code(err), convey.ShouldEqual, codes.ResourceExhausted)
+			convey.So(getter.health.healthy(time.Now()), convey.ShouldBeTrue)
+		})
+
+		convey.Convey("removed peers are closed and unreachable peers become unhealthy", func() {
+			dead := "127.0.0.1:2"
+			convey.So(pool.SetWeighted(map[string]int{self: 1, dead: 1}), convey.ShouldBeNil)
+			convey.So(pool.getters, convey.ShouldHaveLength, 1)
+
+			getter := pool.getters[dead]
+			err := getter.Get(&pb.Request{Group: g.Name(), Key: "zhangsan"}, &pb.Response{})
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(getter.health.healthy(time.Now()), convey.ShouldBeFalse)
+		})
+	})
+}
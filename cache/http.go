@@ -1,13 +1,17 @@
 package cache
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/gy0117/gocache/consistenthash"
@@ -18,37 +22,234 @@ import (
 const CACHE_BASE_PATH = "/_marscache/"
 const REPLICS_PEERS = 100
 
+// 请求节点失败后，在这段时间内视为不健康，优先选择其他副本
+const UNHEALTHY_COOLDOWN = 5 * time.Second
+
+// 节点加入后的预热时间，期间本节点未命中的key先去之前的节点的缓存中查找
+const WARMUP_WINDOW = 30 * time.Second
+
+// 节点间的内部接口，group名称不能以_开头
+const (
+	peekPath    = "_peek"    // /_marscache/_peek/<group>/<key>，只查找本地缓存
+	handoffPath = "_handoff" // /_marscache/_handoff/<group>?peer=&peers=&weights=&limit=，流式返回属于peer的缓存
+	leavePath   = "_leave"   // POST /_marscache/_leave?peer=，peer离开集群
+	joinPath    = "_join"    // POST /_marscache/_join?peer=，离开的peer重新加入集群
+	leasePath   = "_lease"   // /_marscache/_lease/<group>/<key>?holder=&ttl=，申请和释放租约
+)
+
 // 分布式缓存，实现节点间通信
 type HttpPool struct {
 	hostPort string
 	basepath string
 
 	mutex       sync.Mutex
-	peersMap    *consistenthash.Map    // 一致性哈希
-	httpGetters map[string]*httpGetter // 一个节点对应一个httpGetter
+	weights     map[string]int               // 节点及其权重
+	placement   consistenthash.PlacementFunc // 构造节点的放置算法，默认为一致性哈希
+	peersMap    consistenthash.Placement     // key所属的节点
+	httpGetters map[string]*httpGetter       // 一个节点对应一个httpGetter
+	loads       sync.Map                     // 节点 -> *atomic.Int64，正在进行的请求数
+
+	left map[string]int // 收到离开的通知而移除的节点及其权重，重新加入时恢复
+
+	previousMap consistenthash.Placement // 本节点加入之前的节点
+	warmupUntil time.Time                // 预热结束的时间
+
+	replicas int // 副本数，每个key存放在哈希环上连续的replicas个节点
+
+	client *http.Client // 访问其他节点的客户端
+
+	registry  *Registry // 处理其他节点的请求时，从中查找Group
+	subsets   subsets   // 命名的节点子集
+	limiter   *Limiter  // 限流，为nil时不限制
+	peerToken string    // 节点之间共享的令牌，为空时只接受校验过客户端证书的节点
 }
 
 func NewHttpPool(hostport string) *HttpPool {
 	return &HttpPool{
 		hostPort: hostport,
 		basepath: CACHE_BASE_PATH,
+		replicas: 1,
+		client:   http.DefaultClient,
+		registry: defaultRegistry,
+	}
+}
+
+// 设置查找Group的Registry，默认使用包级别的Registry；需要在开始服务之前调用
+// 同一个进程中的多个节点各自使用一个Registry，互不影响
+func (hp *HttpPool) SetRegistry(registry *Registry) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	hp.registry = registry
+}
+
+func (hp *HttpPool) getGroup(name string) *Group {
+	hp.mutex.Lock()
+	registry := hp.registry
+	hp.mutex.Unlock()
+	return registry.GetGroup(name)
+}
+
+// 设置访问其他节点的客户端，例如使用TLS；需要在Set之前调用
+func (hp *HttpPool) SetClient(client *http.Client) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	hp.client = client
+}
+
+// 设置节点之间共享的令牌，请求其他节点时带上，写入、删除等修改本节点状态的请求需要校验；需要在Set之前调用
+// 使用mTLS时校验过客户端证书的请求不需要令牌
+func (hp *HttpPool) SetPeerToken(token string) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	hp.peerToken = token
+}
+
+// 请求是否来自可信的节点：客户端证书已经通过CA校验，或者带有节点之间共享的令牌
+func (hp *HttpPool) authenticated(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	hp.mutex.Lock()
+	token := hp.peerToken
+	hp.mutex.Unlock()
+	return TokenAuth(token)(r)
+}
+
+// 发起请求的节点，即PEER_HEADER，只有请求通过校验时才返回
+// 使用客户端证书时，该节点的主机名需要在证书中；使用令牌时，持有令牌的节点都是可信的
+func (hp *HttpPool) authenticatedPeer(r *http.Request) (string, bool) {
+	peer := r.Header.Get(PEER_HEADER)
+	if peer == "" {
+		return "", false
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if u, err := url.Parse(peer); err == nil && r.TLS.VerifiedChains[0][0].VerifyHostname(u.Hostname()) == nil {
+			return peer, true
+		}
+	}
+	hp.mutex.Lock()
+	token := hp.peerToken
+	hp.mutex.Unlock()
+	if TokenAuth(token)(r) {
+		return peer, true
+	}
+	return "", false
+}
+
+// 创建发往其他节点的请求，带上本节点和令牌
+func (hp *HttpPool) newPeerRequest(ctx context.Context, method, u string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	hp.mutex.Lock()
+	setPeerHeader(req, hp.hostPort, hp.peerToken)
+	hp.mutex.Unlock()
+	return req, nil
+}
+
+func setPeerHeader(req *http.Request, self, token string) {
+	if self != "" {
+		req.Header.Set(PEER_HEADER, self)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// 设置副本数，读取时可以从任意健康的副本读取，写入和删除会发送到所有副本
+func (hp *HttpPool) SetReplicas(n int) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	if n < 1 {
+		n = 1
+	}
+	hp.replicas = n
+}
+
+// 设置放置算法，之后Set的节点生效；所有节点需要使用相同的算法
+func (hp *HttpPool) SetPlacement(placement consistenthash.PlacementFunc) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	hp.placement = placement
+}
+
+// 有界负载的一致性哈希，以本节点向其他节点正在进行的请求数作为负载
+// 本节点的负载总是0，即本节点不会因为负载被跳过
+// 各节点的负载不同，负载只影响请求发往哪个节点；归属、交接、租约和Owner仍然按照哈希环
+func (hp *HttpPool) BoundedLoad(factor float64) consistenthash.PlacementFunc {
+	return func(weights map[string]int) consistenthash.Placement {
+		return consistenthash.NewBoundedLoad(REPLICS_PEERS, nil, weights, factor, hp.peerLoad)
+	}
+}
+
+func (hp *HttpPool) peerLoad(peer string) int64 {
+	if inflight, ok := hp.loads.Load(peer); ok {
+		return inflight.(*atomic.Int64).Load()
 	}
+	return 0
 }
 
-// 添加节点
+// 调用时已经持有锁
+func (hp *HttpPool) place(weights map[string]int) consistenthash.Placement {
+	if hp.placement == nil {
+		return newRing(weights)
+	}
+	return hp.placement(weights)
+}
+
+// 添加节点，每个节点的权重相同
 func (hp *HttpPool) Set(peers ...string) {
+	weights := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		weights[peer] = 1
+	}
+	hp.SetWeighted(weights)
+}
+
+// 添加带权重的节点，节点在哈希环上的虚拟节点数与权重成正比，例如按照内存大小配置
+// 之前离开的节点不再可以通过加入的通知加回，以新的节点列表为准
+func (hp *HttpPool) SetWeighted(weights map[string]int) {
 	hp.mutex.Lock()
 	defer hp.mutex.Unlock()
 
-	hp.peersMap = consistenthash.New(REPLICS_PEERS, nil)
-	hp.peersMap.Add(peers...)
+	hp.left = nil
+	hp.setWeighted(weights)
+}
+
+// 调用时已经持有锁
+func (hp *HttpPool) setWeighted(weights map[string]int) {
+	peers := make([]string, 0, len(weights))
+	hp.weights = make(map[string]int, len(weights))
+	for peer, weight := range weights {
+		peers = append(peers, peer)
+		hp.weights[peer] = weight
+	}
+	hp.peersMap = hp.place(hp.weights)
 
-	hp.httpGetters = make(map[string]*httpGetter)
+	// 保留已有节点的健康状态
+	httpGetters := make(map[string]*httpGetter)
 	for _, peer := range peers {
-		hp.httpGetters[peer] = &httpGetter{
-			baseUrl: peer + hp.basepath,
+		if getter, ok := hp.httpGetters[peer]; ok {
+			httpGetters[peer] = getter
+			continue
+		}
+		inflight, _ := hp.loads.LoadOrStore(peer, &atomic.Int64{})
+		httpGetters[peer] = &httpGetter{
+			baseUrl:  peer + hp.basepath,
+			self:     hp.hostPort,
+			token:    hp.peerToken,
+			health:   &peerHealth{},
+			inflight: inflight.(*atomic.Int64),
+			client:   hp.client,
 		}
 	}
+	hp.httpGetters = httpGetters
 }
 
 // 实现PeerPicker接口，根据key，找到对应的节点，然后根据节点，找到对应的PeerGetter
@@ -56,6 +257,9 @@ func (hp *HttpPool) PickPeer(key string) (peers.PeerGetter, bool) {
 	hp.mutex.Lock()
 	defer hp.mutex.Unlock()
 
+	if hp.peersMap == nil {
+		return nil, false
+	}
 	peer := hp.peersMap.Get(key)
 	if peer != "" && peer != hp.hostPort {
 		return hp.httpGetters[peer], true
@@ -63,6 +267,233 @@ func (hp *HttpPool) PickPeer(key string) (peers.PeerGetter, bool) {
 	return nil, false
 }
 
+// 实现ownerPicker接口，使用不依赖负载的放置
+func (hp *HttpPool) owner(key string) string {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	if hp.peersMap == nil {
+		return ""
+	}
+	return consistenthash.Stable(hp.peersMap).Get(key)
+}
+
+// 实现ReplicaPicker接口，按照哈希环上的顺序返回副本，不健康的节点排在后面，本节点对应nil
+func (hp *HttpPool) PickReplicas(key string) []peers.PeerGetter {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	if hp.peersMap == nil {
+		return nil
+	}
+	return orderReplicas(hp.hostPort, hp.peersMap.GetN(key, hp.replicas), hp.getterLocked)
+}
+
+// 调用时已经持有锁
+func (hp *HttpPool) getterLocked(peer string) (peers.PeerGetter, *peerHealth, bool) {
+	getter, ok := hp.httpGetters[peer]
+	if !ok {
+		return nil, nil, false
+	}
+	return getter, getter.health, true
+}
+
+// 设置命名的节点子集，已经存在时更新其中的节点，节点需要带有协议，例如 http://127.0.0.1:8001
+// 子集作为Group的PeerPicker，使Group只分布在这些节点上；子集使用创建或者更新时HttpPool的放置算法
+func (hp *HttpPool) SetSubset(name string, weights map[string]int) *Subset {
+	return hp.subsets.set(hp, name, weights)
+}
+
+// 命名的节点子集，不存在时返回nil
+func (hp *HttpPool) Subset(name string) *Subset {
+	return hp.subsets.get(name)
+}
+
+// 以下实现peerSource接口
+func (hp *HttpPool) selfPeer() string {
+	return hp.hostPort
+}
+
+func (hp *HttpPool) placeSubset(weights map[string]int) consistenthash.Placement {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+	return hp.place(weights)
+}
+
+func (hp *HttpPool) subsetGetter(peer string) (peers.PeerGetter, *peerHealth, bool) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+	return hp.getterLocked(peer)
+}
+
+func (hp *HttpPool) replicaCount() int {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+	return hp.replicas
+}
+
+func newRing(weights map[string]int) consistenthash.Placement {
+	return consistenthash.Ring(REPLICS_PEERS, nil)(weights)
+}
+
+// 节点及其权重编码到请求参数中，对方据此构造相同的哈希环
+func encodeWeights(query url.Values, weights map[string]int) {
+	for peer, weight := range weights {
+		query.Add("peers", peer)
+		query.Add("weights", strconv.Itoa(weight))
+	}
+}
+
+func decodeWeights(query url.Values) map[string]int {
+	peers, weights := query["peers"], query["weights"]
+	result := make(map[string]int, len(peers))
+	for i, peer := range peers {
+		weight := 1
+		if i < len(weights) {
+			weight, _ = strconv.Atoi(weights[i])
+		}
+		result[peer] = weight
+	}
+	return result
+}
+
+// 实现PreviousPeerPicker接口，预热期间根据本节点加入之前的一致性哈希，找到之前的节点
+func (hp *HttpPool) PickPreviousPeer(key string) (peers.PeerGetter, bool) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	if hp.previousMap == nil || time.Now().After(hp.warmupUntil) {
+		return nil, false
+	}
+	peer := hp.previousMap.Get(key)
+	if peer == "" || peer == hp.hostPort {
+		return nil, false
+	}
+	return &httpGetter{baseUrl: peer + hp.basepath + peekPath + "/", self: hp.hostPort, token: hp.peerToken, client: hp.client}, true
+}
+
+// 本节点加入集群后调用，从之前的节点拉取现在属于本节点的最近使用的limit个缓存（limit为0时不限制）
+// 之后的WARMUP_WINDOW时间内，未命中的key先去之前的节点的缓存中查找
+func (hp *HttpPool) Warmup(ctx context.Context, group *Group, limit int) error {
+	hp.mutex.Lock()
+	var others []string
+	previous := make(map[string]int)
+	for peer, weight := range hp.weights {
+		if peer != hp.hostPort {
+			others = append(others, peer)
+			previous[peer] = weight
+		}
+	}
+	if len(others) == 0 {
+		hp.mutex.Unlock()
+		return nil
+	}
+	hp.previousMap = consistenthash.Stable(hp.place(previous))
+	hp.warmupUntil = time.Now().Add(WARMUP_WINDOW)
+	query := url.Values{
+		"peer":  {hp.hostPort},
+		"limit": {strconv.Itoa(limit)},
+	}
+	encodeWeights(query, hp.weights)
+	client := hp.client
+	hp.mutex.Unlock()
+
+	var firstErr error
+	for _, peer := range others {
+		u := fmt.Sprintf("%v%v%v/%v?%v", peer, hp.basepath, handoffPath, url.PathEscape(group.Name()), query.Encode())
+		n, err := hp.fetchHandoff(ctx, client, u, group)
+		if err != nil {
+			errorf("HttpPool.Warmup | peer: %v, err: %+v\n", peer, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		infof("HttpPool.Warmup | peer: %v, group: %v, entries: %v\n", peer, group.Name(), n)
+	}
+	return firstErr
+}
+
+func (hp *HttpPool) fetchHandoff(ctx context.Context, client *http.Client, u string, group *Group) (int, error) {
+	req, err := hp.newPeerRequest(ctx, http.MethodGet, u)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned: %v", resp.Status)
+	}
+	return group.Restore(resp.Body)
+}
+
+// 返回本地缓存中，根据请求中的节点列表属于请求节点的数据，只接受请求节点自己发起的、通过校验的请求
+// 节点列表中没有请求节点时忽略该列表，使用本节点的节点列表
+func (p *HttpPool) serveHandoff(w http.ResponseWriter, r *http.Request, groupname string) {
+	g := p.getGroup(groupname)
+	if g == nil {
+		http.Error(w, "no such group: "+groupname, http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	target := query.Get("peer")
+	if caller, ok := p.authenticatedPeer(r); !ok || target == "" || caller != target {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	weights := decodeWeights(query)
+	p.mutex.Lock()
+	if weights[target] <= 0 {
+		weights = p.weights
+	}
+	ring := consistenthash.Stable(p.place(weights))
+	p.mutex.Unlock()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	err := g.snapshot(w, func(key string) bool {
+		return ring.Get(key) == target
+	}, limit)
+	if err != nil {
+		errorf("HttpPool.serveHandoff | group: %v, err: %+v\n", groupname, err)
+	}
+}
+
+// 只查找本地缓存，不加载，也不影响淘汰顺序；只接受通过校验的节点的请求
+func (p *HttpPool) servePeek(w http.ResponseWriter, r *http.Request, groupname string, key string) {
+	if _, ok := p.authenticatedPeer(r); !ok {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	g := p.getGroup(groupname)
+	if g == nil {
+		http.Error(w, "no such group: "+groupname, http.StatusNotFound)
+		return
+	}
+	item, ok := g.mainCache.peek(key)
+	if !ok || item.expired(time.Now()) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	p.writeValue(w, item)
+}
+
+func (p *HttpPool) writeValue(w http.ResponseWriter, item ByteData) {
+	body, err := proto.Marshal(&pb.Response{Value: item.ByteSlice()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
 //  1. 解析url，拿到groupname和key
 //     判断path是否是以/_geecache/为前缀的。 否，则panic
 //  2. 根据group和key，获取到对应的value，然后写到writer中
@@ -75,36 +506,137 @@ func (p *HttpPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	path := r.URL.Path
 	// /_marscache/scores/Tom
-	log.Printf("HttpPool.ServeHTTP | path:%v\n", path[len(CACHE_BASE_PATH):])
+	debugf("HttpPool.ServeHTTP | path:%v\n", path[len(CACHE_BASE_PATH):])
 	parts := strings.SplitN(path[len(CACHE_BASE_PATH):], "/", 2)
 
+	// 先限流再路由，内部接口也受限流保护
+	if !p.admit(w, r, parts) {
+		return
+	}
+
+	switch parts[0] {
+	case handoffPath:
+		if len(parts) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		p.serveHandoff(w, r, parts[1])
+		return
+	case peekPath:
+		if len(parts) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		parts = strings.SplitN(parts[1], "/", 2)
+		if len(parts) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		p.servePeek(w, r, parts[0], parts[1])
+		return
+	case leavePath:
+		p.serveLeave(w, r)
+		return
+	case joinPath:
+		p.serveJoin(w, r)
+		return
+	case leasePath:
+		if len(parts) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		p.serveLease(w, r, parts[1])
+		return
+	case peersPath, ownerPath, ringPath:
+		p.serveTopology(w, r, parts[0])
+		return
+	}
+
+	if len(parts) != 2 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
 	groupname := parts[0]
 	key := parts[1]
 
-	log.Printf("HttpPool.ServeHTTP | group_name: %v, key: %v\n", groupname, key)
+	debugf("HttpPool.ServeHTTP | group_name: %v, key: %v\n", groupname, key)
 
-	g := GetGroup(groupname)
+	g := p.getGroup(groupname)
+	if g == nil {
+		http.Error(w, "no such group: "+groupname, http.StatusNotFound)
+		return
+	}
 
-	item, err := g.Get(key)
-	if err != nil {
-		log.Printf("HttpPool.ServeHTTP | g.Get | key: %v, err: %+v\n", key, err)
+	// 写入和删除只接受可信的节点
+	if (r.Method == http.MethodPut || r.Method == http.MethodDelete) && !p.authenticated(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
 
-	body, err := proto.Marshal(&pb.Response{Value: item.ByteSlice()})
+	switch r.Method {
+	case http.MethodPut:
+		p.serveSet(w, r, g, key)
+		return
+	case http.MethodDelete:
+		g.removeLocally(key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// 来自其他节点的请求，只在本节点加载，不再转发，避免节点之间循环请求
+	item, err := g.getLocally(key)
 	if err != nil {
+		errorf("HttpPool.ServeHTTP | g.getLocally | key: %v, err: %+v\n", key, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(body)
+	p.writeValue(w, item)
+}
+
+func (p *HttpPool) serveSet(w http.ResponseWriter, r *http.Request, g *Group, key string) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	value := &pb.Response{}
+	if err := proto.Unmarshal(b, value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.setLocally(key, value.GetValue())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 节点的健康状态，请求失败后的一段时间内视为不健康
+type peerHealth struct {
+	unhealthyUntil atomic.Int64 // UnixNano
+}
+
+func (ph *peerHealth) healthy(now time.Time) bool {
+	return ph == nil || now.UnixNano() >= ph.unhealthyUntil.Load()
+}
 
+func (ph *peerHealth) report(err error) {
+	if ph == nil {
+		return
+	}
+	if err != nil {
+		ph.unhealthyUntil.Store(time.Now().Add(UNHEALTHY_COOLDOWN).UnixNano())
+	} else {
+		ph.unhealthyUntil.Store(0)
+	}
 }
 
-// 客户端实现PeerGetter接口
+// 客户端实现PeerGetter、PeerWriter接口
 type httpGetter struct {
-	baseUrl string // 例如：http://127.0.0.1/_marscache/
+	baseUrl  string // 例如：http://127.0.0.1/_marscache/
+	self     string // 本节点，写入PEER_HEADER
+	token    string // 节点之间共享的令牌，写入Authorization
+	health   *peerHealth
+	inflight *atomic.Int64 // 正在进行的请求数，为nil时不统计
+	client   *http.Client
 }
 
 // 1. 拼接url，执行请求
@@ -134,13 +666,41 @@ type httpGetter struct {
 
 // }
 
-func (hg *httpGetter) Get(in *pb.Request, out *pb.Response) error {
+func (hg *httpGetter) url(in *pb.Request) string {
 	// url.QueryEscape对string进行转义
-	url := fmt.Sprintf("%v%v/%v", hg.baseUrl, url.QueryEscape(in.GetGroup()), url.QueryEscape(in.GetKey()))
+	return fmt.Sprintf("%v%v/%v", hg.baseUrl, url.QueryEscape(in.GetGroup()), url.QueryEscape(in.GetKey()))
+}
 
-	log.Printf("httpGetter.Get | url: %v\n", url)
+// 发送请求，网络错误和5xx视为节点不健康
+func (hg *httpGetter) do(req *http.Request) (*http.Response, error) {
+	setPeerHeader(req, hg.self, hg.token)
+	if hg.inflight != nil {
+		hg.inflight.Add(1)
+		defer hg.inflight.Add(-1)
+	}
+	resp, err := hg.client.Do(req)
+	if err != nil {
+		hg.health.report(err)
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		hg.health.report(fmt.Errorf("server returned: %v", resp.Status))
+	} else {
+		hg.health.report(nil)
+	}
+	return resp, nil
+}
+
+func (hg *httpGetter) Get(in *pb.Request, out *pb.Response) error {
+	url := hg.url(in)
 
-	resp, err := http.Get(url)
+	debugf("httpGetter.Get | url: %v\n", url)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := hg.do(req)
 	if err != nil {
 		return err
 	}
@@ -161,3 +721,37 @@ func (hg *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 
 	return nil
 }
+
+func (hg *httpGetter) Set(in *pb.Request, value *pb.Response) error {
+	body, err := proto.Marshal(value)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, hg.url(in), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return hg.send(req)
+}
+
+func (hg *httpGetter) Delete(in *pb.Request) error {
+	req, err := http.NewRequest(http.MethodDelete, hg.url(in), nil)
+	if err != nil {
+		return err
+	}
+	return hg.send(req)
+}
+
+func (hg *httpGetter) send(req *http.Request) error {
+	resp, err := hg.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("server returned: %v", resp.Status)
+	}
+	return nil
+}
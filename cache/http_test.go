@@ -0,0 +1,359 @@
+package cache
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gy0117/gocache/consistenthash"
+	"github.com/gy0117/gocache/pb"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestWarmup(t *testing.T) {
+	convey.Convey("TestWarmup", t, func() {
+		getter := GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key + "-db"), nil
+		})
+
+		// 旧节点，缓存中有100个key
+		old := NewGroup("warmup-old", 0, getter)
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key-%03d", i)
+			old.put(key, ByteData{data: []byte(key + "-cached")})
+		}
+		oldPool := NewHttpPool("")
+		oldPool.SetPeerToken("peer-secret")
+		server := httptest.NewServer(oldPool)
+		defer server.Close()
+		oldPool.hostPort = server.URL
+		joinerPool := NewHttpPool("http://joiner")
+		joinerPool.SetPeerToken("peer-secret")
+
+		joiner := "http://joiner"
+		weights := map[string]int{server.URL: 1, joiner: 2}
+		ring := newRing(weights)
+		query := url.Values{"peer": {joiner}}
+		encodeWeights(query, weights)
+
+		convey.Convey("handoff streams only the keys owned by the joining node", func() {
+			fresh := NewGroup("warmup-fresh", 0, getter)
+			u := fmt.Sprintf("%v%v%v/%v?%v", server.URL, CACHE_BASE_PATH, handoffPath, old.Name(), query.Encode())
+			n, err := joinerPool.fetchHandoff(context.Background(), http.DefaultClient, u, fresh)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(n, convey.ShouldBeGreaterThan, 0)
+
+			for _, key := range fresh.mainCache.keys() {
+				convey.So(ring.Get(key), convey.ShouldEqual, joiner)
+			}
+			owned := 0
+			for i := 0; i < 100; i++ {
+				if ring.Get(fmt.Sprintf("key-%03d", i)) == joiner {
+					owned++
+				}
+			}
+			convey.So(n, convey.ShouldEqual, owned)
+		})
+
+		convey.Convey("handoff honours the limit", func() {
+			fresh := NewGroup("warmup-limit", 0, getter)
+			query.Set("limit", "3")
+			u := fmt.Sprintf("%v%v%v/%v?%v", server.URL, CACHE_BASE_PATH, handoffPath, old.Name(), query.Encode())
+			n, err := joinerPool.fetchHandoff(context.Background(), http.DefaultClient, u, fresh)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(n, convey.ShouldEqual, 3)
+		})
+
+		convey.Convey("handoff rejects unauthenticated or spoofed peers", func() {
+			fresh := NewGroup("warmup-spoofed", 0, getter)
+			u := fmt.Sprintf("%v%v%v/%v?%v", server.URL, CACHE_BASE_PATH, handoffPath, old.Name(), query.Encode())
+			_, err := NewHttpPool(joiner).fetchHandoff(context.Background(), http.DefaultClient, u, fresh)
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(err.Error(), convey.ShouldContainSubstring, "403")
+
+			// 令牌正确，但是peer不是请求节点
+			other := NewHttpPool("http://other")
+			other.SetPeerToken("peer-secret")
+			_, err = other.fetchHandoff(context.Background(), http.DefaultClient, u, fresh)
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(err.Error(), convey.ShouldContainSubstring, "403")
+			convey.So(fresh.mainCache.keys(), convey.ShouldBeEmpty)
+		})
+
+		convey.Convey("handoff ignores peer lists without the requesting peer", func() {
+			// 请求中的节点列表没有joiner，按照本节点的节点列表返回属于joiner的数据
+			oldPool.SetWeighted(weights)
+			fresh := NewGroup("warmup-foreign", 0, getter)
+			foreign := url.Values{"peer": {joiner}}
+			encodeWeights(foreign, map[string]int{server.URL: 1})
+			u := fmt.Sprintf("%v%v%v/%v?%v", server.URL, CACHE_BASE_PATH, handoffPath, old.Name(), foreign.Encode())
+			n, err := joinerPool.fetchHandoff(context.Background(), http.DefaultClient, u, fresh)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(n, convey.ShouldBeGreaterThan, 0)
+			for _, key := range fresh.mainCache.keys() {
+				convey.So(ring.Get(key), convey.ShouldEqual, joiner)
+			}
+		})
+
+		convey.Convey("misses during warmup consult the previous owner", func() {
+			pool := NewHttpPool(joiner)
+			pool.SetPeerToken("peer-secret")
+			pool.SetWeighted(weights)
+
+			var key string
+			for i := 0; i < 100; i++ {
+				if ring.Get(fmt.Sprintf("key-%03d", i)) == joiner {
+					key = fmt.Sprintf("key-%03d", i)
+					break
+				}
+			}
+			_, ok := pool.PickPreviousPeer(key)
+			convey.So(ok, convey.ShouldBeFalse)
+
+			convey.So(pool.Warmup(context.Background(), old, 0), convey.ShouldBeNil)
+
+			peer, ok := pool.PickPreviousPeer(key)
+			convey.So(ok, convey.ShouldBeTrue)
+			resp := &pb.Response{}
+			err := peer.Get(&pb.Request{Group: old.Name(), Key: key}, resp)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(resp.Value), convey.ShouldEqual, key+"-cached")
+
+			// 之前的节点没有缓存时不会加载
+			err = peer.Get(&pb.Request{Group: old.Name(), Key: "missing"}, resp)
+			convey.So(err, convey.ShouldNotBeNil)
+		})
+	})
+}
+
+func TestReplicas(t *testing.T) {
+	convey.Convey("TestReplicas", t, func() {
+		loads := 0
+		g := NewGroup("replicas", 0, GetterFunc(func(key string) ([]byte, error) {
+			loads++
+			return []byte(key + "-db"), nil
+		}))
+
+		remotePool := NewHttpPool("")
+		remotePool.SetPeerToken("peer-secret")
+		server := httptest.NewServer(remotePool)
+		defer server.Close()
+
+		self := "http://self"
+		dead := "http://127.0.0.1:1"
+		pool := NewHttpPool(self)
+		pool.SetPeerToken("peer-secret")
+		pool.Set(server.URL, dead, self)
+		pool.SetReplicas(3)
+
+		replicas := pool.PickReplicas("zhangsan")
+		convey.So(len(replicas), convey.ShouldEqual, 3)
+
+		convey.Convey("unhealthy replicas are tried last", func() {
+			deadGetter := pool.httpGetters[dead]
+			err := deadGetter.Get(&pb.Request{Group: g.Name(), Key: "zhangsan"}, &pb.Response{})
+			convey.So(err, convey.ShouldNotBeNil)
+
+			replicas := pool.PickReplicas("zhangsan")
+			convey.So(replicas[len(replicas)-1], convey.ShouldEqual, deadGetter)
+		})
+
+		convey.Convey("set and delete go to the remote replica", func() {
+			remote := pool.httpGetters[server.URL]
+			req := &pb.Request{Group: g.Name(), Key: "lisi"}
+			convey.So(remote.Set(req, &pb.Response{Value: []byte("200")}), convey.ShouldBeNil)
+
+			value, ok := g.mainCache.get("lisi")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(value.String(), convey.ShouldEqual, "200")
+
+			convey.So(remote.Delete(req), convey.ShouldBeNil)
+			_, ok = g.mainCache.get("lisi")
+			convey.So(ok, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("set and delete without the peer token are rejected", func() {
+			g.put("lisi", ByteData{data: []byte("200")})
+			req := &pb.Request{Group: g.Name(), Key: "lisi"}
+			for _, token := range []string{"", "wrong"} {
+				stranger := &httpGetter{baseUrl: server.URL + CACHE_BASE_PATH, token: token, client: http.DefaultClient}
+				convey.So(stranger.Set(req, &pb.Response{Value: []byte("300")}), convey.ShouldNotBeNil)
+				convey.So(stranger.Delete(req), convey.ShouldNotBeNil)
+			}
+
+			value, ok := g.mainCache.get("lisi")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(value.String(), convey.ShouldEqual, "200")
+		})
+
+		convey.Convey("verified client certificates are trusted without the token", func() {
+			r := httptest.NewRequest(http.MethodPut, "/", nil)
+			convey.So(remotePool.authenticated(r), convey.ShouldBeFalse)
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{DNSNames: []string{"node1"}}}}}
+			convey.So(remotePool.authenticated(r), convey.ShouldBeTrue)
+
+			// 节点的主机名需要在证书中
+			r.Header.Set(PEER_HEADER, "https://node1:8001")
+			peer, ok := remotePool.authenticatedPeer(r)
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(peer, convey.ShouldEqual, "https://node1:8001")
+			r.Header.Set(PEER_HEADER, "https://node2:8001")
+			_, ok = remotePool.authenticatedPeer(r)
+			convey.So(ok, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("peer requests load locally without forwarding", func() {
+			resp := &pb.Response{}
+			err := pool.httpGetters[server.URL].Get(&pb.Request{Group: g.Name(), Key: "wangwu"}, resp)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(resp.Value), convey.ShouldEqual, "wangwu-db")
+			convey.So(loads, convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("group writes reach every replica including itself", func() {
+			local := NewGroup("replicas-writer", 0, GetterFunc(func(key string) ([]byte, error) {
+				return nil, fmt.Errorf("%s not exist", key)
+			}))
+			local.RegisterPeerPicker(pool)
+
+			// dead节点写入失败，其他副本仍然写入
+			err := local.Set("zhaoliu", []byte("400"))
+			convey.So(err, convey.ShouldNotBeNil)
+			value, ok := local.mainCache.get("zhaoliu")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(value.String(), convey.ShouldEqual, "400")
+
+			local.Remove("zhaoliu")
+			_, ok = local.mainCache.get("zhaoliu")
+			convey.So(ok, convey.ShouldBeFalse)
+		})
+	})
+}
+
+func TestPlacement(t *testing.T) {
+	convey.Convey("TestPlacement", t, func() {
+		peers := []string{"http://10.0.0.1:8001", "http://10.0.0.2:8001", "http://10.0.0.3:8001"}
+		weights := map[string]int{peers[0]: 1, peers[1]: 1, peers[2]: 1}
+
+		convey.Convey("pool uses the configured placement", func() {
+			pool := NewHttpPool(peers[0])
+			pool.SetPlacement(func(weights map[string]int) consistenthash.Placement {
+				return consistenthash.NewRendezvous(weights)
+			})
+			pool.Set(peers...)
+
+			want := consistenthash.NewRendezvous(weights)
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				getter, ok := pool.PickPeer(key)
+				convey.So(ok, convey.ShouldEqual, want.Get(key) != peers[0])
+				if ok {
+					convey.So(getter.(*httpGetter).baseUrl, convey.ShouldEqual, want.Get(key)+CACHE_BASE_PATH)
+				}
+			}
+		})
+
+		convey.Convey("bounded load skips peers with too many requests in flight", func() {
+			pool := NewHttpPool(peers[0])
+			pool.SetPlacement(pool.BoundedLoad(1.25))
+			pool.Set(peers...)
+
+			var key string
+			for i := 0; ; i++ {
+				key = fmt.Sprintf("key-%d", i)
+				if newRing(weights).Get(key) == peers[1] {
+					break
+				}
+			}
+			getter, ok := pool.PickPeer(key)
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(getter.(*httpGetter).baseUrl, convey.ShouldEqual, peers[1]+CACHE_BASE_PATH)
+
+			pool.httpGetters[peers[1]].inflight.Add(10)
+			getter, ok = pool.PickPeer(key)
+			if ok {
+				convey.So(getter.(*httpGetter).baseUrl, convey.ShouldNotEqual, peers[1]+CACHE_BASE_PATH)
+			}
+			replicas := pool.PickReplicas(key)
+			convey.So(replicas, convey.ShouldHaveLength, 1)
+			convey.So(replicas[0], convey.ShouldNotEqual, pool.httpGetters[peers[1]])
+		})
+
+		convey.Convey("bounded load only routes, ownership stays on the ring", func() {
+			pool := NewHttpPool(peers[2])
+			pool.SetPlacement(pool.BoundedLoad(1.25))
+			pool.Set(peers...)
+			g := NewGroup("bounded-owner", 0, GetterFunc(func(key string) ([]byte, error) {
+				return []byte(key), nil
+			}))
+			g.RegisterPeerPicker(pool)
+
+			var key string
+			for i := 0; ; i++ {
+				key = fmt.Sprintf("key-%d", i)
+				if newRing(weights).Get(key) == peers[1] {
+					break
+				}
+			}
+
+			// 其他节点都超载时请求留在本节点，但key仍然属于哈希环上的节点
+			pool.httpGetters[peers[0]].inflight.Add(10)
+			pool.httpGetters[peers[1]].inflight.Add(10)
+			_, ok := pool.PickPeer(key)
+			convey.So(ok, convey.ShouldBeFalse)
+			convey.So(g.owns(key), convey.ShouldBeFalse)
+			convey.So(pool.Owner("bounded-owner", key).Replicas, convey.ShouldResemble, []string{peers[1]})
+			convey.So(pool.leasePeers(key)[0], convey.ShouldEqual, pool.httpGetters[peers[1]])
+		})
+	})
+}
+
+// 同一个进程中的两个节点，各自使用一个Registry，Group的名称相同
+func TestRegistryNodes(t *testing.T) {
+	convey.Convey("TestRegistryNodes", t, func() {
+		servers := make([]*httptest.Server, 2)
+		addrs := make([]string, 2)
+		for i := range servers {
+			servers[i] = httptest.NewUnstartedServer(nil)
+			addrs[i] = "http://" + servers[i].Listener.Addr().String()
+		}
+
+		groups := make([]*Group, 2)
+		for i := range servers {
+			node := fmt.Sprintf("node%d", i)
+			registry := NewRegistry()
+			groups[i] = registry.NewGroup("registry-nodes", 0, GetterFunc(func(key string) ([]byte, error) {
+				return []byte(node + "-" + key), nil
+			}))
+
+			pool := NewHttpPool(addrs[i])
+			pool.SetRegistry(registry)
+			pool.Set(addrs...)
+			groups[i].RegisterPeerPicker(pool)
+			servers[i].Config.Handler = pool
+			servers[i].Start()
+			defer servers[i].Close()
+		}
+		convey.So(GetGroup("registry-nodes"), convey.ShouldBeNil)
+
+		// 每个key只由所属的节点加载
+		ring := newRing(map[string]int{addrs[0]: 1, addrs[1]: 1})
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			owner := "node0"
+			if ring.Get(key) == addrs[1] {
+				owner = "node1"
+			}
+			for _, g := range groups {
+				value, err := g.Get(key)
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(value.String(), convey.ShouldEqual, owner+"-"+key)
+			}
+		}
+	})
+}
@@ -0,0 +1,309 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gy0117/gocache/consistenthash"
+	"github.com/gy0117/gocache/pb"
+	"github.com/gy0117/gocache/peers"
+)
+
+// 等待租约的持有者时，两次重试之间的间隔
+const LEASE_RETRY_INTERVAL = 50 * time.Millisecond
+
+// 租约表超过这个大小时，申请租约的同时清理过期的租约
+const leaseSweepSize = 1024
+
+// 没有节点可以授予租约，例如PeerPicker使用gRPC
+var errNoLessor = errors.New("no peer can grant the lease")
+
+// 支持租约的PeerPicker
+type leasePicker interface {
+	selfPeer() string
+	// 可以授予key的租约的节点，依次尝试，本节点对应nil
+	leasePeers(key string) []peers.PeerGetter
+	// 节点对应的PeerGetter，用于从租约的持有者获取数据
+	peerGetter(peer string) (peers.PeerGetter, bool)
+}
+
+// 启用分布式租约，window是租约的有效期
+// 本节点需要从Getter加载时，先向key的主节点申请租约，主节点无法访问时依次向之后的节点申请
+// 租约期间同一个key只有持有者从Getter加载，其他节点有过期的数据时直接返回，没有时从持有者获取
+// 过期的数据在内存中保留到被淘汰为止；PeerPicker需要是HttpPool或者HttpPool的Subset
+func WithLease(window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.lease = window
+		g.mainCache.keepStale = window > 0
+	}
+}
+
+// 租约的有效期，为0表示没有启用
+func (g *Group) Lease() time.Duration {
+	return g.lease
+}
+
+// 在租约的保护下从Getter加载
+// 没有启用租约、PeerPicker不支持租约，或者可以授予租约的节点都无法访问时直接加载
+func (g *Group) loadLeased(picker peers.PeerPicker, key string) (ByteData, error) {
+	lp, ok := picker.(leasePicker)
+	if g.lease <= 0 || !ok {
+		return g.loadLocally(key)
+	}
+
+	self := lp.selfPeer()
+	req := &pb.Request{Group: g.name, Key: key}
+	// 持有者一直无法访问时，最多等待两个租约周期
+	deadline := time.Now().Add(2 * g.lease)
+	for {
+		granted, holder, lessor, err := g.acquireLease(lp.leasePeers(key), req, self)
+		if err != nil {
+			if err != errNoLessor {
+				errorf("Group.loadLeased | key: %v, err: %+v\n", key, err)
+			}
+			return g.loadLocally(key)
+		}
+		// 加载成功后租约保留到有效期结束，期间其他节点返回过期的数据或者从本节点获取，不再加载
+		// 加载失败时释放，其他节点可以立即重新申请
+		if granted {
+			bytedata, err := g.loadLocally(key)
+			if err != nil {
+				g.releaseLease(lessor, req, self)
+			}
+			return bytedata, err
+		}
+
+		g.stats.LeaseWaits.Add(1)
+		if stale, ok := g.mainCache.peek(key); ok {
+			g.stats.StaleHits.Add(1)
+			return stale, nil
+		}
+		// 持有者正在加载时，请求会合并到它的加载中
+		if peer, ok := lp.peerGetter(holder); ok {
+			if bytedata, err := g.loadFromPeer(peer, key); err == nil {
+				return bytedata, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return g.loadLocally(key)
+		}
+		time.Sleep(LEASE_RETRY_INTERVAL)
+	}
+}
+
+// 依次向lessors申请租约，返回是否授予、当前的持有者和授予租约的节点（本节点为nil）
+// lessors为空时由本节点授予
+func (g *Group) acquireLease(lessors []peers.PeerGetter, req *pb.Request, self string) (bool, string, peers.PeerGetter, error) {
+	if len(lessors) == 0 {
+		granted, holder := g.leases.acquire(req.Key, self, g.lease, time.Now())
+		return granted, holder, nil, nil
+	}
+
+	var errs []error
+	for _, peer := range lessors {
+		if peer == nil {
+			granted, holder := g.leases.acquire(req.Key, self, g.lease, time.Now())
+			return granted, holder, nil, nil
+		}
+		leaseGetter, ok := peer.(peers.LeaseGetter)
+		if !ok {
+			continue
+		}
+		granted, holder, err := leaseGetter.Acquire(req, self, g.lease)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return granted, holder, peer, nil
+	}
+	if len(errs) == 0 {
+		return false, "", nil, errNoLessor
+	}
+	return false, "", nil, fmt.Errorf("%w: %w", errNoLessor, errors.Join(errs...))
+}
+
+func (g *Group) releaseLease(lessor peers.PeerGetter, req *pb.Request, self string) {
+	if lessor == nil {
+		g.leases.release(req.Key, self)
+		return
+	}
+	if err := lessor.(peers.LeaseGetter).Release(req, self); err != nil {
+		// 释放失败时租约到期后自动失效
+		errorf("Group.releaseLease | key: %v, err: %+v\n", req.Key, err)
+	}
+}
+
+// 租约表，由授予租约的节点维护
+type leaseTable struct {
+	mutex sync.Mutex
+	m     map[string]lease
+}
+
+type lease struct {
+	holder string
+	until  time.Time
+}
+
+// 租约不存在、已经过期或者已经由holder持有时授予holder，否则返回当前的持有者
+func (lt *leaseTable) acquire(key, holder string, ttl time.Duration, now time.Time) (bool, string) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	if l, ok := lt.m[key]; ok && l.holder != holder && now.Before(l.until) {
+		return false, l.holder
+	}
+	if lt.m == nil {
+		lt.m = make(map[string]lease)
+	}
+	// 持有者崩溃时租约不会被释放
+	if len(lt.m) >= leaseSweepSize {
+		for k, l := range lt.m {
+			if !now.Before(l.until) {
+				delete(lt.m, k)
+			}
+		}
+	}
+	lt.m[key] = lease{holder: holder, until: now.Add(ttl)}
+	return true, holder
+}
+
+// 只释放holder持有的租约
+func (lt *leaseTable) release(key, holder string) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	if l, ok := lt.m[key]; ok && l.holder == holder {
+		delete(lt.m, key)
+	}
+}
+
+// 以下实现leasePicker接口
+// 授予租约的节点是key的副本以及哈希环上的下一个节点，主节点无法访问时仍然由同一个节点授予
+// 所有节点需要找到相同的授予者，有界负载时也按照哈希环，不受负载影响
+func (hp *HttpPool) leasePeers(key string) []peers.PeerGetter {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	if hp.peersMap == nil {
+		return nil
+	}
+	return orderReplicas(hp.hostPort, consistenthash.Stable(hp.peersMap).GetN(key, hp.replicas+1), hp.getterLocked)
+}
+
+func (hp *HttpPool) peerGetter(peer string) (peers.PeerGetter, bool) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	getter, ok := hp.httpGetters[peer]
+	if !ok {
+		return nil, false
+	}
+	return getter, true
+}
+
+func (s *Subset) selfPeer() string {
+	return s.source.selfPeer()
+}
+
+func (s *Subset) leasePeers(key string) []peers.PeerGetter {
+	peersMap := s.placement()
+	if peersMap == nil {
+		return nil
+	}
+	return orderReplicas(s.source.selfPeer(), consistenthash.Stable(peersMap).GetN(key, s.source.replicaCount()+1), s.source.subsetGetter)
+}
+
+func (s *Subset) peerGetter(peer string) (peers.PeerGetter, bool) {
+	getter, _, ok := s.source.subsetGetter(peer)
+	return getter, ok
+}
+
+// 申请租约的结果
+type leaseResult struct {
+	Granted bool   `json:"granted"`
+	Holder  string `json:"holder"` // 当前的持有者
+}
+
+// POST /_marscache/_lease/<group>/<key>?holder=&ttl= 申请租约
+// DELETE /_marscache/_lease/<group>/<key>?holder= 释放租约
+// 只接受holder自己发起的、通过校验的请求
+func (p *HttpPool) serveLease(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	g := p.getGroup(parts[0])
+	if g == nil {
+		http.Error(w, "no such group: "+parts[0], http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	holder := query.Get("holder")
+	if holder == "" {
+		http.Error(w, "holder is required", http.StatusBadRequest)
+		return
+	}
+	if caller, ok := p.authenticatedPeer(r); !ok || caller != holder {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		ttl, err := time.ParseDuration(query.Get("ttl"))
+		if err != nil || ttl <= 0 {
+			http.Error(w, "bad ttl", http.StatusBadRequest)
+			return
+		}
+		granted, current := g.leases.acquire(parts[1], holder, ttl, time.Now())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(leaseResult{Granted: granted, Holder: current})
+	case http.MethodDelete:
+		g.leases.release(parts[1], holder)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (hg *httpGetter) leaseUrl(in *pb.Request, query url.Values) string {
+	return fmt.Sprintf("%v%v/%v/%v?%v", hg.baseUrl, leasePath, url.PathEscape(in.GetGroup()), url.PathEscape(in.GetKey()), query.Encode())
+}
+
+// 实现LeaseGetter接口
+func (hg *httpGetter) Acquire(in *pb.Request, holder string, ttl time.Duration) (bool, string, error) {
+	query := url.Values{"holder": {holder}, "ttl": {ttl.String()}}
+	req, err := http.NewRequest(http.MethodPost, hg.leaseUrl(in, query), nil)
+	if err != nil {
+		return false, "", err
+	}
+	resp, err := hg.do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("server returned: %v", resp.Status)
+	}
+	var result leaseResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, "", fmt.Errorf("decoding lease response: %v", err)
+	}
+	return result.Granted, result.Holder, nil
+}
+
+func (hg *httpGetter) Release(in *pb.Request, holder string) error {
+	req, err := http.NewRequest(http.MethodDelete, hg.leaseUrl(in, url.Values{"holder": {holder}}), nil)
+	if err != nil {
+		return err
+	}
+	return hg.send(req)
+}
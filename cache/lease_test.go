@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gy0117/gocache/pb"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestLeaseTable(t *testing.T) {
+	convey.Convey("TestLeaseTable", t, func() {
+		var lt leaseTable
+		now := time.Now()
+
+		granted, holder := lt.acquire("key", "a", time.Second, now)
+		convey.So(granted, convey.ShouldBeTrue)
+		convey.So(holder, convey.ShouldEqual, "a")
+
+		// 其他节点不能获得，持有者可以重复申请
+		granted, holder = lt.acquire("key", "b", time.Second, now)
+		convey.So(granted, convey.ShouldBeFalse)
+		convey.So(holder, convey.ShouldEqual, "a")
+		granted, _ = lt.acquire("key", "a", time.Second, now)
+		convey.So(granted, convey.ShouldBeTrue)
+
+		// 只有持有者可以释放
+		lt.release("key", "b")
+		granted, _ = lt.acquire("key", "b", time.Second, now)
+		convey.So(granted, convey.ShouldBeFalse)
+		lt.release("key", "a")
+		granted, _ = lt.acquire("key", "b", time.Second, now)
+		convey.So(granted, convey.ShouldBeTrue)
+
+		// 过期之后可以被其他节点获得
+		granted, holder = lt.acquire("key", "a", time.Second, now.Add(time.Second))
+		convey.So(granted, convey.ShouldBeTrue)
+		convey.So(holder, convey.ShouldEqual, "a")
+	})
+}
+
+func TestLease(t *testing.T) {
+	convey.Convey("TestLease", t, func() {
+		servers := make([]*httptest.Server, 3)
+		addrs := make([]string, 3)
+		for i := range servers {
+			servers[i] = httptest.NewUnstartedServer(nil)
+			addrs[i] = "http://" + servers[i].Listener.Addr().String()
+		}
+
+		var loads atomic.Int32
+		groups := make([]*Group, 3)
+		for i := range servers {
+			registry := NewRegistry()
+			groups[i] = registry.NewGroup("lease", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+				loads.Add(1)
+				time.Sleep(100 * time.Millisecond)
+				return []byte("value-" + key), nil
+			}), WithLease(time.Second), WithTTL(50*time.Millisecond))
+
+			pool := NewHttpPool(addrs[i])
+			pool.SetRegistry(registry)
+			pool.SetPeerToken("peer-secret")
+			pool.Set(addrs...)
+			groups[i].RegisterPeerPicker(pool)
+			servers[i].Config.Handler = pool
+			servers[i].Start()
+			defer servers[i].Close()
+		}
+
+		// 主节点无法访问时，其他节点绕过主节点加载，同一个key只有一个节点调用Getter
+		key := "tom"
+		ring := newRing(map[string]int{addrs[0]: 1, addrs[1]: 1, addrs[2]: 1})
+		var others []*Group
+		for i, addr := range addrs {
+			if addr == ring.Get(key) {
+				servers[i].Close()
+				continue
+			}
+			others = append(others, groups[i])
+		}
+
+		var wg sync.WaitGroup
+		values := make([]string, len(others))
+		for i, g := range others {
+			wg.Add(1)
+			go func(i int, g *Group) {
+				defer wg.Done()
+				value, err := g.Get(key)
+				if err == nil {
+					values[i] = value.String()
+				}
+			}(i, g)
+		}
+		wg.Wait()
+
+		convey.So(loads.Load(), convey.ShouldEqual, 1)
+		for _, value := range values {
+			convey.So(value, convey.ShouldEqual, "value-tom")
+		}
+		waits := int64(0)
+		for _, g := range others {
+			waits += g.Stats().LeaseWaits
+		}
+		convey.So(waits, convey.ShouldEqual, 1)
+
+	})
+}
+
+func TestLeaseWait(t *testing.T) {
+	convey.Convey("TestLeaseWait", t, func() {
+		var loads atomic.Int32
+		registry := NewRegistry()
+		g := registry.NewGroup("lease-wait", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+			if key == "missing" {
+				return nil, fmt.Errorf("%s not exist", key)
+			}
+			loads.Add(1)
+			return []byte("new-" + key), nil
+		}), WithLease(time.Second), WithTTL(20*time.Millisecond))
+		pool := NewHttpPool("http://self")
+		pool.SetRegistry(registry)
+		pool.Set("http://self")
+		g.RegisterPeerPicker(pool)
+
+		convey.Convey("an expired value is returned while another node holds the lease", func() {
+			g.setLocally("tom", []byte("old-tom"))
+			time.Sleep(30 * time.Millisecond)
+			granted, _ := g.leases.acquire("tom", "http://other", time.Second, time.Now())
+			convey.So(granted, convey.ShouldBeTrue)
+
+			value, err := g.Get("tom")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(value.String(), convey.ShouldEqual, "old-tom")
+			convey.So(g.Stats().StaleHits, convey.ShouldEqual, 1)
+			convey.So(loads.Load(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("without an expired value, load after the lease expires", func() {
+			granted, _ := g.leases.acquire("tom", "http://other", 100*time.Millisecond, time.Now())
+			convey.So(granted, convey.ShouldBeTrue)
+
+			start := time.Now()
+			value, err := g.Get("tom")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(value.String(), convey.ShouldEqual, "new-tom")
+			convey.So(time.Since(start), convey.ShouldBeGreaterThanOrEqualTo, 100*time.Millisecond)
+			convey.So(g.Stats().LeaseWaits, convey.ShouldBeGreaterThan, 0)
+			convey.So(loads.Load(), convey.ShouldEqual, 1)
+
+			// 加载成功后租约保留到有效期结束
+			granted, holder := g.leases.acquire("tom", "http://other", time.Second, time.Now())
+			convey.So(granted, convey.ShouldBeFalse)
+			convey.So(holder, convey.ShouldEqual, "http://self")
+		})
+
+		convey.Convey("the lease is released when the load fails", func() {
+			_, err := g.Get("missing")
+			convey.So(err, convey.ShouldNotBeNil)
+			granted, _ := g.leases.acquire("missing", "http://other", time.Second, time.Now())
+			convey.So(granted, convey.ShouldBeTrue)
+		})
+	})
+}
+
+func TestLeaseRoute(t *testing.T) {
+	convey.Convey("TestLeaseRoute", t, func() {
+		registry := NewRegistry()
+		g := registry.NewGroup("lease-route", 0, GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+		pool := NewHttpPool("http://self")
+		pool.SetRegistry(registry)
+		pool.SetPeerToken("peer-secret")
+		server := httptest.NewServer(pool)
+		defer server.Close()
+
+		// 每个节点只能以自己的名义申请和释放
+		peer := func(self string) *httpGetter {
+			return &httpGetter{baseUrl: server.URL + CACHE_BASE_PATH, self: self, token: "peer-secret", client: server.Client()}
+		}
+		req := &pb.Request{Group: g.Name(), Key: "a b/c"}
+		_, _, err := peer("http://b").Acquire(req, "http://a", time.Second)
+		convey.So(err, convey.ShouldNotBeNil)
+		stranger := &httpGetter{baseUrl: server.URL + CACHE_BASE_PATH, self: "http://a", client: server.Client()}
+		_, _, err = stranger.Acquire(req, "http://a", time.Second)
+		convey.So(err, convey.ShouldNotBeNil)
+
+		getter := peer("http://a")
+		granted, holder, err := getter.Acquire(req, "http://a", time.Second)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(granted, convey.ShouldBeTrue)
+		convey.So(holder, convey.ShouldEqual, "http://a")
+
+		granted, holder, err = peer("http://b").Acquire(req, "http://b", time.Second)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(granted, convey.ShouldBeFalse)
+		convey.So(holder, convey.ShouldEqual, "http://a")
+
+		convey.So(peer("http://b").Release(req, "http://a"), convey.ShouldNotBeNil)
+		convey.So(getter.Release(req, "http://a"), convey.ShouldBeNil)
+		granted, _, err = peer("http://b").Acquire(req, "http://b", time.Second)
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(granted, convey.ShouldBeTrue)
+
+		_, _, err = getter.Acquire(&pb.Request{Group: "missing", Key: "k"}, "http://a", time.Second)
+		convey.So(err, convey.ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// 通知其他节点本节点离开集群，其他节点将本节点从节点列表中移除，不再把key分配给本节点
+// 本节点的节点列表不变，返回所有通知失败的错误
+func (hp *HttpPool) Leave(ctx context.Context) error {
+	return hp.announce(ctx, leavePath)
+}
+
+// 通知其他节点本节点重新加入集群，节点重启后调用
+// 之前因为本节点离开而把本节点移除的节点，按照原来的权重把本节点加回节点列表，返回所有通知失败的错误
+func (hp *HttpPool) Join(ctx context.Context) error {
+	return hp.announce(ctx, joinPath)
+}
+
+// 向其他所有节点发送离开或者加入的通知
+func (hp *HttpPool) announce(ctx context.Context, path string) error {
+	hp.mutex.Lock()
+	client := hp.client
+	peers := make([]string, 0, len(hp.weights))
+	for peer := range hp.weights {
+		if peer != hp.hostPort {
+			peers = append(peers, peer)
+		}
+	}
+	hp.mutex.Unlock()
+	sort.Strings(peers)
+
+	query := url.Values{"peer": {hp.hostPort}}
+	var errs []error
+	for _, peer := range peers {
+		u := peer + hp.basepath + path + "?" + query.Encode()
+		req, err := hp.newPeerRequest(ctx, http.MethodPost, u)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			errs = append(errs, fmt.Errorf("%v %v: %v", strings.TrimPrefix(path, "_"), peer, resp.Status))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 移除离开的节点，节点不存在或者是本节点时返回false
+func (hp *HttpPool) remove(peer string) bool {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	if _, ok := hp.weights[peer]; !ok || peer == hp.hostPort {
+		return false
+	}
+	weights := make(map[string]int, len(hp.weights)-1)
+	for p, weight := range hp.weights {
+		if p != peer {
+			weights[p] = weight
+		}
+	}
+	if hp.left == nil {
+		hp.left = make(map[string]int)
+	}
+	hp.left[peer] = hp.weights[peer]
+	hp.setWeighted(weights)
+	return true
+}
+
+// 加回之前离开的节点，added表示是否加回；节点既不在节点列表中也没有离开过时ok为false
+func (hp *HttpPool) rejoin(peer string) (added, ok bool) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	if _, ok := hp.weights[peer]; ok {
+		return false, true
+	}
+	weight, ok := hp.left[peer]
+	if !ok {
+		return false, false
+	}
+	weights := make(map[string]int, len(hp.weights)+1)
+	for p, w := range hp.weights {
+		weights[p] = w
+	}
+	weights[peer] = weight
+	delete(hp.left, peer)
+	hp.setWeighted(weights)
+	return true, true
+}
+
+// 校验离开或者加入的通知，只接受该节点自己发起的、通过校验的通知，返回该节点
+func (p *HttpPool) announcedPeer(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	peer := r.URL.Query().Get("peer")
+	if peer == "" {
+		http.Error(w, "peer is required", http.StatusBadRequest)
+		return "", false
+	}
+	if caller, ok := p.authenticatedPeer(r); !ok || caller != peer {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return peer, true
+}
+
+// 重复的通知也返回成功
+func (p *HttpPool) serveLeave(w http.ResponseWriter, r *http.Request) {
+	peer, ok := p.announcedPeer(w, r)
+	if !ok {
+		return
+	}
+	if p.remove(peer) {
+		infof("HttpPool.serveLeave | peer %v left\n", peer)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 已经在节点列表中时也返回成功，不是离开的节点时返回404
+func (p *HttpPool) serveJoin(w http.ResponseWriter, r *http.Request) {
+	peer, ok := p.announcedPeer(w, r)
+	if !ok {
+		return
+	}
+	added, ok := p.rejoin(peer)
+	if !ok {
+		http.Error(w, "unknown peer: "+peer, http.StatusNotFound)
+		return
+	}
+	if added {
+		infof("HttpPool.serveJoin | peer %v rejoined\n", peer)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 节点之间的请求带有这个头或者gRPC元数据，值是发起请求的节点
+const PEER_HEADER = "X-Marscache-Peer"
+
+// 令牌桶超过这个个数时，创建新的令牌桶的同时清理已经装满的令牌桶
+const bucketSweepSize = 4096
+
+var (
+	ErrRateLimited = errors.New("rate limited")
+	ErrOverloaded  = errors.New("too many pending loads")
+)
+
+// 令牌桶的速率，Rate为0时不限制
+type RateLimit struct {
+	Rate  float64 // 每秒的请求数
+	Burst int     // 桶的容量，为0时取Rate向上取整
+}
+
+func (rl RateLimit) burst() float64 {
+	if rl.Burst > 0 {
+		return float64(rl.Burst)
+	}
+	return math.Max(1, math.Ceil(rl.Rate))
+}
+
+// 节点服务端的限流和过载保护
+type Limits struct {
+	Client RateLimit // 每个客户端IP，不是来自其他节点的请求
+	Peer   RateLimit // 每个节点，根据PEER_HEADER识别，只接受通过校验的、节点列表中的节点
+	Group  RateLimit // 每个Group，包括所有来源的请求；不属于Group的内部接口每个接口一个令牌桶
+	// 所有Group正在进行的加载超过这个数时，拒绝本地缓存未命中的读请求，为0时不限制
+	MaxPendingLoads int
+}
+
+// 限流的统计数据
+type LimiterStats struct {
+	RateLimited int64 // 超过速率被拒绝的请求数
+	Shed        int64 // 过载时被拒绝的请求数
+}
+
+// HttpPool和GrpcServer共用的限流器，可以在运行时修改限制
+type Limiter struct {
+	mutex   sync.Mutex
+	limits  Limits
+	clients map[string]*tokenBucket
+	peers   map[string]*tokenBucket
+	groups  map[string]*tokenBucket
+
+	rateLimited atomic.Int64
+	shed        atomic.Int64
+}
+
+func NewLimiter(limits Limits) *Limiter {
+	l := &Limiter{}
+	l.SetLimits(limits)
+	return l
+}
+
+// 修改限制，所有的令牌桶重新开始计算
+func (l *Limiter) SetLimits(limits Limits) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.limits = limits
+	l.clients = make(map[string]*tokenBucket)
+	l.peers = make(map[string]*tokenBucket)
+	l.groups = make(map[string]*tokenBucket)
+}
+
+func (l *Limiter) Limits() Limits {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.limits
+}
+
+func (l *Limiter) Stats() LimiterStats {
+	return LimiterStats{
+		RateLimited: l.rateLimited.Load(),
+		Shed:        l.shed.Load(),
+	}
+}
+
+// 检查一个请求，source是客户端IP或者节点；超过速率时返回ErrRateLimited
+// miss为true表示请求需要加载，正在进行的加载过多时返回ErrOverloaded，pending只在需要时调用
+func (l *Limiter) admit(source string, fromPeer bool, group string, miss func() bool, pending func() int) error {
+	l.mutex.Lock()
+	limits := l.limits
+	now := time.Now()
+	var allowed bool
+	if fromPeer {
+		allowed = allow(l.peers, source, limits.Peer, now)
+	} else {
+		allowed = allow(l.clients, source, limits.Client, now)
+	}
+	if allowed {
+		allowed = allow(l.groups, group, limits.Group, now)
+	}
+	l.mutex.Unlock()
+
+	if !allowed {
+		l.rateLimited.Add(1)
+		return ErrRateLimited
+	}
+	if limits.MaxPendingLoads > 0 && pending() > limits.MaxPendingLoads && miss() {
+		l.shed.Add(1)
+		return ErrOverloaded
+	}
+	return nil
+}
+
+// 调用时已经持有锁
+func allow(buckets map[string]*tokenBucket, name string, limit RateLimit, now time.Time) bool {
+	if limit.Rate <= 0 {
+		return true
+	}
+	b, ok := buckets[name]
+	if !ok {
+		if len(buckets) >= bucketSweepSize {
+			for k, old := range buckets {
+				if old.full(limit, now) {
+					delete(buckets, k)
+				}
+			}
+		}
+		b = &tokenBucket{tokens: limit.burst(), last: now}
+		buckets[name] = b
+	}
+	return b.take(limit, now)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(limit.burst(), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) take(limit RateLimit, now time.Time) bool {
+	b.refill(limit, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// 装满的令牌桶与新建的相同，可以删除
+func (b *tokenBucket) full(limit RateLimit, now time.Time) bool {
+	b.refill(limit, now)
+	return b.tokens >= limit.burst()
+}
+
+// 本地缓存是否命中，不影响统计数据，也不影响淘汰顺序
+func (g *Group) cached(key string) bool {
+	return g.mainCache.contains(key)
+}
+
+// 去掉端口的客户端地址
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// 设置限流，为nil时不限制；需要在开始服务之前调用
+func (hp *HttpPool) SetLimiter(limiter *Limiter) {
+	hp.limiter = limiter
+}
+
+// 限流和过载保护，超过速率时返回429，过载时返回503使对方选择其他副本
+// parts是去掉CACHE_BASE_PATH之后的路径，按照第一个/分隔
+func (p *HttpPool) admit(w http.ResponseWriter, r *http.Request, parts []string) bool {
+	if p.limiter == nil {
+		return true
+	}
+	// 只有通过校验的节点才按照节点限流，否则PEER_HEADER可以被客户端伪造
+	source, fromPeer := clientIP(r.RemoteAddr), false
+	if peer, ok := p.authenticatedPeer(r); ok && p.isPeer(peer) {
+		source, fromPeer = peer, true
+	}
+	bucket, miss := p.admission(r, parts)
+
+	switch err := p.limiter.admit(source, fromPeer, bucket, miss, p.registry.Inflight); err {
+	case nil:
+		return true
+	case ErrRateLimited:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+	return false
+}
+
+// 请求使用的Group令牌桶，以及是否需要加载
+// 数据、查找、预热和租约接口使用对应Group的令牌桶，其他内部接口各自使用以接口名称命名的令牌桶，Group名称不会以_开头
+// 只有数据接口的读请求在本地缓存未命中时需要加载
+func (p *HttpPool) admission(r *http.Request, parts []string) (string, func() bool) {
+	never := func() bool { return false }
+	switch parts[0] {
+	case handoffPath, peekPath, leasePath:
+		if len(parts) == 2 {
+			return strings.SplitN(parts[1], "/", 2)[0], never
+		}
+		return parts[0], never
+	case leavePath, joinPath, peersPath, ownerPath, ringPath:
+		return parts[0], never
+	}
+
+	miss := func() bool {
+		if r.Method != http.MethodGet || len(parts) != 2 {
+			return false
+		}
+		g := p.getGroup(parts[0])
+		return g != nil && !g.cached(parts[1])
+	}
+	return parts[0], miss
+}
+
+func (hp *HttpPool) isPeer(peer string) bool {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+	_, ok := hp.weights[peer]
+	return ok && peer != hp.hostPort
+}
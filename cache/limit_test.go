@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenBucket(t *testing.T) {
+	convey.Convey("TestTokenBucket", t, func() {
+		limit := RateLimit{Rate: 10, Burst: 2}
+		now := time.Now()
+		b := &tokenBucket{tokens: limit.burst(), last: now}
+
+		convey.So(b.take(limit, now), convey.ShouldBeTrue)
+		convey.So(b.take(limit, now), convey.ShouldBeTrue)
+		convey.So(b.take(limit, now), convey.ShouldBeFalse)
+
+		// 每100ms补充一个
+		convey.So(b.take(limit, now.Add(100*time.Millisecond)), convey.ShouldBeTrue)
+		convey.So(b.take(limit, now.Add(100*time.Millisecond)), convey.ShouldBeFalse)
+		convey.So(b.full(limit, now.Add(time.Second)), convey.ShouldBeTrue)
+
+		convey.So(RateLimit{Rate: 2.5}.burst(), convey.ShouldEqual, 3)
+		convey.So(RateLimit{Rate: 0.1}.burst(), convey.ShouldEqual, 1)
+	})
+}
+
+func TestLimiter(t *testing.T) {
+	convey.Convey("TestLimiter", t, func() {
+		release := make(chan struct{})
+		registry := NewRegistry()
+		g := registry.NewGroup("limit", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+			if key == "slow" || key == "slower" {
+				<-release
+			}
+			return []byte(key), nil
+		}))
+		limiter := NewLimiter(Limits{})
+		pool := NewHttpPool("http://self")
+		pool.SetRegistry(registry)
+		pool.SetPeerToken("peer-secret")
+		pool.Set("http://self", "http://peer")
+		pool.SetLimiter(limiter)
+		server := httptest.NewServer(pool)
+		defer server.Close()
+
+		getAs := func(key, peer, token string) int {
+			req, _ := http.NewRequest(http.MethodGet, server.URL+CACHE_BASE_PATH+"limit/"+key, nil)
+			setPeerHeader(req, peer, token)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return 0
+			}
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+		get := func(key, peer string) int {
+			return getAs(key, peer, "peer-secret")
+		}
+
+		convey.Convey("clients and peers have their own buckets", func() {
+			limiter.SetLimits(Limits{Client: RateLimit{Rate: 1, Burst: 1}, Peer: RateLimit{Rate: 1, Burst: 1}})
+			convey.So(get("a", ""), convey.ShouldEqual, http.StatusOK)
+			convey.So(get("a", ""), convey.ShouldEqual, http.StatusTooManyRequests)
+			// 不在节点列表中的节点按照客户端处理
+			convey.So(get("a", "http://stranger"), convey.ShouldEqual, http.StatusTooManyRequests)
+			// 没有令牌时PEER_HEADER不可信
+			convey.So(getAs("a", "http://peer", ""), convey.ShouldEqual, http.StatusTooManyRequests)
+			convey.So(get("a", "http://peer"), convey.ShouldEqual, http.StatusOK)
+			convey.So(get("a", "http://peer"), convey.ShouldEqual, http.StatusTooManyRequests)
+			convey.So(limiter.Stats().RateLimited, convey.ShouldEqual, 4)
+		})
+
+		convey.Convey("internal routes are limited before routing", func() {
+			limiter.SetLimits(Limits{Group: RateLimit{Rate: 1, Burst: 1}})
+			request := func(method, path string) int {
+				req, _ := http.NewRequest(method, server.URL+CACHE_BASE_PATH+path, nil)
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					return 0
+				}
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			// 每个接口一个令牌桶
+			convey.So(request(http.MethodGet, "_peers"), convey.ShouldEqual, http.StatusOK)
+			convey.So(request(http.MethodGet, "_ring"), convey.ShouldEqual, http.StatusOK)
+			convey.So(request(http.MethodGet, "_peers"), convey.ShouldEqual, http.StatusTooManyRequests)
+			convey.So(request(http.MethodPost, "_leave?peer=http://peer"), convey.ShouldEqual, http.StatusForbidden)
+			convey.So(request(http.MethodPost, "_leave?peer=http://peer"), convey.ShouldEqual, http.StatusTooManyRequests)
+
+			// 查找和租约接口与数据接口共用Group的令牌桶
+			convey.So(request(http.MethodGet, "_peek/limit/a"), convey.ShouldEqual, http.StatusForbidden)
+			convey.So(request(http.MethodPost, "_lease/limit/a?holder=http://peer&ttl=1s"), convey.ShouldEqual, http.StatusTooManyRequests)
+			convey.So(get("a", ""), convey.ShouldEqual, http.StatusTooManyRequests)
+		})
+
+		convey.Convey("groups are limited across all sources", func() {
+			limiter.SetLimits(Limits{Group: RateLimit{Rate: 1, Burst: 2}})
+			convey.So(get("a", ""), convey.ShouldEqual, http.StatusOK)
+			convey.So(get("a", "http://peer"), convey.ShouldEqual, http.StatusOK)
+			convey.So(get("a", "http://peer"), convey.ShouldEqual, http.StatusTooManyRequests)
+		})
+
+		convey.Convey("misses are shed while too many loads are pending", func() {
+			limiter.SetLimits(Limits{MaxPendingLoads: 1})
+			convey.So(get("cached", ""), convey.ShouldEqual, http.StatusOK)
+
+			done := make(chan struct{}, 2)
+			for _, key := range []string{"slow", "slower"} {
+				go func(key string) {
+					g.Get(key)
+					done <- struct{}{}
+				}(key)
+			}
+			for registry.Inflight() < 2 {
+				time.Sleep(time.Millisecond)
+			}
+			convey.So(get("new", ""), convey.ShouldEqual, http.StatusServiceUnavailable)
+			convey.So(get("cached", ""), convey.ShouldEqual, http.StatusOK)
+			convey.So(limiter.Stats().Shed, convey.ShouldEqual, 1)
+
+			close(release)
+			<-done
+			<-done
+			convey.So(get("new", ""), convey.ShouldEqual, http.StatusOK)
+		})
+	})
+}
+
+func TestCached(t *testing.T) {
+	convey.Convey("TestCached", t, func() {
+		registry := NewRegistry()
+		// 每个元素 1 + 1 = 2，内存中只能放下两个
+		g := registry.NewGroup("cached", 4, GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+		g.Get("a")
+		g.Get("b")
+
+		// 检查是否命中不影响淘汰顺序，a仍然最先被淘汰
+		convey.So(g.cached("a"), convey.ShouldBeTrue)
+		g.Get("c")
+		convey.So(g.cached("a"), convey.ShouldBeFalse)
+		convey.So(g.cached("b"), convey.ShouldBeTrue)
+		convey.So(g.Stats().Hits, convey.ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// 并发加载数和等待的个数都已经达到上限
+var ErrLoadRejected = errors.New("too many concurrent loads")
+
+// 支持context的Getter，设置了加载超时时通过ctx取消
+type ContextGetter interface {
+	Getter
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+type ContextGetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f ContextGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+func (f ContextGetterFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+// 暂时性的错误，例如超时、连接失败，可以重试
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// Getter返回的错误用Transient包装后视为暂时性的错误
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &transientError{err}
+}
+
+// 用Transient包装的错误、超时以及网络超时视为暂时性的错误
+func IsTransient(err error) bool {
+	var te *transientError
+	if errors.As(err, &te) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// 重试策略，第n次重试之前等待 Backoff*2^(n-1)，不超过MaxBackoff，并在[d/2, d)之间随机，避免多个节点同时重试
+type RetryPolicy struct {
+	Retries    int              // 失败后的最大重试次数，为0时不重试
+	Backoff    time.Duration    // 第一次重试之前的等待时间，为0时使用100ms
+	MaxBackoff time.Duration    // 最大的等待时间，为0时不限制
+	Retryable  func(error) bool // 哪些错误可以重试，为nil时使用IsTransient
+}
+
+func (r RetryPolicy) retryable(err error) bool {
+	if r.Retryable != nil {
+		return r.Retryable(err)
+	}
+	return IsTransient(err)
+}
+
+// 第attempt次重试之前的等待时间
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	d := r.Backoff
+	if d <= 0 {
+		d = 100 * time.Millisecond
+	}
+	for i := 1; i < attempt && (r.MaxBackoff <= 0 || d < r.MaxBackoff); i++ {
+		d *= 2
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// 从Getter加载的选项
+type LoadOptions struct {
+	// 每次调用Getter的超时时间，包括等待并发数的时间，为0时不限制
+	// Getter实现ContextGetter时通过ctx取消，否则超时后不再等待，Getter返回之前仍然占用并发数
+	Timeout time.Duration
+	Retry   RetryPolicy
+	// 同时调用Getter的最大个数，不同的key也受限制，为0时不限制
+	MaxConcurrent int
+	// 达到MaxConcurrent后最多等待的个数，超出时返回ErrLoadRejected；为0时不等待
+	MaxQueue int
+}
+
+// 设置从Getter加载的超时、重试和并发限制
+func WithLoadOptions(opts LoadOptions) GroupOption {
+	return func(g *Group) {
+		g.loadOptions = opts
+		g.limiter = newLoadLimiter(opts.MaxConcurrent, opts.MaxQueue)
+	}
+}
+
+func (g *Group) LoadOptions() LoadOptions {
+	return g.loadOptions
+}
+
+// 限制同时调用Getter的个数
+type loadLimiter struct {
+	slots    chan struct{} // 为nil时不限制
+	maxQueue int64
+	active   atomic.Int64 // 正在调用Getter的个数
+	queued   atomic.Int64 // 等待并发数的个数
+}
+
+func newLoadLimiter(maxConcurrent, maxQueue int) *loadLimiter {
+	l := &loadLimiter{maxQueue: int64(maxQueue)}
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// 取得一个并发数，已满时排队等待，队列已满时返回ErrLoadRejected，ctx结束时返回ctx.Err()
+func (l *loadLimiter) acquire(ctx context.Context) error {
+	if l.slots == nil {
+		l.active.Add(1)
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		l.active.Add(1)
+		return nil
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		return ErrLoadRejected
+	}
+	defer l.queued.Add(-1)
+	select {
+	case l.slots <- struct{}{}:
+		l.active.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *loadLimiter) release() {
+	l.active.Add(-1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// 调用Getter，暂时性的错误按照重试策略重试
+func (g *Group) callGetter(key string) ([]byte, error) {
+	retry := g.loadOptions.Retry
+	for attempt := 1; ; attempt++ {
+		b, err := g.callGetterOnce(key)
+		if err == nil || attempt > retry.Retries || errors.Is(err, ErrLoadRejected) || !retry.retryable(err) {
+			return b, err
+		}
+		g.stats.LoadRetries.Add(1)
+		debugf("Group.callGetter | key: %v, attempt: %v, err: %+v\n", key, attempt, err)
+		time.Sleep(retry.backoff(attempt))
+	}
+}
+
+func (g *Group) callGetterOnce(key string) ([]byte, error) {
+	timeout := g.loadOptions.Timeout
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	if err := g.limiter.acquire(ctx); err != nil {
+		if err == ErrLoadRejected {
+			g.stats.LoadRejects.Add(1)
+		} else {
+			g.stats.LoadTimeouts.Add(1)
+		}
+		return nil, fmt.Errorf("load %q: %w", key, err)
+	}
+
+	getter, ok := g.getter.(ContextGetter)
+	if !ok && timeout > 0 {
+		return g.callGetterAsync(ctx, key)
+	}
+	defer g.limiter.release()
+
+	var b []byte
+	var err error
+	if ok {
+		b, err = getter.GetContext(ctx, key)
+	} else {
+		b, err = g.getter.Get(key)
+	}
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		g.stats.LoadTimeouts.Add(1)
+	}
+	return b, err
+}
+
+// Getter不支持context时在单独的goroutine中调用，超时后不再等待，Getter返回时才释放并发数
+func (g *Group) callGetterAsync(ctx context.Context, key string) ([]byte, error) {
+	type result struct {
+		b   []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer g.limiter.release()
+		b, err := g.getter.Get(key)
+		done <- result{b, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.b, r.err
+	case <-ctx.Done():
+		g.stats.LoadTimeouts.Add(1)
+		return nil, fmt.Errorf("load %q: %w", key, ctx.Err())
+	}
+}
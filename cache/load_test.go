@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoadOptions(t *testing.T) {
+	convey.Convey("TestLoadOptions", t, func() {
+		registry := NewRegistry()
+
+		convey.Convey("slow getters time out", func() {
+			g := registry.NewGroup("load-timeout", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+				time.Sleep(200 * time.Millisecond)
+				return []byte(key), nil
+			}), WithLoadOptions(LoadOptions{Timeout: 20 * time.Millisecond}))
+			_, err := g.Get("tom")
+			convey.So(errors.Is(err, context.DeadlineExceeded), convey.ShouldBeTrue)
+			convey.So(g.Stats().LoadTimeouts, convey.ShouldEqual, 1)
+
+			// 支持context的Getter被取消
+			var canceled atomic.Bool
+			g = registry.NewGroup("load-timeout", 1<<20, ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+				<-ctx.Done()
+				canceled.Store(true)
+				return nil, ctx.Err()
+			}), WithLoadOptions(LoadOptions{Timeout: 20 * time.Millisecond}))
+			_, err = g.Get("tom")
+			convey.So(errors.Is(err, context.DeadlineExceeded), convey.ShouldBeTrue)
+			convey.So(canceled.Load(), convey.ShouldBeTrue)
+			convey.So(g.Stats().LoadTimeouts, convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("transient errors are retried", func() {
+			var calls atomic.Int32
+			g := registry.NewGroup("load-retry", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+				switch calls.Add(1) {
+				case 1, 2:
+					return nil, Transient(errors.New("connection reset"))
+				}
+				return []byte(key), nil
+			}), WithLoadOptions(LoadOptions{Retry: RetryPolicy{Retries: 3, Backoff: time.Millisecond}}))
+			value, err := g.Get("tom")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(value.String(), convey.ShouldEqual, "tom")
+			convey.So(calls.Load(), convey.ShouldEqual, 3)
+			convey.So(g.Stats().LoadRetries, convey.ShouldEqual, 2)
+
+			// 其他错误不重试
+			calls.Store(0)
+			g = registry.NewGroup("load-retry", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+				calls.Add(1)
+				return nil, errors.New("not found")
+			}), WithLoadOptions(LoadOptions{Retry: RetryPolicy{Retries: 3, Backoff: time.Millisecond}}))
+			_, err = g.Get("tom")
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(calls.Load(), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("backoff grows exponentially with jitter", func() {
+			policy := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}
+			for attempt, want := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 40 * time.Millisecond, 10: 40 * time.Millisecond} {
+				d := policy.backoff(attempt)
+				convey.So(d, convey.ShouldBeBetweenOrEqual, want/2, want)
+			}
+			convey.So(IsTransient(context.DeadlineExceeded), convey.ShouldBeTrue)
+			convey.So(IsTransient(errors.New("boom")), convey.ShouldBeFalse)
+		})
+
+		convey.Convey("concurrent loads are limited, queued and rejected", func() {
+			release := make(chan struct{})
+			started := make(chan struct{}, 3)
+			g := registry.NewGroup("load-limit", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+				started <- struct{}{}
+				<-release
+				return []byte(key), nil
+			}), WithLoadOptions(LoadOptions{MaxConcurrent: 1, MaxQueue: 1}))
+
+			errs := make(chan error, 2)
+			for _, key := range []string{"a", "b"} {
+				go func(key string) {
+					_, err := g.Get(key)
+					errs <- err
+				}(key)
+				if key == "a" {
+					<-started
+				}
+			}
+			for g.Stats().LoadsQueued != 1 {
+				time.Sleep(time.Millisecond)
+			}
+			stats := g.Stats()
+			convey.So(stats.LoadsActive, convey.ShouldEqual, 1)
+
+			_, err := g.Get("c")
+			convey.So(errors.Is(err, ErrLoadRejected), convey.ShouldBeTrue)
+			convey.So(g.Stats().LoadRejects, convey.ShouldEqual, 1)
+
+			close(release)
+			convey.So(<-errs, convey.ShouldBeNil)
+			convey.So(<-errs, convey.ShouldBeNil)
+			stats = g.Stats()
+			convey.So(stats.LoadsActive, convey.ShouldEqual, 0)
+			convey.So(stats.LoadsQueued, convey.ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// 日志级别，低于当前级别的日志不输出
+type LogLevel int32
+
+const (
+	LogDebug LogLevel = iota // 每个请求的日志，例如命中和未命中
+	LogInfo                  // 节点和Group的变化，例如节点离开、清空缓存
+	LogError                 // 只输出错误
+)
+
+var logLevel atomic.Int32 // 默认LogDebug
+
+// 设置日志级别，可以在运行时修改
+func SetLogLevel(level LogLevel) {
+	logLevel.Store(int32(level))
+}
+
+func GetLogLevel() LogLevel {
+	return LogLevel(logLevel.Load())
+}
+
+// 解析debug、info或者error
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LogDebug, nil
+	case "info":
+		return LogInfo, nil
+	case "error":
+		return LogError, nil
+	}
+	return LogDebug, fmt.Errorf("unknown log level: %q", s)
+}
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogError:
+		return "error"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int32(l))
+}
+
+func logf(level LogLevel, format string, args ...interface{}) {
+	if level >= GetLogLevel() {
+		log.Printf(format, args...)
+	}
+}
+
+func debugf(format string, args ...interface{}) { logf(LogDebug, format, args...) }
+func infof(format string, args ...interface{})  { logf(LogInfo, format, args...) }
+func errorf(format string, args ...interface{}) { logf(LogError, format, args...) }
@@ -0,0 +1,274 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 缓存管理器，持有一个总的内存预算，多个Group共享
+// 每个Group按照配置的权重，或者最近的命中价值，得到一个目标份额
+// 总的使用量超出预算时，从超出目标份额最多的Group中淘汰，即单位内存的价值最低的Group
+// 每个Group使用量的变化实时计入used，没有超出预算时写入不需要加锁，也不需要遍历所有的Group
+type Manager struct {
+	mutex  sync.Mutex
+	total  atomic.Int64 // 修改时持有锁
+	used   atomic.Int64
+	groups map[string]*managedGroup
+}
+
+type managedGroup struct {
+	group  *Group
+	weight int64   // 配置的权重，为0时按照命中价值分配
+	hits   int64   // 上次Rebalance时的命中数
+	value  float64 // 最近的命中价值，每次Rebalance衰减一半
+	target int64   // 目标份额
+}
+
+func NewManager(total int64) *Manager {
+	m := &Manager{
+		groups: make(map[string]*managedGroup),
+	}
+	m.total.Store(total)
+	return m
+}
+
+// 注册Group，weight为0时按照最近的命中价值分配预算
+// 只要有一个Group配置了权重，就按照权重分配，没有配置权重的Group权重视为1
+// 一个Group只能属于一个Manager，重复注册会从之前的Manager中移除
+func (m *Manager) Register(g *Group, weight int64) {
+	if old := g.manager.Swap(m); old != nil && old != m {
+		old.remove(g)
+	}
+
+	m.mutex.Lock()
+	m.groups[g.name] = &managedGroup{
+		group:  g,
+		weight: weight,
+		hits:   g.stats.Hits.Load(),
+	}
+	g.mainCache.setUsage(&m.used)
+	g.mainCache.setCapacity(m.capacityOf(g))
+	m.rebalanceLocked()
+	m.mutex.Unlock()
+
+	g.mainCache.flushSpilled()
+	m.reclaim()
+}
+
+// 取消注册，Group恢复创建时的容量
+func (m *Manager) Unregister(g *Group) {
+	if !g.manager.CompareAndSwap(m, nil) {
+		return
+	}
+	m.remove(g)
+	g.mainCache.setUsage(nil)
+	g.mainCache.resize(g.capacity.Load())
+}
+
+// Group的容量改变后，重新计算实际的容量和目标份额
+func (m *Manager) resize(g *Group) {
+	m.mutex.Lock()
+	if mg, ok := m.groups[g.name]; ok && mg.group == g {
+		g.mainCache.setCapacity(m.capacityOf(g))
+		m.rebalanceLocked()
+	}
+	m.mutex.Unlock()
+
+	g.mainCache.flushSpilled()
+	m.reclaim()
+}
+
+func (m *Manager) remove(g *Group) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if mg, ok := m.groups[g.name]; ok && mg.group == g {
+		delete(m.groups, g.name)
+		m.rebalanceLocked()
+	}
+}
+
+// 设置总的预算，超出时淘汰
+func (m *Manager) SetTotal(total int64) {
+	m.mutex.Lock()
+	m.total.Store(total)
+	resized := make([]*cacheInner, 0, len(m.groups))
+	for _, mg := range m.groups {
+		mg.group.mainCache.setCapacity(m.capacityOf(mg.group))
+		resized = append(resized, &mg.group.mainCache)
+	}
+	m.rebalanceLocked()
+	m.mutex.Unlock()
+
+	// 淘汰的数据在释放锁之后再写入磁盘，与reclaim相同
+	for _, c := range resized {
+		c.flushSpilled()
+	}
+	m.reclaim()
+}
+
+func (m *Manager) Total() int64 {
+	return m.total.Load()
+}
+
+// 所有注册的Group已经使用的容量之和
+func (m *Manager) Usage() int64 {
+	return m.used.Load()
+}
+
+// 每个Group的目标份额
+func (m *Manager) Targets() map[string]int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	targets := make(map[string]int64, len(m.groups))
+	for name, mg := range m.groups {
+		targets[name] = mg.target
+	}
+	return targets
+}
+
+// 根据最近的命中重新计算目标份额
+func (m *Manager) Rebalance() {
+	m.mutex.Lock()
+	m.rebalanceLocked()
+	m.mutex.Unlock()
+
+	m.reclaim()
+}
+
+// 定期Rebalance，返回停止的方法
+func (m *Manager) Start(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Rebalance()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// 单个Group最多可以使用整个预算，同时不超过创建时指定的容量
+func (m *Manager) capacityOf(g *Group) int64 {
+	total := m.total.Load()
+	capacity := g.capacity.Load()
+	if total <= 0 || (capacity > 0 && capacity < total) {
+		return capacity
+	}
+	return total
+}
+
+func (m *Manager) rebalanceLocked() {
+	if len(m.groups) == 0 {
+		return
+	}
+
+	weighted := false
+	for _, mg := range m.groups {
+		if mg.weight > 0 {
+			weighted = true
+			break
+		}
+	}
+
+	// 更新命中价值
+	for _, mg := range m.groups {
+		hits := mg.group.stats.Hits.Load()
+		mg.value = mg.value/2 + float64(hits-mg.hits)
+		mg.hits = hits
+	}
+
+	scores := make(map[*managedGroup]float64, len(m.groups))
+	var sum float64
+	for _, mg := range m.groups {
+		score := mg.value
+		if weighted {
+			score = float64(mg.weight)
+			if mg.weight <= 0 {
+				score = 1
+			}
+		}
+		scores[mg] = score
+		sum += score
+	}
+
+	// 按照命中价值分配时，每个Group保底平均份额的1/4，避免新的Group因为没有命中而一直拿不到内存
+	n := int64(len(m.groups))
+	floor := int64(0)
+	if !weighted {
+		floor = m.total.Load() / (4 * n)
+	}
+	rest := m.total.Load() - floor*n
+
+	for mg, score := range scores {
+		share := rest / n
+		if sum > 0 {
+			share = int64(float64(rest) * score / sum)
+		}
+		mg.target = floor + share
+	}
+}
+
+// 总的使用量超出预算时，从单位内存价值最低的Group中淘汰
+// 没有超出时只读取两个原子变量，每次写入后都可以调用
+func (m *Manager) reclaim() {
+	if !m.over() {
+		return
+	}
+
+	// 淘汰的数据在释放锁之后再写入磁盘
+	victims := make(map[*cacheInner]struct{})
+	defer func() {
+		for c := range victims {
+			c.flushSpilled()
+		}
+	}()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for m.over() {
+		victim := m.victimLocked()
+		if victim == nil {
+			return
+		}
+		victims[&victim.group.mainCache] = struct{}{}
+		if victim.group.mainCache.removeOldest() <= 0 {
+			return
+		}
+	}
+}
+
+func (m *Manager) over() bool {
+	total := m.total.Load()
+	return total > 0 && m.used.Load() > total
+}
+
+// 使用量相对目标份额最大的Group
+func (m *Manager) victimLocked() *managedGroup {
+	var victim *managedGroup
+	var worst float64
+	for _, mg := range m.groups {
+		used := mg.group.mainCache.bytes()
+		if used <= 0 {
+			continue
+		}
+		ratio := float64(used) / float64(mg.target+1)
+		if victim == nil || ratio > worst {
+			victim, worst = mg, ratio
+		}
+	}
+	return victim
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gy0117/gocache/disk"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestManager(t *testing.T) {
+	convey.Convey("TestManager", t, func() {
+		getter := GetterFunc(func(key string) ([]byte, error) {
+			return []byte("0123456789"), nil
+		})
+		// 每个元素占用 4 + 10 = 14
+		const entry = 14
+
+		convey.Convey("shares follow configured weights", func() {
+			m := NewManager(1000 * entry)
+			big := NewGroup("manager-weight-big", 0, getter)
+			small := NewGroup("manager-weight-small", 0, getter)
+			m.Register(big, 3)
+			m.Register(small, 1)
+
+			targets := m.Targets()
+			convey.So(targets["manager-weight-big"], convey.ShouldEqual, 750*entry)
+			convey.So(targets["manager-weight-small"], convey.ShouldEqual, 250*entry)
+
+			for i := 0; i < 2000; i++ {
+				big.Get(fmt.Sprintf("b%03d", i%1000))
+				small.Get(fmt.Sprintf("s%03d", i%1000))
+			}
+			convey.So(m.Usage(), convey.ShouldBeLessThanOrEqualTo, 1000*entry)
+			convey.So(big.mainCache.bytes(), convey.ShouldBeGreaterThan, 2*small.mainCache.bytes())
+		})
+
+		convey.Convey("tight budget evicts from the least valuable group", func() {
+			m := NewManager(100 * entry)
+			hot := NewGroup("manager-hit-hot", 0, getter)
+			cold := NewGroup("manager-hit-cold", 0, getter)
+			m.Register(hot, 0)
+			m.Register(cold, 0)
+
+			// hot反复命中同一批key，cold每次都是新的key
+			for round := 0; round < 10; round++ {
+				for i := 0; i < 40; i++ {
+					hot.Get(fmt.Sprintf("h%03d", i))
+				}
+				m.Rebalance()
+			}
+			for i := 0; i < 500; i++ {
+				cold.Get(fmt.Sprintf("c%03d", i))
+			}
+
+			convey.So(m.Usage(), convey.ShouldBeLessThanOrEqualTo, 100*entry)
+			convey.So(hot.mainCache.bytes(), convey.ShouldEqual, 40*entry)
+			convey.So(hot.Stats().Hits, convey.ShouldEqual, 360)
+		})
+
+		convey.Convey("unregister restores the group capacity", func() {
+			m := NewManager(10 * entry)
+			g := NewGroup("manager-unregister", 0, getter)
+			m.Register(g, 0)
+			for i := 0; i < 100; i++ {
+				g.Get(fmt.Sprintf("u%03d", i))
+			}
+			convey.So(g.mainCache.bytes(), convey.ShouldBeLessThanOrEqualTo, 10*entry)
+
+			m.Unregister(g)
+			for i := 0; i < 100; i++ {
+				g.Get(fmt.Sprintf("u%03d", i))
+			}
+			convey.So(g.mainCache.bytes(), convey.ShouldEqual, 100*entry)
+			convey.So(m.Usage(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("usage follows puts and removes of every group", func() {
+			m := NewManager(1000 * entry)
+			a := NewGroup("manager-usage-a", 0, getter)
+			b := NewGroup("manager-usage-b", 0, getter)
+			for i := 0; i < 10; i++ {
+				a.Get(fmt.Sprintf("a%03d", i))
+			}
+			m.Register(a, 0)
+			m.Register(b, 0)
+			convey.So(m.Usage(), convey.ShouldEqual, 10*entry)
+
+			for i := 0; i < 5; i++ {
+				b.Get(fmt.Sprintf("b%03d", i))
+			}
+			a.removeLocally("a000")
+			convey.So(m.Usage(), convey.ShouldEqual, 14*entry)
+			convey.So(m.Usage(), convey.ShouldEqual, a.mainCache.bytes()+b.mainCache.bytes())
+
+			m.Unregister(a)
+			convey.So(m.Usage(), convey.ShouldEqual, 5*entry)
+			b.Flush()
+			convey.So(m.Usage(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("shrinking the budget writes evicted entries to disk", func() {
+			store, err := disk.Open(t.TempDir(), 1<<20)
+			convey.So(err, convey.ShouldBeNil)
+			defer store.Close()
+
+			m := NewManager(1000 * entry)
+			g := NewGroup("manager-disk", 0, getter, WithDiskTier(store))
+			defer DeleteGroup("manager-disk")
+			m.Register(g, 0)
+			for i := 0; i < 10; i++ {
+				g.Get(fmt.Sprintf("d%03d", i))
+			}
+
+			m.SetTotal(4 * entry)
+			convey.So(g.mainCache.bytes(), convey.ShouldEqual, 4*entry)
+			convey.So(store.Len(), convey.ShouldEqual, 6)
+			convey.So(g.mainCache.pending, convey.ShouldBeEmpty)
+		})
+	})
+}
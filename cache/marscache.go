@@ -1,15 +1,21 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/gy0117/gocache/disk"
 	"github.com/gy0117/gocache/pb"
 	"github.com/gy0117/gocache/peers"
 	"github.com/gy0117/gocache/singleflight"
 )
 
+// 副本不支持写入和删除，例如GrpcPool的节点，该副本上的数据可能与其他副本不一致
+var ErrReplicaNotWritable = errors.New("replica does not support writes")
+
 type Getter interface {
 	Get(key string) ([]byte, error)
 }
@@ -24,50 +30,169 @@ func (gf GetterFunc) Get(key string) ([]byte, error) {
 type Group struct {
 	name       string
 	getter     Getter
+	capacity   atomic.Int64 // 创建时指定的容量，共享内存预算时作为上限
+	ttl        atomic.Int64 // 从Getter加载的数据的过期时间，单位纳秒，为0表示不过期
 	mainCache  cacheInner
-	peerPicker peers.PeerPicker
+	peerPicker atomic.Pointer[pickerBox] // 为nil或者PeerPicker为nil时只在本地加载
 
 	loader *singleflight.Group
+	lease  time.Duration // 分布式租约的有效期，为0时不申请租约
+	leases leaseTable    // 本节点授予其他节点的租约
+
+	loadOptions LoadOptions  // 调用Getter的超时、重试和并发限制
+	limiter     *loadLimiter // 限制同时调用Getter的个数
+
+	stats   groupStats
+	manager atomic.Pointer[Manager] // 共享内存预算的管理器
 }
 
-var (
-	mutex  sync.RWMutex
-	groups = make(map[string]*Group)
-)
+// Group的可选配置
+type GroupOption func(*Group)
+
+// 容量按照内存统计，每个元素额外计入list、map和ByteData的开销，使容量接近真实的内存占用
+func WithMemoryAccounting() GroupOption {
+	return func(g *Group) {
+		g.mainCache.overhead = entryOverhead
+	}
+}
+
+// 启用磁盘缓存，内存淘汰的数据写入磁盘，内存未命中时先从磁盘中查找，再从远程节点或者Getter加载
+func WithDiskTier(store *disk.Store) GroupOption {
+	return func(g *Group) {
+		g.mainCache.disk = store
+	}
+}
+
+// 从Getter加载的数据在ttl之后过期，过期后重新加载
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl.Store(int64(ttl))
+	}
+}
+
+// 本地缓存的存储方式，默认是StorageLRU
+func WithStorage(storage Storage) GroupOption {
+	return func(g *Group) {
+		g.mainCache.storage = storage
+	}
+}
 
-func NewGroup(name string, capacity int64, getter Getter) *Group {
+// 创建Group并加入默认的Registry，同名的Group会被替换；名称为空、以_开头或者包含/时panic
+func NewGroup(name string, capacity int64, getter Getter, opts ...GroupOption) *Group {
+	return defaultRegistry.NewGroup(name, capacity, getter, opts...)
+}
+
+// 创建Group并加入默认的Registry，同名的Group已经存在时返回ErrGroupExists，名称不合法时返回ErrReservedName
+func CreateGroup(name string, capacity int64, getter Getter, opts ...GroupOption) (*Group, error) {
+	return defaultRegistry.CreateGroup(name, capacity, getter, opts...)
+}
+
+func newGroup(name string, capacity int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("Getter is nil")
 	}
 
-	cache := cacheInner{
-		cacheCapacity: capacity,
-	}
 	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache,
-		loader:    &singleflight.Group{},
+		name:   name,
+		getter: getter,
+		mainCache: cacheInner{
+			cacheCapacity: capacity,
+		},
+		loader:  &singleflight.Group{},
+		limiter: newLoadLimiter(0, 0),
+	}
+	g.capacity.Store(capacity)
+	for _, opt := range opts {
+		opt(g)
 	}
-
-	mutex.Lock()
-	groups[name] = g
-	mutex.Unlock()
 	return g
 }
 
+func (g *Group) Name() string {
+	return g.name
+}
+
+// atomic.Pointer不能直接存放接口
+type pickerBox struct {
+	picker peers.PeerPicker
+}
+
+// 只能调用一次，替换PeerPicker使用SetPeerPicker
 func (g *Group) RegisterPeerPicker(peer peers.PeerPicker) {
-	if g.peerPicker != nil {
+	if !g.peerPicker.CompareAndSwap(nil, &pickerBox{peer}) {
 		panic("RegisterPeerPicker called more than once")
 	}
-	g.peerPicker = peer
+}
+
+// 替换PeerPicker，返回之前的PeerPicker；picker为nil时只在本地加载，例如只在本地缓存的Group
+// 正在进行的加载仍然使用之前的PeerPicker，之后的请求使用新的PeerPicker，例如迁移到另一组节点
+func (g *Group) SetPeerPicker(picker peers.PeerPicker) peers.PeerPicker {
+	if old := g.peerPicker.Swap(&pickerBox{picker}); old != nil {
+		return old.picker
+	}
+	return nil
+}
+
+// 当前的PeerPicker，没有设置时返回nil
+func (g *Group) PeerPicker() peers.PeerPicker {
+	if box := g.peerPicker.Load(); box != nil {
+		return box.picker
+	}
+	return nil
 }
 
 func GetGroup(name string) *Group {
-	mutex.RLock()
-	g := groups[name]
-	mutex.RUnlock()
-	return g
+	return defaultRegistry.GetGroup(name)
+}
+
+// 从默认的Registry中删除Group，Group不存在时返回false
+func DeleteGroup(name string) bool {
+	return defaultRegistry.DeleteGroup(name)
+}
+
+// 默认的Registry中所有的Group，按照名称排序
+func Groups() []*Group {
+	return defaultRegistry.Groups()
+}
+
+// 指定的容量，共享内存预算时实际的容量可能更小
+func (g *Group) Capacity() int64 {
+	return g.capacity.Load()
+}
+
+// 调整容量，超出时淘汰；共享内存预算时作为新的上限
+func (g *Group) Resize(capacity int64) {
+	g.capacity.Store(capacity)
+	if m := g.manager.Load(); m != nil {
+		m.resize(g)
+		return
+	}
+	g.mainCache.resize(capacity)
+}
+
+func (g *Group) TTL() time.Duration {
+	return time.Duration(g.ttl.Load())
+}
+
+// 修改过期时间，只影响之后加载的数据，已经缓存的数据仍然按照原来的时间过期
+func (g *Group) SetTTL(ttl time.Duration) {
+	g.ttl.Store(int64(ttl))
+}
+
+// 正在进行的加载的个数，相同key的并发请求只算一个
+func (g *Group) Inflight() int {
+	return g.loader.Inflight()
+}
+
+// 等待正在进行的加载结束，用于关闭节点之前
+func (g *Group) WaitLoads(ctx context.Context) error {
+	return g.loader.Wait(ctx)
+}
+
+// 清空本地的内存缓存和磁盘缓存，不影响其他节点
+func (g *Group) Flush() {
+	g.mainCache.clear()
+	infof("Group.Flush | group: %v\n", g.name)
 }
 
 func (g *Group) Get(key string) (ByteData, error) {
@@ -75,15 +200,18 @@ func (g *Group) Get(key string) (ByteData, error) {
 		return ByteData{}, fmt.Errorf("key must not be nil")
 	}
 
+	g.stats.Gets.Add(1)
 	if bytedata, ok := g.mainCache.get(key); ok {
-		log.Printf("Group.Get | mainCache.get successfully data: %v\n", bytedata.String())
+		g.stats.Hits.Add(1)
+		debugf("Group.Get | mainCache.get successfully data: %v\n", bytedata.String())
 		return bytedata, nil
 	}
-	log.Println("Group.Get | cache miss")
+	debugf("Group.Get | cache miss\n")
 	// 如果没有缓存，则加载本地或者远程的
 	// return g.load(key)
 
 	data, err := g.loader.Do(key, func() (singleflight.CallValue, error) {
+		g.stats.Loads.Add(1)
 		return g.load(key)
 	})
 	if err != nil {
@@ -92,36 +220,200 @@ func (g *Group) Get(key string) (ByteData, error) {
 	return data.(ByteData), nil
 }
 
+// 只查找本地缓存，未命中时从Getter加载，不请求其他节点的缓存（启用租约时除外）；用于处理其他节点的请求
+func (g *Group) getLocally(key string) (ByteData, error) {
+	if key == "" {
+		return ByteData{}, fmt.Errorf("key must not be nil")
+	}
+
+	g.stats.Gets.Add(1)
+	if bytedata, ok := g.mainCache.get(key); ok {
+		g.stats.Hits.Add(1)
+		return bytedata, nil
+	}
+
+	data, err := g.loader.Do(key, func() (singleflight.CallValue, error) {
+		g.stats.Loads.Add(1)
+		return g.loadLeased(g.PeerPicker(), key)
+	})
+	if err != nil {
+		return ByteData{}, err
+	}
+	return data.(ByteData), nil
+}
+
+// 写入所有副本，没有注册PeerPicker时只写入本地缓存
+// 返回第一个失败的副本的错误，其他副本仍然会写入；副本不支持写入时返回ErrReplicaNotWritable
+func (g *Group) Set(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key must not be nil")
+	}
+
+	req := &pb.Request{Group: g.name, Key: key}
+	var firstErr error
+	for _, peer := range g.replicas(key) {
+		if peer == nil {
+			g.setLocally(key, value)
+			continue
+		}
+		writer, ok := peer.(peers.PeerWriter)
+		if !ok {
+			if firstErr == nil {
+				firstErr = ErrReplicaNotWritable
+			}
+			continue
+		}
+		if err := writer.Set(req, &pb.Response{Value: value}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// 从所有副本以及本节点删除，用于数据变化后的失效；副本不支持删除时返回ErrReplicaNotWritable
+func (g *Group) Remove(key string) error {
+	g.removeLocally(key)
+
+	req := &pb.Request{Group: g.name, Key: key}
+	var firstErr error
+	for _, peer := range g.replicas(key) {
+		if peer == nil {
+			continue
+		}
+		writer, ok := peer.(peers.PeerWriter)
+		if !ok {
+			if firstErr == nil {
+				firstErr = ErrReplicaNotWritable
+			}
+			continue
+		}
+		if err := writer.Delete(req); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// key的所有副本，本节点对应nil
+func (g *Group) replicas(key string) []peers.PeerGetter {
+	picker := g.PeerPicker()
+	if picker == nil {
+		return []peers.PeerGetter{nil}
+	}
+	if replicaPicker, ok := picker.(peers.ReplicaPicker); ok {
+		return replicaPicker.PickReplicas(key)
+	}
+	if peer, ok := picker.PickPeer(key); ok {
+		return []peers.PeerGetter{peer}
+	}
+	return []peers.PeerGetter{nil}
+}
+
+func (g *Group) setLocally(key string, value []byte) {
+	val := ByteData{data: cloneBytes(value)}
+	if ttl := g.TTL(); ttl > 0 {
+		val.expire = time.Now().Add(ttl).UnixNano()
+	}
+	g.put(key, val)
+}
+
+func (g *Group) removeLocally(key string) {
+	g.mainCache.remove(key)
+}
+
 func (g *Group) put(key string, value ByteData) {
 	g.mainCache.add(key, value)
+
+	if m := g.manager.Load(); m != nil {
+		m.reclaim()
+	}
 }
 
-// 1. 先去远程查找
-// 2. 远程找不到，再去本地找
+// 1. 先去远程查找，多副本时按照顺序尝试每个副本，轮到本节点时直接在本地加载
+// 2. 属于本节点时，如果正在预热，先去之前的节点的缓存中查找
+// 3. 远程找不到，再去本地找，启用租约时需要先取得租约
 func (g *Group) load(key string) (ByteData, error) {
-	if g.peerPicker != nil {
-		if peer, ok := g.peerPicker.PickPeer(key); ok {
-			bytedata, err := g.GetFromPeerPicker(peer, key)
-			if err == nil {
-				log.Printf("Group.load | get from PeerPicker successfully, data: %+v\n", bytedata.String())
+	picker := g.PeerPicker()
+	if picker != nil {
+		if replicaPicker, ok := picker.(peers.ReplicaPicker); ok {
+			return g.loadFromReplicas(picker, replicaPicker.PickReplicas(key), key)
+		}
+		if peer, ok := picker.PickPeer(key); ok {
+			if bytedata, err := g.loadFromPeer(peer, key); err == nil {
+				return bytedata, nil
+			}
+		} else if bytedata, ok := g.loadFromPreviousPeer(picker, key); ok {
+			return bytedata, nil
+		}
+	}
+	return g.loadLeased(picker, key)
+}
+
+func (g *Group) loadFromReplicas(picker peers.PeerPicker, replicas []peers.PeerGetter, key string) (ByteData, error) {
+	for _, peer := range replicas {
+		if peer == nil {
+			if bytedata, ok := g.loadFromPreviousPeer(picker, key); ok {
 				return bytedata, nil
 			}
-			log.Printf("Group.load | failed to get from peer, failed: %+v\n", err)
+			return g.loadLeased(picker, key)
+		}
+		if bytedata, err := g.loadFromPeer(peer, key); err == nil {
+			return bytedata, nil
 		}
 	}
-	return g.loadLocally(key)
+	// 所有副本都失败，在本地加载
+	return g.loadLeased(picker, key)
+}
+
+func (g *Group) loadFromPeer(peer peers.PeerGetter, key string) (ByteData, error) {
+	bytedata, err := g.GetFromPeerPicker(peer, key)
+	if err != nil {
+		g.stats.PeerErrors.Add(1)
+		errorf("Group.load | failed to get from peer, failed: %+v\n", err)
+		return ByteData{}, err
+	}
+	g.stats.PeerLoads.Add(1)
+	debugf("Group.load | get from PeerPicker successfully, data: %+v\n", bytedata.String())
+	return bytedata, nil
+}
+
+// 从加入之前的节点的缓存中查找，找到后加入本地缓存
+func (g *Group) loadFromPreviousPeer(picker peers.PeerPicker, key string) (ByteData, bool) {
+	previousPicker, ok := picker.(peers.PreviousPeerPicker)
+	if !ok {
+		return ByteData{}, false
+	}
+	peer, ok := previousPicker.PickPreviousPeer(key)
+	if !ok {
+		return ByteData{}, false
+	}
+	bytedata, err := g.GetFromPeerPicker(peer, key)
+	if err != nil {
+		errorf("Group.loadFromPreviousPeer | key: %v, err: %+v\n", key, err)
+		return ByteData{}, false
+	}
+	if ttl := g.TTL(); ttl > 0 {
+		bytedata.expire = time.Now().Add(ttl).UnixNano()
+	}
+	g.put(key, bytedata)
+	return bytedata, true
 }
 
 func (g *Group) loadLocally(key string) (ByteData, error) {
-	log.Printf("Group.loadLocally | key: %v\n", key)
-	bytedata, err := g.getter.Get(key)
+	debugf("Group.loadLocally | key: %v\n", key)
+	bytedata, err := g.callGetter(key)
 	if err != nil {
+		g.stats.LocalLoadErrs.Add(1)
 		return ByteData{}, err
 	}
+	g.stats.LocalLoads.Add(1)
 
 	val := ByteData{
 		data: cloneBytes(bytedata),
 	}
+	if ttl := g.TTL(); ttl > 0 {
+		val.expire = time.Now().Add(ttl).UnixNano()
+	}
 
 	// 添加到缓存
 	g.put(key, val)
@@ -5,6 +5,7 @@ import (
 	"log"
 	"testing"
 
+	"github.com/gy0117/gocache/disk"
 	"github.com/smartystreets/goconvey/convey"
 )
 
@@ -49,6 +50,7 @@ func TestGet(t *testing.T) {
 			}
 
 		}))
+		defer DeleteGroup("test")
 
 		convey.Convey("test bytedata success", func() {
 
@@ -69,3 +71,79 @@ func TestGet(t *testing.T) {
 		})
 	})
 }
+
+func TestArenaStorage(t *testing.T) {
+	convey.Convey("TestArenaStorage", t, func() {
+		loads := 0
+		g := NewGroup("arena", 1024, GetterFunc(func(key string) ([]byte, error) {
+			loads++
+			return []byte(key + "-value"), nil
+		}), WithStorage(StorageArena))
+		defer DeleteGroup("arena")
+
+		for i := 0; i < 2; i++ {
+			bytedata, err := g.Get("zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(bytedata.String(), convey.ShouldEqual, "zhangsan-value")
+		}
+		convey.So(loads, convey.ShouldEqual, 1)
+		convey.So(g.Stats().Hits, convey.ShouldEqual, 1)
+	})
+}
+
+func TestDiskTier(t *testing.T) {
+	convey.Convey("TestDiskTier", t, func() {
+		store, err := disk.Open(t.TempDir(), 1<<20)
+		convey.So(err, convey.ShouldBeNil)
+		defer store.Close()
+
+		loads := make(map[string]int)
+		// 每个元素 2 + 7 = 9，内存中只能放下两个
+		g := NewGroup("disk", 20, GetterFunc(func(key string) ([]byte, error) {
+			loads[key]++
+			return []byte(key + "-value"), nil
+		}), WithDiskTier(store))
+		defer DeleteGroup("disk")
+
+		for _, key := range []string{"k1", "k2", "k3", "k4"} {
+			g.Get(key)
+		}
+		convey.So(store.Len(), convey.ShouldEqual, 2)
+
+		convey.Convey("evicted entries are read back from disk", func() {
+			bytedata, err := g.Get("k1")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(bytedata.String(), convey.ShouldEqual, "k1-value")
+			convey.So(loads["k1"], convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("removed entries are not kept on disk", func() {
+			g.mainCache.remove("k4")
+			g.mainCache.remove("k1")
+			convey.So(store.Len(), convey.ShouldEqual, 1)
+
+			g.Get("k1")
+			convey.So(loads["k1"], convey.ShouldEqual, 2)
+		})
+
+		convey.Convey("entries waiting for the disk are readable and removals are not undone", func() {
+			// 模拟淘汰之后、写入磁盘之前的状态
+			c := &g.mainCache
+			c.mutex.Lock()
+			c.spill("k9", ByteData{data: []byte("k9-value")})
+			keys := c.takeSpilled()
+			c.mutex.Unlock()
+
+			bytedata, ok := c.get("k9")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(bytedata.String(), convey.ShouldEqual, "k9-value")
+
+			c.remove("k9")
+			c.flush(keys)
+			_, err := store.Get("k9")
+			convey.So(err, convey.ShouldNotBeNil)
+			_, ok = c.get("k9")
+			convey.So(ok, convey.ShouldBeFalse)
+		})
+	})
+}
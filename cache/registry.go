@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrGroupExists  = errors.New("group already exists")
+	ErrReservedName = errors.New("group name must not be empty, start with _ or contain /")
+)
+
+// 节点间的内部接口使用以_开头的路径，Group的名称也是路径的一部分
+func checkGroupName(name string) error {
+	if name == "" || strings.HasPrefix(name, "_") || strings.Contains(name, "/") {
+		return fmt.Errorf("%w: %q", ErrReservedName, name)
+	}
+	return nil
+}
+
+// 按照名称管理Group，不同的Registry之间互不影响，例如测试或者多租户的服务中各自持有一个Registry
+// 包级别的NewGroup、GetGroup等函数使用默认的Registry
+type Registry struct {
+	mutex   sync.RWMutex
+	groups  map[string]*Group
+	manager *Manager // 不为nil且设置了预算时，新的Group加入该Manager
+}
+
+// 默认的Registry，其中的Group共享进程级别的内存预算
+var defaultRegistry = &Registry{
+	groups:  make(map[string]*Group),
+	manager: defaultManager,
+}
+
+// 所有Group正在进行的加载的个数
+func (r *Registry) Inflight() int {
+	n := 0
+	for _, g := range r.Groups() {
+		n += g.Inflight()
+	}
+	return n
+}
+
+// 包级别的函数使用的Registry
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*Group)}
+}
+
+// 创建Group，同名的Group会被替换，被替换的Group不再共享内存预算；名称不合法时panic
+func (r *Registry) NewGroup(name string, capacity int64, getter Getter, opts ...GroupOption) *Group {
+	if err := checkGroupName(name); err != nil {
+		panic(err)
+	}
+	g := newGroup(name, capacity, getter, opts...)
+
+	r.mutex.Lock()
+	old := r.groups[name]
+	r.groups[name] = g
+	r.mutex.Unlock()
+
+	if old != nil {
+		old.unregister()
+	}
+	r.register(g)
+	return g
+}
+
+// 创建Group，同名的Group已经存在时返回ErrGroupExists，名称不合法时返回ErrReservedName
+func (r *Registry) CreateGroup(name string, capacity int64, getter Getter, opts ...GroupOption) (*Group, error) {
+	if err := checkGroupName(name); err != nil {
+		return nil, err
+	}
+	g := newGroup(name, capacity, getter, opts...)
+
+	r.mutex.Lock()
+	if _, ok := r.groups[name]; ok {
+		r.mutex.Unlock()
+		return nil, ErrGroupExists
+	}
+	r.groups[name] = g
+	r.mutex.Unlock()
+
+	r.register(g)
+	return g, nil
+}
+
+func (r *Registry) register(g *Group) {
+	if r.manager != nil && r.manager.Total() > 0 {
+		r.manager.Register(g, 1)
+	}
+}
+
+func (r *Registry) GetGroup(name string) *Group {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.groups[name]
+}
+
+// 删除Group，Group不存在时返回false
+// 删除后GetGroup找不到该Group，已经持有的*Group仍然可以使用，但是不再共享内存预算
+func (r *Registry) DeleteGroup(name string) bool {
+	r.mutex.Lock()
+	g, ok := r.groups[name]
+	delete(r.groups, name)
+	r.mutex.Unlock()
+
+	if ok {
+		g.unregister()
+	}
+	return ok
+}
+
+// 所有的Group，按照名称排序
+func (r *Registry) Groups() []*Group {
+	r.mutex.RLock()
+	list := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		list = append(list, g)
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+// 从所属的Manager中移除
+func (g *Group) unregister() {
+	if m := g.manager.Load(); m != nil {
+		m.Unregister(g)
+	}
+}
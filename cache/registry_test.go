@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegistry(t *testing.T) {
+	convey.Convey("TestRegistry", t, func() {
+		getter := GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key), nil
+		})
+
+		convey.Convey("reserved names are rejected", func() {
+			r := NewRegistry()
+			for _, name := range []string{"", "_leave", "_handoff", "a/b"} {
+				_, err := r.CreateGroup(name, 1024, getter)
+				convey.So(errors.Is(err, ErrReservedName), convey.ShouldBeTrue)
+				convey.So(func() { r.NewGroup(name, 1024, getter) }, convey.ShouldPanic)
+			}
+			convey.So(r.Groups(), convey.ShouldBeEmpty)
+		})
+
+		convey.Convey("duplicate names are rejected by CreateGroup", func() {
+			r := NewRegistry()
+			g, err := r.CreateGroup("scores", 1024, getter)
+			convey.So(err, convey.ShouldBeNil)
+			_, err = r.CreateGroup("scores", 2048, getter)
+			convey.So(err, convey.ShouldEqual, ErrGroupExists)
+			convey.So(r.GetGroup("scores"), convey.ShouldEqual, g)
+
+			// NewGroup替换同名的Group
+			replaced := r.NewGroup("scores", 2048, getter)
+			convey.So(r.GetGroup("scores"), convey.ShouldEqual, replaced)
+			convey.So(r.Groups(), convey.ShouldHaveLength, 1)
+		})
+
+		convey.Convey("groups can be deleted and enumerated", func() {
+			r := NewRegistry()
+			r.NewGroup("users", 1024, getter)
+			r.NewGroup("scores", 1024, getter)
+			convey.So(r.Groups()[0].Name(), convey.ShouldEqual, "scores")
+			convey.So(r.Groups()[1].Name(), convey.ShouldEqual, "users")
+
+			convey.So(r.DeleteGroup("users"), convey.ShouldBeTrue)
+			convey.So(r.DeleteGroup("users"), convey.ShouldBeFalse)
+			convey.So(r.GetGroup("users"), convey.ShouldBeNil)
+			convey.So(r.Groups(), convey.ShouldHaveLength, 1)
+		})
+
+		convey.Convey("registries are isolated from each other and the default one", func() {
+			r1, r2 := NewRegistry(), NewRegistry()
+			g1 := r1.NewGroup("registry-isolated", 1024, getter)
+			g2 := r2.NewGroup("registry-isolated", 1024, getter)
+			convey.So(r1.GetGroup("registry-isolated"), convey.ShouldEqual, g1)
+			convey.So(r2.GetGroup("registry-isolated"), convey.ShouldEqual, g2)
+			convey.So(GetGroup("registry-isolated"), convey.ShouldBeNil)
+		})
+
+		convey.Convey("deleted and replaced groups leave the memory budget", func() {
+			SetMemoryBudget(1 << 20)
+			defer SetMemoryBudget(0)
+
+			old := NewGroup("registry-budget", 1024, getter)
+			convey.So(old.manager.Load(), convey.ShouldEqual, defaultManager)
+			g := NewGroup("registry-budget", 1024, getter)
+			convey.So(old.manager.Load(), convey.ShouldBeNil)
+			convey.So(g.manager.Load(), convey.ShouldEqual, defaultManager)
+
+			convey.So(DeleteGroup("registry-budget"), convey.ShouldBeTrue)
+			convey.So(g.manager.Load(), convey.ShouldBeNil)
+			convey.So(GetGroup("registry-budget"), convey.ShouldBeNil)
+		})
+	})
+}
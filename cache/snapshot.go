@@ -0,0 +1,306 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// 快照的格式
+// | magic(4) | version(2) | 元素... | 结束标记(1) | 元素个数(uvarint) | crc32(4) |
+// 元素：| 标记(1) | keyLen(uvarint) | key | valueLen(uvarint) | value | expire(varint) | crc32(4) |
+// 元素的crc32覆盖标记之后的内容，最后的crc32覆盖之前所有的内容
+// 元素按照从最近最少使用到最近使用的顺序写入，恢复时按照相同的顺序添加，淘汰顺序不变
+
+const (
+	snapshotMagic   = "MCSN"
+	snapshotVersion = 1
+
+	snapshotEntry = 1
+	snapshotEnd   = 0
+
+	// 单个key或者value的最大长度，超过时说明长度已经损坏
+	maxSnapshotItem = 1 << 30
+)
+
+var ErrSnapshotCorrupted = errors.New("snapshot corrupted")
+
+// 把本地缓存写入w，不包括磁盘缓存和已经过期的数据
+func (g *Group) Snapshot(w io.Writer) error {
+	return g.snapshot(w, nil, 0)
+}
+
+// filter不为nil时只写入满足条件的key；limit大于0时只写入最近使用的limit个
+func (g *Group) snapshot(w io.Writer, filter func(key string) bool, limit int) error {
+	sw := newSnapshotWriter(w)
+	now := time.Now()
+
+	keys := g.mainCache.keys()
+	if filter != nil {
+		matched := keys[:0]
+		for _, key := range keys {
+			if filter(key) {
+				matched = append(matched, key)
+			}
+		}
+		keys = matched
+	}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[len(keys)-limit:]
+	}
+
+	var count uint64
+	for _, key := range keys {
+		value, ok := g.mainCache.peek(key)
+		if !ok || value.expired(now) {
+			continue
+		}
+		if err := sw.writeEntry(key, value); err != nil {
+			return err
+		}
+		count++
+	}
+	return sw.close(count)
+}
+
+// 从r中恢复本地缓存，跳过已经过期的数据，以及根据当前的一致性哈希不属于本节点的数据
+// 遇到损坏的数据时返回错误，之前的数据已经添加到缓存中
+func (g *Group) Restore(r io.Reader) (int, error) {
+	sr, err := newSnapshotReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	restored := 0
+	for {
+		key, value, err := sr.readEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return restored, err
+		}
+		if value.expired(now) || !g.owns(key) {
+			continue
+		}
+		g.put(key, value)
+		restored++
+	}
+	infof("Group.Restore | group: %v, restored: %v\n", g.name, restored)
+	return restored, nil
+}
+
+// key的归属不受负载影响的PeerPicker，有界负载时PickPeer只用于路由
+type ownerPicker interface {
+	// key所属的节点，没有节点时返回空字符串
+	owner(key string) string
+	selfPeer() string
+}
+
+// key是否属于本节点，没有注册PeerPicker时都属于本节点
+func (g *Group) owns(key string) bool {
+	picker := g.PeerPicker()
+	if picker == nil {
+		return true
+	}
+	if op, ok := picker.(ownerPicker); ok {
+		peer := op.owner(key)
+		return peer == "" || peer == op.selfPeer()
+	}
+	_, remote := picker.PickPeer(key)
+	return !remote
+}
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	err error
+}
+
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	sw := &snapshotWriter{
+		w:   bufio.NewWriter(w),
+		crc: crc32.NewIEEE(),
+	}
+	header := make([]byte, 0, 6)
+	header = append(header, snapshotMagic...)
+	header = binary.LittleEndian.AppendUint16(header, snapshotVersion)
+	sw.write(header)
+	return sw
+}
+
+// 写入的内容同时计算整体的crc32
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	sw.crc.Write(p)
+	_, sw.err = sw.w.Write(p)
+}
+
+func (sw *snapshotWriter) writeEntry(key string, value ByteData) error {
+	buf := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(key)+len(value.data)+binary.MaxVarintLen64+4)
+	buf = append(buf, snapshotEntry)
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = binary.AppendUvarint(buf, uint64(len(value.data)))
+	buf = append(buf, value.data...)
+	buf = binary.AppendVarint(buf, value.expire)
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[1:]))
+	sw.write(buf)
+	return sw.err
+}
+
+func (sw *snapshotWriter) close(count uint64) error {
+	buf := []byte{snapshotEnd}
+	buf = binary.AppendUvarint(buf, count)
+	sw.write(buf)
+	if sw.err != nil {
+		return sw.err
+	}
+
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], sw.crc.Sum32())
+	if _, err := sw.w.Write(sum[:]); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+type snapshotReader struct {
+	r     *bufio.Reader
+	crc   hash.Hash32
+	count uint64
+	entry []byte // 当前元素已经读取的内容，用于计算元素的crc32
+}
+
+func newSnapshotReader(r io.Reader) (*snapshotReader, error) {
+	sr := &snapshotReader{
+		r:   bufio.NewReader(r),
+		crc: crc32.NewIEEE(),
+	}
+
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(sr.r, header); err != nil {
+		return nil, fmt.Errorf("read snapshot header: %w", err)
+	}
+	sr.crc.Write(header)
+	if string(header[:4]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: bad magic %q", ErrSnapshotCorrupted, header[:4])
+	}
+	if version := binary.LittleEndian.Uint16(header[4:]); version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %v", version)
+	}
+	return sr, nil
+}
+
+// io.ByteReader，读取的内容同时计算crc32
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, err := sr.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	sr.crc.Write([]byte{b})
+	sr.entry = append(sr.entry, b)
+	return b, nil
+}
+
+// n来自还没有校验的数据，超过上限时返回ErrSnapshotCorrupted；
+// 缓冲区随着读到的数据增长，截断的数据不会按照n分配内存
+func (sr *snapshotReader) readFull(n uint64) ([]byte, error) {
+	if n > maxSnapshotItem {
+		return nil, fmt.Errorf("%w: length %v exceeds the limit", ErrSnapshotCorrupted, n)
+	}
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, sr.r, int64(n)); err != nil {
+		return nil, err
+	}
+	buf := b.Bytes()
+	sr.crc.Write(buf)
+	sr.entry = append(sr.entry, buf...)
+	return buf, nil
+}
+
+// 读取一个元素，读到结束标记并且校验通过时返回io.EOF
+func (sr *snapshotReader) readEntry() (string, ByteData, error) {
+	flag, err := sr.ReadByte()
+	if err != nil {
+		return "", ByteData{}, sr.corrupted(err)
+	}
+	sr.entry = sr.entry[:0]
+
+	if flag == snapshotEnd {
+		return "", ByteData{}, sr.readTrailer()
+	}
+	if flag != snapshotEntry {
+		return "", ByteData{}, fmt.Errorf("%w: unknown flag %v", ErrSnapshotCorrupted, flag)
+	}
+
+	keyLen, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return "", ByteData{}, sr.corrupted(err)
+	}
+	key, err := sr.readFull(keyLen)
+	if err != nil {
+		return "", ByteData{}, sr.corrupted(err)
+	}
+	valueLen, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return "", ByteData{}, sr.corrupted(err)
+	}
+	data, err := sr.readFull(valueLen)
+	if err != nil {
+		return "", ByteData{}, sr.corrupted(err)
+	}
+	expire, err := binary.ReadVarint(sr)
+	if err != nil {
+		return "", ByteData{}, sr.corrupted(err)
+	}
+
+	want := crc32.ChecksumIEEE(sr.entry)
+	sum, err := sr.readFull(4)
+	if err != nil {
+		return "", ByteData{}, sr.corrupted(err)
+	}
+	if binary.LittleEndian.Uint32(sum) != want {
+		return "", ByteData{}, fmt.Errorf("%w: checksum mismatch for key %q", ErrSnapshotCorrupted, key)
+	}
+
+	sr.count++
+	return string(key), ByteData{data: data, expire: expire}, nil
+}
+
+func (sr *snapshotReader) readTrailer() error {
+	count, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return sr.corrupted(err)
+	}
+	want := sr.crc.Sum32()
+
+	var sum [4]byte
+	if _, err := io.ReadFull(sr.r, sum[:]); err != nil {
+		return sr.corrupted(err)
+	}
+	if binary.LittleEndian.Uint32(sum[:]) != want {
+		return fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupted)
+	}
+	if count != sr.count {
+		return fmt.Errorf("%w: expect %v entries, got %v", ErrSnapshotCorrupted, count, sr.count)
+	}
+	return io.EOF
+}
+
+// 数据不完整时，统一返回ErrSnapshotCorrupted
+func (sr *snapshotReader) corrupted(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: unexpected end of snapshot", ErrSnapshotCorrupted)
+	}
+	return err
+}
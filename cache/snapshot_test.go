@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gy0117/gocache/pb"
+	"github.com/gy0117/gocache/peers"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// 以remote为前缀的key属于其他节点
+type prefixPicker struct{}
+
+func (prefixPicker) PickPeer(key string) (peers.PeerGetter, bool) {
+	if strings.HasPrefix(key, "remote") {
+		return failGetter{}, true
+	}
+	return nil, false
+}
+
+type failGetter struct{}
+
+func (failGetter) Get(in *pb.Request, out *pb.Response) error {
+	return errors.New("unreachable")
+}
+
+func TestSnapshot(t *testing.T) {
+	convey.Convey("TestSnapshot", t, func() {
+		getter := GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key + "-value"), nil
+		})
+		src := NewGroup("snapshot-src", 0, getter)
+		for _, key := range []string{"k1", "k2", "remote-k3", "k4"} {
+			src.put(key, ByteData{data: []byte(key + "-value")})
+		}
+		src.put("ttl", ByteData{data: []byte("ttl-value"), expire: time.Now().Add(time.Hour).UnixNano()})
+		src.put("expired", ByteData{data: []byte("expired-value"), expire: time.Now().Add(-time.Second).UnixNano()})
+		src.mainCache.get("k1")
+
+		var buf bytes.Buffer
+		convey.So(src.Snapshot(&buf), convey.ShouldBeNil)
+
+		convey.Convey("restore keeps order and ttl, skips expired and foreign keys", func() {
+			dst := NewGroup("snapshot-dst", 0, getter)
+			dst.RegisterPeerPicker(prefixPicker{})
+
+			n, err := dst.Restore(bytes.NewReader(buf.Bytes()))
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(n, convey.ShouldEqual, 4)
+			convey.So(dst.mainCache.keys(), convey.ShouldResemble, []string{"k2", "k4", "ttl", "k1"})
+
+			value, ok := dst.mainCache.peek("ttl")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(value.Expire().After(time.Now()), convey.ShouldBeTrue)
+		})
+
+		convey.Convey("corrupted snapshot is rejected", func() {
+			data := buf.Bytes()
+			data[10] ^= 0xff
+
+			dst := NewGroup("snapshot-corrupted", 0, getter)
+			_, err := dst.Restore(bytes.NewReader(data))
+			convey.So(errors.Is(err, ErrSnapshotCorrupted), convey.ShouldBeTrue)
+		})
+
+		convey.Convey("truncated snapshot is rejected", func() {
+			dst := NewGroup("snapshot-truncated", 0, getter)
+			_, err := dst.Restore(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+			convey.So(errors.Is(err, ErrSnapshotCorrupted), convey.ShouldBeTrue)
+		})
+
+		convey.Convey("corrupted length prefix is rejected without allocating", func() {
+			dst := NewGroup("snapshot-length", 0, getter)
+			for _, n := range []uint64{1<<64 - 1, maxSnapshotItem + 1, maxSnapshotItem} {
+				data := append([]byte(snapshotMagic), snapshotVersion, 0, snapshotEntry)
+				data = binary.AppendUvarint(data, n)
+				data = append(data, "short"...)
+				_, err := dst.Restore(bytes.NewReader(data))
+				convey.So(errors.Is(err, ErrSnapshotCorrupted), convey.ShouldBeTrue)
+			}
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package cache
+
+import "sync/atomic"
+
+// Group的统计数据
+type Stats struct {
+	Gets          int64 // 请求次数
+	Hits          int64 // 本地缓存命中次数
+	Loads         int64 // 未命中，需要加载的次数，经过singleflight合并
+	PeerLoads     int64 // 从远程节点加载成功的次数
+	PeerErrors    int64 // 从远程节点加载失败的次数
+	LocalLoads    int64 // 从Getter加载成功的次数
+	LocalLoadErrs int64 // 从Getter加载失败的次数
+	LeaseWaits    int64 // 租约被其他节点持有，需要等待的次数
+	StaleHits     int64 // 等待租约时返回过期数据的次数
+	LoadRetries   int64 // 调用Getter失败后重试的次数
+	LoadTimeouts  int64 // 调用Getter或者等待并发数超时的次数
+	LoadRejects   int64 // 并发数和等待的个数都已满，拒绝加载的次数
+	LoadsActive   int64 // 正在调用Getter的个数
+	LoadsQueued   int64 // 正在等待并发数的个数，即队列深度
+	CacheBytes    int64 // 本地缓存已经使用的容量
+	CacheCapacity int64 // 本地缓存的容量
+}
+
+type groupStats struct {
+	Gets          atomic.Int64
+	Hits          atomic.Int64
+	Loads         atomic.Int64
+	PeerLoads     atomic.Int64
+	PeerErrors    atomic.Int64
+	LocalLoads    atomic.Int64
+	LocalLoadErrs atomic.Int64
+	LeaseWaits    atomic.Int64
+	StaleHits     atomic.Int64
+	LoadRetries   atomic.Int64
+	LoadTimeouts  atomic.Int64
+	LoadRejects   atomic.Int64
+}
+
+func (g *Group) Stats() Stats {
+	return Stats{
+		Gets:          g.stats.Gets.Load(),
+		Hits:          g.stats.Hits.Load(),
+		Loads:         g.stats.Loads.Load(),
+		PeerLoads:     g.stats.PeerLoads.Load(),
+		PeerErrors:    g.stats.PeerErrors.Load(),
+		LocalLoads:    g.stats.LocalLoads.Load(),
+		LocalLoadErrs: g.stats.LocalLoadErrs.Load(),
+		LeaseWaits:    g.stats.LeaseWaits.Load(),
+		StaleHits:     g.stats.StaleHits.Load(),
+		LoadRetries:   g.stats.LoadRetries.Load(),
+		LoadTimeouts:  g.stats.LoadTimeouts.Load(),
+		LoadRejects:   g.stats.LoadRejects.Load(),
+		LoadsActive:   g.limiter.active.Load(),
+		LoadsQueued:   g.limiter.queued.Load(),
+		CacheBytes:    g.mainCache.bytes(),
+		CacheCapacity: g.mainCache.capacity(),
+	}
+}
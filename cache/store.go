@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"github.com/gy0117/gocache/arena"
+	"github.com/gy0117/gocache/lru"
+)
+
+// 本地缓存的存储方式
+type Storage int
+
+const (
+	// lru.Cache，链表加map，严格的LRU
+	StorageLRU Storage = iota
+	// arena.Cache，数据存放在预分配的字节数组中，CLOCK淘汰，GC扫描的代价小，适合大容量
+	StorageArena
+)
+
+// arena需要预分配字节数组，容量为0时使用的默认大小
+const defaultArenaCapacity = 64 << 20
+
+// cacheInner的存储后端，不需要并发安全，由cacheInner加锁
+type store interface {
+	get(key string) (ByteData, bool)
+	peek(key string) (ByteData, bool)
+	add(key string, value ByteData) bool
+	remove(key string) bool
+	removeOldest()
+	resize(capacity int64)
+	len() int
+	bytes() int64
+	keys() []string
+	clear()
+	// 淘汰、删除时回调
+	setDeleteHandler(handler func(key string, value ByteData))
+}
+
+func newStore(storage Storage, capacity int64, overhead int64) store {
+	switch storage {
+	case StorageArena:
+		if capacity <= 0 {
+			capacity = defaultArenaCapacity
+		}
+		return &arenaStore{arena.New(capacity)}
+	default:
+		c := lru.New(capacity)
+		c.SetEntryOverhead(overhead)
+		return &lruStore{c}
+	}
+}
+
+type lruStore struct {
+	c *lru.Cache
+}
+
+func (s *lruStore) get(key string) (ByteData, bool) {
+	if val, ok := s.c.Get(key); ok {
+		return val.(ByteData), true
+	}
+	return ByteData{}, false
+}
+
+func (s *lruStore) peek(key string) (ByteData, bool) {
+	if val, ok := s.c.Peek(key); ok {
+		return val.(ByteData), true
+	}
+	return ByteData{}, false
+}
+
+func (s *lruStore) add(key string, value ByteData) bool { return s.c.Add(key, value) }
+func (s *lruStore) remove(key string) bool              { return s.c.Remove(key) }
+func (s *lruStore) removeOldest()                       { s.c.RemoveOldElement() }
+func (s *lruStore) resize(capacity int64)               { s.c.Resize(capacity) }
+func (s *lruStore) len() int                            { return s.c.Len() }
+func (s *lruStore) bytes() int64                        { return s.c.UsedCapacity() }
+func (s *lruStore) keys() []string                      { return s.c.Keys() }
+func (s *lruStore) clear()                              { s.c.Clear() }
+
+func (s *lruStore) setDeleteHandler(handler func(key string, value ByteData)) {
+	s.c.SetDeleteHandler(func(key string, value lru.Value) {
+		handler(key, value.(ByteData))
+	})
+}
+
+// arena的容量就是字节数组的大小，已使用的容量包括元素的header和等待回收的空间
+// value按照encodeByteData编码，包括过期时间
+type arenaStore struct {
+	c *arena.Cache
+}
+
+func (s *arenaStore) get(key string) (ByteData, bool) {
+	if val, ok := s.c.Get(key); ok {
+		return decodeByteData(val), true
+	}
+	return ByteData{}, false
+}
+
+func (s *arenaStore) peek(key string) (ByteData, bool) {
+	if val, ok := s.c.Peek(key); ok {
+		return decodeByteData(val), true
+	}
+	return ByteData{}, false
+}
+
+func (s *arenaStore) add(key string, value ByteData) bool {
+	return s.c.Set(key, encodeByteData(value))
+}
+
+func (s *arenaStore) remove(key string) bool { return s.c.Remove(key) }
+func (s *arenaStore) removeOldest()          { s.c.RemoveOldElement() }
+func (s *arenaStore) len() int               { return s.c.Len() }
+func (s *arenaStore) bytes() int64           { return s.c.OccupiedCapacity() }
+func (s *arenaStore) keys() []string         { return s.c.Keys() }
+func (s *arenaStore) clear()                 { s.c.Clear() }
+
+func (s *arenaStore) setDeleteHandler(handler func(key string, value ByteData)) {
+	s.c.SetDeleteHandler(func(key string, value []byte) {
+		handler(key, decodeByteData(value))
+	})
+}
+
+func (s *arenaStore) resize(capacity int64) {
+	if capacity <= 0 {
+		capacity = defaultArenaCapacity
+	}
+	s.c.Resize(capacity)
+}
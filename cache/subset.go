@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gy0117/gocache/consistenthash"
+	"github.com/gy0117/gocache/peers"
+)
+
+// 节点子集从所属的HttpPool或者GrpcPool获取连接、副本数和放置算法
+type peerSource interface {
+	selfPeer() string
+	placeSubset(weights map[string]int) consistenthash.Placement
+	subsetGetter(peer string) (peers.PeerGetter, *peerHealth, bool)
+	replicaCount() int
+}
+
+// 命名的节点子集，作为一部分Group的PeerPicker，使这些Group只分布在这些节点上
+// 与所属的HttpPool或者GrpcPool共享连接和健康状态，所属的节点列表中没有的节点按照本节点处理
+type Subset struct {
+	name   string
+	source peerSource
+
+	mutex    sync.Mutex
+	weights  map[string]int
+	peersMap consistenthash.Placement
+}
+
+func (s *Subset) Name() string {
+	return s.name
+}
+
+// 子集中的节点，按照名称排序
+func (s *Subset) Peers() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := make([]string, 0, len(s.weights))
+	for peer := range s.weights {
+		list = append(list, peer)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// 设置子集中的节点及其权重，使用所属的HttpPool或者GrpcPool当前的放置算法
+func (s *Subset) SetWeighted(weights map[string]int) {
+	copied := make(map[string]int, len(weights))
+	for peer, weight := range weights {
+		copied[peer] = weight
+	}
+	peersMap := s.source.placeSubset(copied)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.weights = copied
+	s.peersMap = peersMap
+}
+
+func (s *Subset) placement() consistenthash.Placement {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.peersMap
+}
+
+// 实现PeerPicker接口
+func (s *Subset) PickPeer(key string) (peers.PeerGetter, bool) {
+	peersMap := s.placement()
+	if peersMap == nil {
+		return nil, false
+	}
+	peer := peersMap.Get(key)
+	if peer == "" || peer == s.source.selfPeer() {
+		return nil, false
+	}
+	getter, _, ok := s.source.subsetGetter(peer)
+	return getter, ok
+}
+
+// 实现ownerPicker接口，使用不依赖负载的放置
+func (s *Subset) owner(key string) string {
+	peersMap := s.placement()
+	if peersMap == nil {
+		return ""
+	}
+	return consistenthash.Stable(peersMap).Get(key)
+}
+
+// 实现ReplicaPicker接口，不健康的节点排在后面，本节点对应nil
+func (s *Subset) PickReplicas(key string) []peers.PeerGetter {
+	return orderReplicas(s.source.selfPeer(), s.replicas(key), s.source.subsetGetter)
+}
+
+// key的所有副本，按照放置算法的顺序
+func (s *Subset) replicas(key string) []string {
+	peersMap := s.placement()
+	if peersMap == nil {
+		return nil
+	}
+	return peersMap.GetN(key, s.source.replicaCount())
+}
+
+// 按照replicas的顺序返回副本，不健康的节点排在后面，本节点对应nil，找不到连接的节点被跳过
+func orderReplicas(self string, replicas []string, lookup func(peer string) (peers.PeerGetter, *peerHealth, bool)) []peers.PeerGetter {
+	var healthy, unhealthy []peers.PeerGetter
+	now := time.Now()
+	for _, peer := range replicas {
+		if peer == self {
+			healthy = append(healthy, nil)
+			continue
+		}
+		getter, health, ok := lookup(peer)
+		if !ok {
+			continue
+		}
+		if health.healthy(now) {
+			healthy = append(healthy, getter)
+		} else {
+			unhealthy = append(unhealthy, getter)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// HttpPool和GrpcPool中的所有子集
+type subsets struct {
+	mutex sync.Mutex
+	m     map[string]*Subset
+}
+
+func (ss *subsets) set(source peerSource, name string, weights map[string]int) *Subset {
+	ss.mutex.Lock()
+	subset, ok := ss.m[name]
+	if !ok {
+		if ss.m == nil {
+			ss.m = make(map[string]*Subset)
+		}
+		subset = &Subset{name: name, source: source}
+		ss.m[name] = subset
+	}
+	ss.mutex.Unlock()
+
+	subset.SetWeighted(weights)
+	return subset
+}
+
+func (ss *subsets) get(name string) *Subset {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	return ss.m[name]
+}
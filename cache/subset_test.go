@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestSubset(t *testing.T) {
+	convey.Convey("TestSubset", t, func() {
+		getter := func(node string) Getter {
+			return GetterFunc(func(key string) ([]byte, error) {
+				return []byte(node + "-" + key), nil
+			})
+		}
+		remoteRegistry := NewRegistry()
+		remoteRegistry.NewGroup("subset", 0, getter("remote"))
+		remotePool := NewHttpPool("")
+		remotePool.SetRegistry(remoteRegistry)
+		server := httptest.NewServer(remotePool)
+		defer server.Close()
+
+		self := "http://self"
+		dead := "http://127.0.0.1:1"
+		pool := NewHttpPool(self)
+		pool.Set(self, server.URL, dead)
+		g := NewRegistry().NewGroup("subset", 0, getter("local"))
+
+		convey.Convey("a subset only picks its own peers", func() {
+			subset := pool.SetSubset("remote-only", map[string]int{server.URL: 1})
+			convey.So(pool.Subset("remote-only"), convey.ShouldEqual, subset)
+			convey.So(subset.Peers(), convey.ShouldResemble, []string{server.URL})
+			for i := 0; i < 10; i++ {
+				peer, ok := subset.PickPeer(fmt.Sprintf("key-%d", i))
+				convey.So(ok, convey.ShouldBeTrue)
+				convey.So(peer, convey.ShouldEqual, pool.httpGetters[server.URL])
+			}
+
+			g.RegisterPeerPicker(subset)
+			value, err := g.Get("zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(value.String(), convey.ShouldEqual, "remote-zhangsan")
+
+			// 更新子集，本节点和不在节点列表中的节点按照本节点处理
+			pool.SetSubset("remote-only", map[string]int{self: 1, "http://unknown": 1})
+			pool.SetReplicas(2)
+			for i := 0; i < 10; i++ {
+				_, ok := subset.PickPeer(fmt.Sprintf("key-%d", i))
+				convey.So(ok, convey.ShouldBeFalse)
+				replicas := subset.PickReplicas(fmt.Sprintf("key-%d", i))
+				convey.So(replicas, convey.ShouldHaveLength, 1)
+				convey.So(replicas[0], convey.ShouldBeNil)
+			}
+		})
+
+		convey.Convey("the picker can be swapped at runtime", func() {
+			g.RegisterPeerPicker(pool.SetSubset("remote-only", map[string]int{server.URL: 1}))
+			convey.So(func() { g.RegisterPeerPicker(pool) }, convey.ShouldPanic)
+
+			old := g.SetPeerPicker(nil)
+			convey.So(old, convey.ShouldEqual, pool.Subset("remote-only"))
+			value, err := g.Get("lisi")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(value.String(), convey.ShouldEqual, "local-lisi")
+
+			convey.So(g.SetPeerPicker(old), convey.ShouldBeNil)
+			value, err = g.Get("wangwu")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(value.String(), convey.ShouldEqual, "remote-wangwu")
+		})
+	})
+}
@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gy0117/gocache/consistenthash"
+	"github.com/gy0117/gocache/peers"
+)
+
+// 查看集群拓扑的只读接口，返回JSON
+const (
+	peersPath = "_peers" // /_marscache/_peers，本节点知道的所有节点
+	ownerPath = "_owner" // /_marscache/_owner?group=&key=，key所属的节点
+	ringPath  = "_ring"  // /_marscache/_ring，每个节点占哈希空间的比例
+)
+
+type PeerInfo struct {
+	Peer     string `json:"peer"`
+	Weight   int    `json:"weight"`
+	Self     bool   `json:"self"`
+	Healthy  bool   `json:"healthy"`
+	Inflight int64  `json:"inflight"`
+}
+
+type OwnerInfo struct {
+	Group    string   `json:"group"`
+	Key      string   `json:"key"`
+	Owner    string   `json:"owner"`
+	Replicas []string `json:"replicas"`
+	Self     bool     `json:"self"` // 本节点是否是副本之一
+}
+
+type RingInfo struct {
+	Peers    []RingShare `json:"peers"`
+	Segments int         `json:"segments"` // 虚拟节点数
+}
+
+type RingShare struct {
+	Peer     string  `json:"peer"`
+	Weight   int     `json:"weight"`
+	Segments int     `json:"segments"`
+	Share    float64 `json:"share"` // 占整个哈希空间的比例
+}
+
+// 本节点知道的所有节点，按照名称排序
+func (hp *HttpPool) Peers() []PeerInfo {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	now := time.Now()
+	infos := make([]PeerInfo, 0, len(hp.weights))
+	for peer, weight := range hp.weights {
+		infos = append(infos, PeerInfo{
+			Peer:     peer,
+			Weight:   weight,
+			Self:     peer == hp.hostPort,
+			Healthy:  peer == hp.hostPort || hp.httpGetters[peer].health.healthy(now),
+			Inflight: hp.peerLoad(peer),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Peer < infos[j].Peer })
+	return infos
+}
+
+// key所属的节点和所有的副本，与PickReplicas不同，按照放置算法的顺序，不考虑健康状态
+// Group使用本HttpPool的节点子集时按照子集计算，只在本地加载的Group属于本节点
+func (hp *HttpPool) Owner(group, key string) OwnerInfo {
+	info := OwnerInfo{Group: group, Key: key, Replicas: []string{}}
+
+	var replicas []string
+	var picker peers.PeerPicker = hp
+	if g := hp.getGroup(group); g != nil {
+		picker = g.PeerPicker()
+	}
+	switch picker := picker.(type) {
+	case nil:
+		replicas = []string{hp.hostPort}
+	case *Subset:
+		if picker.source == hp {
+			replicas = picker.replicas(key)
+		} else {
+			replicas = hp.replicasOf(key)
+		}
+	default:
+		replicas = hp.replicasOf(key)
+	}
+	if len(replicas) > 0 {
+		info.Replicas = replicas
+		info.Owner = replicas[0]
+	}
+	for _, peer := range info.Replicas {
+		if peer == hp.hostPort {
+			info.Self = true
+		}
+	}
+	return info
+}
+
+func (hp *HttpPool) replicasOf(key string) []string {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	if hp.peersMap == nil {
+		return nil
+	}
+	return consistenthash.Stable(hp.peersMap).GetN(key, hp.replicas)
+}
+
+// 每个节点占哈希空间的比例，放置算法不是哈希环时返回false
+func (hp *HttpPool) Ring() (RingInfo, bool) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	if hp.peersMap == nil {
+		return RingInfo{Peers: []RingShare{}}, true
+	}
+	ring, ok := hp.peersMap.(interface {
+		Segments() []consistenthash.Segment
+	})
+	if !ok {
+		return RingInfo{}, false
+	}
+
+	segments := ring.Segments()
+	shares := consistenthash.Shares(segments)
+	counts := make(map[string]int)
+	for _, s := range segments {
+		counts[s.Node]++
+	}
+
+	info := RingInfo{Peers: []RingShare{}, Segments: len(segments)}
+	for peer, weight := range hp.weights {
+		info.Peers = append(info.Peers, RingShare{
+			Peer:     peer,
+			Weight:   weight,
+			Segments: counts[peer],
+			Share:    shares[peer],
+		})
+	}
+	sort.Slice(info.Peers, func(i, j int) bool { return info.Peers[i].Peer < info.Peers[j].Peer })
+	return info, true
+}
+
+func (p *HttpPool) serveTopology(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var result interface{}
+	switch name {
+	case peersPath:
+		result = p.Peers()
+	case ownerPath:
+		query := r.URL.Query()
+		group, key := query.Get("group"), query.Get("key")
+		if key == "" {
+			http.Error(w, "key is required", http.StatusBadRequest)
+			return
+		}
+		if group != "" && p.getGroup(group) == nil {
+			http.Error(w, "no such group: "+group, http.StatusNotFound)
+			return
+		}
+		result = p.Owner(group, key)
+	case ringPath:
+		info, ok := p.Ring()
+		if !ok {
+			http.Error(w, "placement is not a hash ring", http.StatusNotImplemented)
+			return
+		}
+		result = info
+	}
+	writeJSON(w, result)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
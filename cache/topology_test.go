@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gy0117/gocache/consistenthash"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func getJSON(url string, v interface{}) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		json.NewDecoder(resp.Body).Decode(v)
+	}
+	return resp.StatusCode
+}
+
+func TestTopology(t *testing.T) {
+	convey.Convey("TestTopology", t, func() {
+		getter := GetterFunc(func(key string) ([]byte, error) {
+			return []byte(key), nil
+		})
+		g := NewGroup("topology", 0, getter)
+		defer DeleteGroup("topology")
+
+		pool := NewHttpPool("")
+		server := httptest.NewServer(pool)
+		defer server.Close()
+		pool.hostPort = server.URL
+		peer := "http://127.0.0.1:1"
+		pool.SetWeighted(map[string]int{server.URL: 1, peer: 3})
+		pool.SetReplicas(2)
+		g.RegisterPeerPicker(pool)
+		base := server.URL + CACHE_BASE_PATH
+
+		convey.Convey("peers", func() {
+			var peers []PeerInfo
+			convey.So(getJSON(base+peersPath, &peers), convey.ShouldEqual, http.StatusOK)
+			convey.So(peers, convey.ShouldHaveLength, 2)
+			for _, info := range peers {
+				convey.So(info.Self, convey.ShouldEqual, info.Peer == server.URL)
+				convey.So(info.Healthy, convey.ShouldBeTrue)
+			}
+		})
+
+		convey.Convey("owner", func() {
+			var owner OwnerInfo
+			convey.So(getJSON(base+ownerPath+"?group=topology&key=zhangsan", &owner), convey.ShouldEqual, http.StatusOK)
+			convey.So(owner.Owner, convey.ShouldEqual, newRing(pool.weights).Get("zhangsan"))
+			convey.So(owner.Replicas, convey.ShouldHaveLength, 2)
+			convey.So(owner.Self, convey.ShouldBeTrue)
+
+			convey.So(getJSON(base+ownerPath+"?group=missing&key=zhangsan", &owner), convey.ShouldEqual, http.StatusNotFound)
+			convey.So(getJSON(base+ownerPath+"?group=topology", &owner), convey.ShouldEqual, http.StatusBadRequest)
+		})
+
+		convey.Convey("owner follows the group's peer picker", func() {
+			local := NewGroup("topology-local", 0, getter)
+			defer DeleteGroup("topology-local")
+			var owner OwnerInfo
+			convey.So(getJSON(base+ownerPath+"?group=topology-local&key=zhangsan", &owner), convey.ShouldEqual, http.StatusOK)
+			convey.So(owner.Replicas, convey.ShouldResemble, []string{server.URL})
+
+			local.SetPeerPicker(pool.SetSubset("remote", map[string]int{peer: 1}))
+			convey.So(getJSON(base+ownerPath+"?group=topology-local&key=zhangsan", &owner), convey.ShouldEqual, http.StatusOK)
+			convey.So(owner.Replicas, convey.ShouldResemble, []string{peer})
+			convey.So(owner.Self, convey.ShouldBeFalse)
+		})
+
+		convey.Convey("ring shares follow the weights", func() {
+			var ring RingInfo
+			convey.So(getJSON(base+ringPath, &ring), convey.ShouldEqual, http.StatusOK)
+			convey.So(ring.Segments, convey.ShouldEqual, 4*REPLICS_PEERS)
+			convey.So(ring.Peers, convey.ShouldHaveLength, 2)
+			total := 0.0
+			for _, share := range ring.Peers {
+				convey.So(share.Segments, convey.ShouldEqual, share.Weight*REPLICS_PEERS)
+				total += share.Share
+			}
+			convey.So(total, convey.ShouldAlmostEqual, 1, 1e-9)
+
+			pool.SetPlacement(func(weights map[string]int) consistenthash.Placement {
+				return consistenthash.NewJump(weights)
+			})
+			pool.SetWeighted(map[string]int{server.URL: 1, peer: 3})
+			convey.So(getJSON(base+ringPath, &ring), convey.ShouldEqual, http.StatusNotImplemented)
+		})
+	})
+}
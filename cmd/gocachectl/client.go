@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gy0117/gocache/cache"
+	"github.com/gy0117/gocache/pb"
+)
+
+// 通过节点间的HTTP协议访问集群
+// 节点只在本地加载，不再转发，所以先向server查询key所属的节点，再直接请求该节点
+type client struct {
+	server    string // 任意一个节点，例如：http://127.0.0.1:8001
+	admin     string // 管理接口所在的地址，例如：http://127.0.0.1:9999
+	token     string // 管理接口的令牌
+	peerToken string // 节点之间共享的令牌，写入和删除时使用
+	http      *http.Client
+}
+
+func newClient(server, admin, token, peerToken string) *client {
+	return &client{
+		server:    strings.TrimSuffix(server, "/"),
+		admin:     strings.TrimSuffix(admin, "/"),
+		token:     token,
+		peerToken: peerToken,
+		http:      http.DefaultClient,
+	}
+}
+
+// token不为空时写入Authorization
+func (c *client) do(method, u string, body []byte, token string) ([]byte, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%v %v: %v %v", method, u, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+func (c *client) getJSON(u string, token string, v interface{}) error {
+	b, err := c.do(http.MethodGet, u, nil, token)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (c *client) peers() ([]cache.PeerInfo, error) {
+	var peers []cache.PeerInfo
+	err := c.getJSON(c.server+cache.CACHE_BASE_PATH+"_peers", "", &peers)
+	return peers, err
+}
+
+func (c *client) owner(group, key string) (cache.OwnerInfo, error) {
+	var owner cache.OwnerInfo
+	query := url.Values{"group": {group}, "key": {key}}
+	err := c.getJSON(c.server+cache.CACHE_BASE_PATH+"_owner?"+query.Encode(), "", &owner)
+	if err == nil && owner.Owner == "" {
+		err = fmt.Errorf("no peers")
+	}
+	return owner, err
+}
+
+func keyURL(peer, group, key string) string {
+	return fmt.Sprintf("%v%v%v/%v", peer, cache.CACHE_BASE_PATH, url.QueryEscape(group), url.QueryEscape(key))
+}
+
+// 从key所属的节点读取，缓存未命中时由该节点加载
+func (c *client) get(group, key string) ([]byte, error) {
+	owner, err := c.owner(group, key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := c.do(http.MethodGet, keyURL(owner.Owner, group, key), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	out := &pb.Response{}
+	if err := proto.Unmarshal(b, out); err != nil {
+		return nil, fmt.Errorf("proto.Unmarshal response body: %v", err)
+	}
+	return out.GetValue(), nil
+}
+
+// 写入所有的副本
+func (c *client) set(group, key string, value []byte) error {
+	owner, err := c.owner(group, key)
+	if err != nil {
+		return err
+	}
+	body, err := proto.Marshal(&pb.Response{Value: value})
+	if err != nil {
+		return err
+	}
+	for _, peer := range owner.Replicas {
+		if _, err := c.do(http.MethodPut, keyURL(peer, group, key), body, c.peerToken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从所有的副本删除
+func (c *client) del(group, key string) error {
+	owner, err := c.owner(group, key)
+	if err != nil {
+		return err
+	}
+	for _, peer := range owner.Replicas {
+		if _, err := c.do(http.MethodDelete, keyURL(peer, group, key), nil, c.peerToken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *client) adminURL(path string) (string, error) {
+	if c.admin == "" {
+		return "", fmt.Errorf("-admin is required")
+	}
+	return c.admin + cache.ADMIN_BASE_PATH + path, nil
+}
+
+func (c *client) stats(group string) ([]cache.GroupInfo, error) {
+	if group != "" {
+		u, err := c.adminURL("groups/" + url.PathEscape(group))
+		if err != nil {
+			return nil, err
+		}
+		var info cache.GroupInfo
+		err = c.getJSON(u, c.token, &info)
+		return []cache.GroupInfo{info}, err
+	}
+
+	u, err := c.adminURL("groups")
+	if err != nil {
+		return nil, err
+	}
+	var infos []cache.GroupInfo
+	err = c.getJSON(u, c.token, &infos)
+	return infos, err
+}
+
+func (c *client) flush(group string) error {
+	u, err := c.adminURL("groups/" + url.PathEscape(group) + "/flush")
+	if err != nil {
+		return err
+	}
+	_, err = c.do(http.MethodPost, u, nil, c.token)
+	return err
+}
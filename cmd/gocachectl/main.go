@@ -0,0 +1,271 @@
+// gocachectl 是查看和操作gocache集群的命令行工具
+//
+//	gocachectl [flags] get <group> <key>
+//	gocachectl [flags] set <group> <key> <value>  需要 -peer-token
+//	gocachectl [flags] del <group> <key>          需要 -peer-token
+//	gocachectl [flags] mget <group> <key>...
+//	gocachectl [flags] owner <group> <key>
+//	gocachectl [flags] peers
+//	gocachectl [flags] stats [group]      需要 -admin
+//	gocachectl [flags] flush <group>      需要 -admin，只清空该节点
+//	gocachectl [flags] bench <group> [-n 1000] [-c 8] [-keys 100]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "gocachectl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("gocachectl", flag.ContinueOnError)
+	server := fs.String("server", "http://127.0.0.1:8001", "address of any cache peer")
+	admin := fs.String("admin", "", "address of the admin api, e.g. http://127.0.0.1:9999")
+	token := fs.String("token", os.Getenv("GOCACHE_ADMIN_TOKEN"), "bearer token for the admin api")
+	peerToken := fs.String("peer-token", os.Getenv("GOCACHE_PEER_TOKEN"), "token shared by the peers, required by set and del")
+	output := fs.String("o", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format: %v", *output)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("missing command")
+	}
+
+	c := newClient(*server, *admin, *token, *peerToken)
+	p := &printer{w: stdout, json: *output == "json"}
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+
+	switch cmd {
+	case "get":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: get <group> <key>")
+		}
+		value, err := c.get(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		return p.values([]keyValue{{Key: args[1], Value: string(value)}})
+	case "mget":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: mget <group> <key>...")
+		}
+		var kvs []keyValue
+		for _, key := range args[1:] {
+			kv := keyValue{Key: key}
+			if value, err := c.get(args[0], key); err != nil {
+				kv.Error = err.Error()
+			} else {
+				kv.Value = string(value)
+			}
+			kvs = append(kvs, kv)
+		}
+		return p.values(kvs)
+	case "set":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: set <group> <key> <value>")
+		}
+		return c.set(args[0], args[1], []byte(args[2]))
+	case "del":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: del <group> <key>")
+		}
+		return c.del(args[0], args[1])
+	case "owner":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: owner <group> <key>")
+		}
+		owner, err := c.owner(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		if p.json {
+			return p.encode(owner)
+		}
+		return p.table([]string{"KEY", "OWNER", "REPLICAS"}, [][]interface{}{
+			{owner.Key, owner.Owner, strings.Join(owner.Replicas, ",")},
+		})
+	case "peers":
+		peers, err := c.peers()
+		if err != nil {
+			return err
+		}
+		if p.json {
+			return p.encode(peers)
+		}
+		var rows [][]interface{}
+		for _, peer := range peers {
+			rows = append(rows, []interface{}{peer.Peer, peer.Weight, peer.Self, peer.Healthy, peer.Inflight})
+		}
+		return p.table([]string{"PEER", "WEIGHT", "SELF", "HEALTHY", "INFLIGHT"}, rows)
+	case "stats":
+		group := ""
+		if len(args) > 0 {
+			group = args[0]
+		}
+		infos, err := c.stats(group)
+		if err != nil {
+			return err
+		}
+		if p.json {
+			return p.encode(infos)
+		}
+		var rows [][]interface{}
+		for _, info := range infos {
+			s := info.Stats
+			rows = append(rows, []interface{}{info.Name, s.CacheBytes, s.CacheCapacity, s.Gets, s.Hits, s.Loads, s.PeerLoads, s.PeerErrors, s.LocalLoads, s.LocalLoadErrs, s.LoadsActive, s.LoadsQueued})
+		}
+		return p.table([]string{"GROUP", "BYTES", "CAPACITY", "GETS", "HITS", "LOADS", "PEER_LOADS", "PEER_ERRS", "LOCAL_LOADS", "LOCAL_ERRS", "ACTIVE", "QUEUED"}, rows)
+	case "flush":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: flush <group>")
+		}
+		return c.flush(args[0])
+	case "bench":
+		return runBench(c, p, args)
+	}
+	return fmt.Errorf("unknown command: %v", cmd)
+}
+
+type keyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Error string `json:"error,omitempty"`
+}
+
+// 按照table或者json输出
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func (p *printer) encode(v interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (p *printer) table(header []string, rows [][]interface{}) error {
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = fmt.Sprint(cell)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (p *printer) values(kvs []keyValue) error {
+	if p.json {
+		return p.encode(kvs)
+	}
+	var rows [][]interface{}
+	for _, kv := range kvs {
+		value := kv.Value
+		if kv.Error != "" {
+			value = "ERROR: " + kv.Error
+		}
+		rows = append(rows, []interface{}{kv.Key, value})
+	}
+	return p.table([]string{"KEY", "VALUE"}, rows)
+}
+
+type benchResult struct {
+	Requests int     `json:"requests"`
+	Errors   int     `json:"errors"`
+	Seconds  float64 `json:"seconds"`
+	QPS      float64 `json:"qps"`
+	P50      string  `json:"p50"`
+	P99      string  `json:"p99"`
+	Max      string  `json:"max"`
+}
+
+// 并发读取keys个不同的key，共n次请求
+func runBench(c *client, p *printer, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	n := fs.Int("n", 1000, "total requests")
+	concurrency := fs.Int("c", 8, "concurrent workers")
+	keys := fs.Int("keys", 100, "number of distinct keys, named key-0 to key-N")
+	if len(args) == 0 {
+		return fmt.Errorf("usage: bench <group> [-n 1000] [-c 8] [-keys 100]")
+	}
+	group := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *n <= 0 || *concurrency <= 0 || *keys <= 0 {
+		return fmt.Errorf("-n, -c and -keys must be positive")
+	}
+
+	var (
+		mutex     sync.Mutex
+		latencies []time.Duration
+		errors    int
+		wg        sync.WaitGroup
+	)
+	jobs := make(chan int)
+	start := time.Now()
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				begin := time.Now()
+				_, err := c.get(group, fmt.Sprintf("key-%d", i%*keys))
+				elapsed := time.Since(begin)
+
+				mutex.Lock()
+				latencies = append(latencies, elapsed)
+				if err != nil {
+					errors++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < *n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	total := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(q float64) time.Duration {
+		return latencies[int(q*float64(len(latencies)-1))]
+	}
+	result := benchResult{
+		Requests: len(latencies),
+		Errors:   errors,
+		Seconds:  total.Seconds(),
+		QPS:      float64(len(latencies)) / total.Seconds(),
+		P50:      percentile(0.5).String(),
+		P99:      percentile(0.99).String(),
+		Max:      latencies[len(latencies)-1].String(),
+	}
+	if p.json {
+		return p.encode(result)
+	}
+	return p.table([]string{"REQUESTS", "ERRORS", "SECONDS", "QPS", "P50", "P99", "MAX"}, [][]interface{}{
+		{result.Requests, result.Errors, fmt.Sprintf("%.2f", result.Seconds), fmt.Sprintf("%.0f", result.QPS), result.P50, result.P99, result.Max},
+	})
+}
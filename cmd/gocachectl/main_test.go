@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gy0117/gocache/cache"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// 启动n个节点，所有节点在同一个进程中共享Group，g按照第一个节点的视角选择节点
+func startPeers(g *cache.Group, n int) ([]string, func()) {
+	var servers []*httptest.Server
+	var addrs []string
+	for i := 0; i < n; i++ {
+		server := httptest.NewUnstartedServer(nil)
+		servers = append(servers, server)
+		addrs = append(addrs, "http://"+server.Listener.Addr().String())
+	}
+	for i, server := range servers {
+		pool := cache.NewHttpPool(addrs[i])
+		pool.SetPeerToken("peer-secret")
+		pool.Set(addrs...)
+		if i == 0 {
+			g.RegisterPeerPicker(pool)
+		}
+		server.Config.Handler = pool
+		server.Start()
+	}
+	return addrs, func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}
+}
+
+func ctl(args ...string) (string, error) {
+	var out bytes.Buffer
+	err := run(args, &out)
+	return out.String(), err
+}
+
+func TestCtl(t *testing.T) {
+	convey.Convey("TestCtl", t, func() {
+		db := map[string]string{"zhangsan": "100", "lisi": "200"}
+		for i := 0; i < 5; i++ {
+			db[fmt.Sprintf("key-%d", i)] = fmt.Sprint(i)
+		}
+		g := cache.NewGroup("ctl", 1<<20, cache.GetterFunc(func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+
+		addrs, stop := startPeers(g, 3)
+		defer stop()
+		admin := httptest.NewServer(cache.NewAdmin(cache.TokenAuth("secret")))
+		defer admin.Close()
+		server := "-server=" + addrs[0]
+
+		convey.Convey("get and mget", func() {
+			out, err := ctl(server, "get", "ctl", "zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(out, convey.ShouldContainSubstring, "zhangsan  100")
+
+			out, err = ctl(server, "-o", "json", "mget", "ctl", "zhangsan", "missing")
+			convey.So(err, convey.ShouldBeNil)
+			var kvs []keyValue
+			convey.So(json.Unmarshal([]byte(out), &kvs), convey.ShouldBeNil)
+			convey.So(kvs[0], convey.ShouldResemble, keyValue{Key: "zhangsan", Value: "100"})
+			convey.So(kvs[1].Error, convey.ShouldNotBeEmpty)
+		})
+
+		convey.Convey("set and del", func() {
+			// 写入需要节点之间共享的令牌
+			_, err := ctl(server, "set", "ctl", "wangwu", "300")
+			convey.So(err, convey.ShouldNotBeNil)
+
+			_, err = ctl(server, "-peer-token=peer-secret", "set", "ctl", "wangwu", "300")
+			convey.So(err, convey.ShouldBeNil)
+			out, err := ctl(server, "get", "ctl", "wangwu")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(out, convey.ShouldContainSubstring, "300")
+
+			_, err = ctl(server, "-peer-token=peer-secret", "del", "ctl", "wangwu")
+			convey.So(err, convey.ShouldBeNil)
+			_, err = ctl(server, "get", "ctl", "wangwu")
+			convey.So(err, convey.ShouldNotBeNil)
+		})
+
+		convey.Convey("owner and peers", func() {
+			out, err := ctl(server, "-o", "json", "owner", "ctl", "zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			var owner cache.OwnerInfo
+			convey.So(json.Unmarshal([]byte(out), &owner), convey.ShouldBeNil)
+			convey.So(addrs, convey.ShouldContain, owner.Owner)
+
+			// 每个节点计算的结果相同
+			out, err = ctl("-server="+addrs[2], "-o", "json", "owner", "ctl", "zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			var other cache.OwnerInfo
+			json.Unmarshal([]byte(out), &other)
+			convey.So(other.Owner, convey.ShouldEqual, owner.Owner)
+
+			out, err = ctl(server, "peers")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(strings.Count(out, "\n"), convey.ShouldEqual, 4)
+			convey.So(out, convey.ShouldContainSubstring, "HEALTHY")
+		})
+
+		convey.Convey("stats and flush use the admin api", func() {
+			_, err := ctl(server, "stats")
+			convey.So(err, convey.ShouldNotBeNil)
+			_, err = ctl(server, "-admin="+admin.URL, "stats")
+			convey.So(err, convey.ShouldNotBeNil)
+
+			ctl(server, "get", "ctl", "zhangsan")
+			out, err := ctl(server, "-admin="+admin.URL, "-token=secret", "-o", "json", "stats", "ctl")
+			convey.So(err, convey.ShouldBeNil)
+			var infos []cache.GroupInfo
+			convey.So(json.Unmarshal([]byte(out), &infos), convey.ShouldBeNil)
+			convey.So(infos[0].Stats.CacheBytes, convey.ShouldBeGreaterThan, 0)
+
+			_, err = ctl(server, "-admin="+admin.URL, "-token=secret", "flush", "ctl")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(cache.GetGroup("ctl").Stats().CacheBytes, convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("bench", func() {
+			out, err := ctl(server, "-o", "json", "bench", "ctl", "-n", "50", "-c", "4", "-keys", "5")
+			convey.So(err, convey.ShouldBeNil)
+			var result benchResult
+			convey.So(json.Unmarshal([]byte(out), &result), convey.ShouldBeNil)
+			convey.So(result.Requests, convey.ShouldEqual, 50)
+			convey.So(result.Errors, convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("bad usage", func() {
+			_, err := ctl(server, "get", "ctl")
+			convey.So(err, convey.ShouldNotBeNil)
+			_, err = ctl(server, "unknown")
+			convey.So(err, convey.ShouldNotBeNil)
+			_, err = ctl(server, "-o", "yaml", "peers")
+			convey.So(err, convey.ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,193 @@
+// gocached 是gocache的服务端，从配置文件启动一个节点
+//
+//	gocached -config gocached.json [-listen 127.0.0.1:8001] [-peers a:8001,b:8001] ...
+//
+// 配置的优先级：命令行参数 > 环境变量（GOCACHED_*） > 配置文件
+//
+// 收到SIGHUP时重新读取配置，使用 -watch 时定期检查配置文件是否被修改
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gy0117/gocache/config"
+	"github.com/gy0117/gocache/server"
+)
+
+func main() {
+	cfg, opts, err := loadConfig(os.Args[1:], os.Getenv, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gocached:", err)
+		os.Exit(2)
+	}
+	if opts.check {
+		fmt.Println("config ok")
+		return
+	}
+	reload := func() (*config.Config, error) {
+		cfg, _, err := loadConfig(os.Args[1:], os.Getenv, io.Discard)
+		return cfg, err
+	}
+	if err := serve(cfg, opts, reload); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// 不属于配置文件的命令行参数
+type options struct {
+	path  string
+	check bool          // 只校验配置
+	watch time.Duration // 检查配置文件是否被修改的间隔，为0表示只在SIGHUP时重新读取
+}
+
+// 解析命令行参数，读取配置文件，依次应用环境变量和命令行参数，最后校验
+func loadConfig(args []string, getenv func(string) string, stderr io.Writer) (cfg *config.Config, opts options, err error) {
+	fs := flag.NewFlagSet("gocached", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.path, "config", getenv("GOCACHED_CONFIG"), "path to the JSON config file")
+	fs.BoolVar(&opts.check, "check", false, "validate the config and exit")
+	fs.DurationVar(&opts.watch, "watch", 0, "reload the config file when it changes, checked at this interval")
+	fs.String("listen", "", "peer listen address, host:port")
+	fs.String("advertise", "", "address other peers use to reach this node, host:port")
+	fs.String("transport", "", "peer transport: http or grpc")
+	fs.String("placement", "", "key placement: ring, rendezvous, jump or bounded")
+	fs.String("replicas", "", "number of replicas for each key")
+	fs.String("peers", "", "comma separated peer list, host:port")
+	fs.String("admin-listen", "", "admin api listen address, host:port")
+	fs.String("admin-token", "", "bearer token for the admin api")
+	fs.String("peer-token", "", "token shared by the peers, required for writes between peers")
+	fs.String("log-level", "", "log level: debug, info or error")
+	if err := fs.Parse(args); err != nil {
+		return nil, opts, err
+	}
+	if opts.path == "" {
+		return nil, opts, errors.New("-config is required")
+	}
+
+	if cfg, err = config.Load(opts.path); err != nil {
+		return nil, opts, err
+	}
+	if err := cfg.ApplyEnv(getenv); err != nil {
+		return nil, opts, err
+	}
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" && f.Name != "check" && f.Name != "watch" {
+			set[f.Name] = f.Value.String()
+		}
+	})
+	if err := cfg.ApplyFlags(set); err != nil {
+		return nil, opts, err
+	}
+
+	cfg.SetDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, opts, fmt.Errorf("invalid config %v:\n%w", opts.path, err)
+	}
+	for _, warning := range cfg.Warnings() {
+		fmt.Fprintln(stderr, "gocached: warning:", warning)
+	}
+	return cfg, opts, nil
+}
+
+// 启动节点，收到SIGHUP或者配置文件被修改时重新加载，收到SIGTERM或者SIGINT时优雅退出
+func serve(cfg *config.Config, opts options, reload func() (*config.Config, error)) error {
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+	if err := srv.Start(); err != nil {
+		return err
+	}
+
+	var changed <-chan struct{}
+	if opts.watch > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		changed = watchFile(opts.path, opts.watch, stop)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case err = <-srv.Err():
+			log.Printf("gocached | server error: %v, shutting down\n", err)
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				reloadServer(srv, reload)
+				continue
+			}
+			log.Printf("gocached | received %v, shutting down\n", s)
+		case <-changed:
+			reloadServer(srv, reload)
+			continue
+		}
+		break
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(srv.Config().ShutdownTimeout))
+	defer cancel()
+	return errors.Join(err, srv.Shutdown(ctx))
+}
+
+// 新的配置不合法时保持原来的配置
+func reloadServer(srv *server.Server, reload func() (*config.Config, error)) {
+	cfg, err := reload()
+	if err != nil {
+		log.Printf("gocached | reload rejected: %v\n", err)
+		return
+	}
+	result, err := srv.Reload(cfg)
+	if err != nil {
+		log.Printf("gocached | reload rejected: %v\n", err)
+		return
+	}
+	log.Printf("gocached | reloaded, applied: %v\n", result.Applied)
+	if len(result.Restart) > 0 {
+		log.Printf("gocached | restart required to apply: %v\n", result.Restart)
+	}
+}
+
+// 定期检查文件的修改时间和大小，发生变化时通知
+func watchFile(path string, interval time.Duration, stop <-chan struct{}) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	stat := func() (time.Time, int64) {
+		if fi, err := os.Stat(path); err == nil {
+			return fi.ModTime(), fi.Size()
+		}
+		return time.Time{}, -1
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		modTime, size := stat()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			m, n := stat()
+			if m.Equal(modTime) && n == size {
+				continue
+			}
+			modTime, size = m, n
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changed
+}
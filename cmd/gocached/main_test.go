@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gy0117/gocache/config"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoadConfig(t *testing.T) {
+	convey.Convey("TestLoadConfig", t, func() {
+		path := filepath.Join(t.TempDir(), "gocached.json")
+		os.WriteFile(path, []byte(`{
+			"listen": "127.0.0.1:8001",
+			"peers": [{"addr": "127.0.0.1:8001"}],
+			"groups": [{"name": "scores", "capacity": "1MB", "loader": {"type": "static"}}]
+		}`), 0o644)
+
+		env := map[string]string{"GOCACHED_CONFIG": path, "GOCACHED_LISTEN": "127.0.0.1:8101", "GOCACHED_TRANSPORT": "grpc"}
+		getenv := func(name string) string { return env[name] }
+
+		convey.Convey("flags override env which overrides the file", func() {
+			cfg, opts, err := loadConfig([]string{"-listen", "127.0.0.1:8201", "-check", "-watch", "2s", "-log-level", "error"}, getenv, io.Discard)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(opts.check, convey.ShouldBeTrue)
+			convey.So(opts.watch, convey.ShouldEqual, 2*time.Second)
+			convey.So(cfg.LogLevel, convey.ShouldEqual, "error")
+			convey.So(cfg.Listen, convey.ShouldEqual, "127.0.0.1:8201")
+			convey.So(cfg.Advertise, convey.ShouldEqual, "127.0.0.1:8201")
+			convey.So(cfg.Transport, convey.ShouldEqual, "grpc")
+		})
+
+		convey.Convey("warnings are written to stderr", func() {
+			var stderr strings.Builder
+			_, _, err := loadConfig(nil, getenv, &stderr)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(stderr.String(), convey.ShouldContainSubstring, "gocached: warning: transport: grpc cannot announce join or leave")
+		})
+
+		convey.Convey("invalid settings are reported with the file name", func() {
+			_, _, err := loadConfig([]string{"-replicas", "-1", "-transport", "tcp"}, getenv, io.Discard)
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(err.Error(), convey.ShouldContainSubstring, path)
+			convey.So(err.Error(), convey.ShouldContainSubstring, "replicas: must be at least 1")
+			convey.So(err.Error(), convey.ShouldContainSubstring, "transport: must be http or grpc")
+
+			_, _, err = loadConfig(nil, func(string) string { return "" }, io.Discard)
+			convey.So(err.Error(), convey.ShouldContainSubstring, "-config is required")
+		})
+
+		convey.Convey("the example config is valid", func() {
+			cfg, err := config.Load("gocached.example.json")
+			convey.So(err, convey.ShouldBeNil)
+			cfg.SetDefaults()
+			convey.So(cfg.Validate(), convey.ShouldBeNil)
+		})
+	})
+}
+
+func TestWatchFile(t *testing.T) {
+	convey.Convey("TestWatchFile", t, func() {
+		path := filepath.Join(t.TempDir(), "gocached.json")
+		os.WriteFile(path, []byte(`{}`), 0o644)
+		stop := make(chan struct{})
+		defer close(stop)
+		changed := watchFile(path, 10*time.Millisecond, stop)
+
+		select {
+		case <-changed:
+			t.Fatal("unexpected change")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		os.WriteFile(path, []byte(`{"listen": "127.0.0.1:8001"}`), 0o644)
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+			t.Fatal("change not detected")
+		}
+	})
+}
@@ -0,0 +1,557 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gocached的配置，从JSON文件读取，之后依次被环境变量和命令行参数覆盖
+type Config struct {
+	Listen    string `json:"listen"`    // 节点间通信监听的地址，例如 127.0.0.1:8001
+	Advertise string `json:"advertise"` // 其他节点访问本节点的地址，默认与listen相同
+	Transport string `json:"transport"` // http或者grpc，默认http；加入和离开的通知只支持http
+	Placement string `json:"placement"` // ring、rendezvous、jump或者bounded，默认ring
+	Replicas  int    `json:"replicas"`  // 每个key的副本数，默认1
+
+	Peers     []Peer     `json:"peers"`     // 静态的节点列表，包括本节点
+	Discovery *Discovery `json:"discovery"` // 从其他来源获取节点列表，与peers二选一
+
+	Pools map[string][]Peer `json:"pools"` // 命名的节点子集，Group通过pool只分布在其中的节点上
+
+	TLS    *TLS         `json:"tls"`
+	Admin  *Admin       `json:"admin"`
+	Limits *Limits      `json:"limits"` // 节点间通信的服务的限流，为空时不限制
+	Groups []GroupEntry `json:"groups"`
+
+	// 节点之间共享的令牌，写入、删除等修改节点状态的内部接口需要校验，限流时只有通过校验的节点按照节点限流
+	// 配置了tls.ca_file时，校验过客户端证书的节点不需要令牌；两者都没有时拒绝这些请求
+	PeerToken string `json:"peer_token"`
+
+	SnapshotDir     string   `json:"snapshot_dir"`     // 不为空时，退出时写入快照，启动时从快照恢复
+	ShutdownTimeout Duration `json:"shutdown_timeout"` // 优雅退出的最长时间，默认30s
+	LogLevel        string   `json:"log_level"`        // debug、info或者error，默认info
+}
+
+// 默认的优雅退出时间
+const DefaultShutdownTimeout = 30 * time.Second
+
+type Peer struct {
+	Addr   string `json:"addr"`   // host:port
+	Weight int    `json:"weight"` // 默认1
+}
+
+// file：每行一个节点，格式为 host:port [weight]
+// dns：解析name的所有A/AAAA记录，端口为port
+type Discovery struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+// 同时用于节点间通信的服务端和客户端
+type TLS struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	CAFile   string `json:"ca_file"` // 校验其他节点证书的CA，为空时使用系统的CA
+}
+
+type Admin struct {
+	Listen string `json:"listen"`
+	Token  string `json:"token"`
+}
+
+// 令牌桶限流，rate是每秒的请求数，为0时不限制；burst为0时取rate
+// 超过速率的请求返回429或者RESOURCE_EXHAUSTED
+type Limits struct {
+	ClientRate  float64 `json:"client_rate"` // 每个客户端IP
+	ClientBurst int     `json:"client_burst"`
+	PeerRate    float64 `json:"peer_rate"` // 每个节点
+	PeerBurst   int     `json:"peer_burst"`
+	GroupRate   float64 `json:"group_rate"` // 每个Group
+	GroupBurst  int     `json:"group_burst"`
+	// 正在进行的加载超过这个数时，拒绝本地缓存未命中的读请求，为0时不限制
+	MaxPendingLoads int `json:"max_pending_loads"`
+}
+
+type GroupEntry struct {
+	Name     string       `json:"name"`
+	Capacity ByteSize     `json:"capacity"` // 字节数，或者 "64MB" 这样的字符串
+	TTL      Duration     `json:"ttl"`      // 例如 "5m"，为空表示不过期
+	Eviction string       `json:"eviction"` // lru或者arena，默认lru
+	Disk     *Disk        `json:"disk"`
+	Loader   Loader       `json:"loader"`
+	Pool     string       `json:"pool"`  // 为空时使用所有节点，local表示只在本地缓存，其他为pools中的名称
+	Lease    Duration     `json:"lease"` // 分布式租约的有效期，同一个key在租约期间只有一个节点从数据源加载；只支持http
+	Load     *LoadOptions `json:"load"`
+}
+
+// 从数据源加载的超时、重试和并发限制，为空时不限制
+type LoadOptions struct {
+	Timeout       Duration `json:"timeout"`        // 每次调用数据源的超时时间，包括排队的时间
+	Retries       int      `json:"retries"`        // 超时、连接失败等暂时性的错误的重试次数
+	Backoff       Duration `json:"backoff"`        // 第一次重试之前的等待时间，之后每次翻倍，默认100ms
+	MaxBackoff    Duration `json:"max_backoff"`    // 重试之前最多等待的时间
+	MaxConcurrent int      `json:"max_concurrent"` // 同时调用数据源的最大个数，为0时不限制
+	MaxQueue      int      `json:"max_queue"`      // 达到max_concurrent之后最多排队的个数，超出时拒绝
+}
+
+// 只在本地缓存的Group使用的pool
+const LocalPool = "local"
+
+type Disk struct {
+	Dir      string   `json:"dir"`
+	MaxBytes ByteSize `json:"max_bytes"`
+}
+
+// 未命中时从哪里加载数据
+// http：GET url，url中的{key}替换为转义后的key，404视为不存在
+// file：读取 dir/<key>
+// static：从values中读取
+type Loader struct {
+	Type   string            `json:"type"`
+	URL    string            `json:"url"`
+	Dir    string            `json:"dir"`
+	Values map[string]string `json:"values"`
+}
+
+// 读取并解析配置文件，不做校验
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	return c, nil
+}
+
+// 环境变量覆盖配置文件，getenv通常是os.Getenv
+//
+//	GOCACHED_LISTEN、GOCACHED_ADVERTISE、GOCACHED_TRANSPORT、GOCACHED_PLACEMENT、
+//	GOCACHED_REPLICAS、GOCACHED_PEERS（逗号分隔）、GOCACHED_ADMIN_LISTEN、GOCACHED_ADMIN_TOKEN、
+//	GOCACHED_PEER_TOKEN、GOCACHED_LOG_LEVEL
+func (c *Config) ApplyEnv(getenv func(string) string) error {
+	for name, apply := range c.overrides() {
+		if v := getenv("GOCACHED_" + strings.ToUpper(name)); v != "" {
+			if err := apply(v); err != nil {
+				return fmt.Errorf("GOCACHED_%v: %w", strings.ToUpper(name), err)
+			}
+		}
+	}
+	return nil
+}
+
+// 命令行参数覆盖配置文件和环境变量，set中只包含命令行中出现的参数
+func (c *Config) ApplyFlags(set map[string]string) error {
+	overrides := c.overrides()
+	for name, v := range set {
+		apply, ok := overrides[strings.ReplaceAll(name, "-", "_")]
+		if !ok {
+			return fmt.Errorf("-%v: unknown setting", name)
+		}
+		if err := apply(v); err != nil {
+			return fmt.Errorf("-%v: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// 可以被覆盖的配置，名称与命令行参数相同
+func (c *Config) overrides() map[string]func(string) error {
+	return map[string]func(string) error{
+		"listen":    func(v string) error { c.Listen = v; return nil },
+		"advertise": func(v string) error { c.Advertise = v; return nil },
+		"transport": func(v string) error { c.Transport = v; return nil },
+		"placement": func(v string) error { c.Placement = v; return nil },
+		"replicas": func(v string) error {
+			n, err := strconv.Atoi(v)
+			c.Replicas = n
+			return err
+		},
+		"peers": func(v string) error {
+			c.Peers = nil
+			for _, addr := range strings.Split(v, ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					c.Peers = append(c.Peers, Peer{Addr: addr})
+				}
+			}
+			return nil
+		},
+		"admin_listen": func(v string) error { c.admin().Listen = v; return nil },
+		"admin_token":  func(v string) error { c.admin().Token = v; return nil },
+		"peer_token":   func(v string) error { c.PeerToken = v; return nil },
+		"log_level":    func(v string) error { c.LogLevel = v; return nil },
+	}
+}
+
+func (c *Config) admin() *Admin {
+	if c.Admin == nil {
+		c.Admin = &Admin{}
+	}
+	return c.Admin
+}
+
+// 填充默认值
+func (c *Config) SetDefaults() {
+	if c.Advertise == "" {
+		c.Advertise = c.Listen
+	}
+	if c.Transport == "" {
+		c.Transport = "http"
+	}
+	if c.Placement == "" {
+		c.Placement = "ring"
+	}
+	if c.Replicas == 0 {
+		c.Replicas = 1
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = "info"
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = Duration(DefaultShutdownTimeout)
+	}
+	for _, pool := range c.Pools {
+		for i := range pool {
+			if pool[i].Weight == 0 {
+				pool[i].Weight = 1
+			}
+		}
+	}
+	for i := range c.Peers {
+		if c.Peers[i].Weight == 0 {
+			c.Peers[i].Weight = 1
+		}
+	}
+	for i := range c.Groups {
+		if c.Groups[i].Eviction == "" {
+			c.Groups[i].Eviction = "lru"
+		}
+	}
+}
+
+// 校验所有的配置，返回所有的错误，每个错误都带有配置项的路径
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(field string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%v: %v", field, fmt.Sprintf(format, args...)))
+	}
+
+	if err := checkHostPort(c.Listen); err != nil {
+		fail("listen", "%v", err)
+	}
+	if err := checkHostPort(c.Advertise); err != nil {
+		fail("advertise", "%v", err)
+	}
+	if c.Transport != "http" && c.Transport != "grpc" {
+		fail("transport", "must be http or grpc, got %q", c.Transport)
+	}
+	switch c.Placement {
+	case "ring", "rendezvous", "jump", "bounded":
+	default:
+		fail("placement", "must be ring, rendezvous, jump or bounded, got %q", c.Placement)
+	}
+	if c.Replicas < 1 {
+		fail("replicas", "must be at least 1, got %v", c.Replicas)
+	}
+
+	if len(c.Peers) > 0 && c.Discovery != nil {
+		fail("discovery", "cannot be used together with peers")
+	}
+	seen := make(map[string]bool)
+	for i, peer := range c.Peers {
+		field := fmt.Sprintf("peers[%v]", i)
+		if err := checkHostPort(peer.Addr); err != nil {
+			fail(field+".addr", "%v", err)
+		}
+		if seen[peer.Addr] {
+			fail(field+".addr", "duplicate peer %q", peer.Addr)
+		}
+		seen[peer.Addr] = true
+		if peer.Weight < 1 {
+			fail(field+".weight", "must be at least 1, got %v", peer.Weight)
+		}
+	}
+	poolNames := make([]string, 0, len(c.Pools))
+	for name := range c.Pools {
+		poolNames = append(poolNames, name)
+	}
+	sort.Strings(poolNames)
+	for _, name := range poolNames {
+		pool := c.Pools[name]
+		field := "pools." + name
+		if name == "" || name == LocalPool {
+			fail(field, "name must not be empty or %q", LocalPool)
+		}
+		if len(pool) == 0 {
+			fail(field, "at least one peer is required")
+		}
+		seen := make(map[string]bool)
+		for i, peer := range pool {
+			field := fmt.Sprintf("%v[%v]", field, i)
+			if err := checkHostPort(peer.Addr); err != nil {
+				fail(field+".addr", "%v", err)
+			}
+			// 从其他来源获取的节点列表无法在这里校验
+			if len(c.Peers) > 0 && !c.hasPeer(peer.Addr) {
+				fail(field+".addr", "%q is not in peers", peer.Addr)
+			}
+			if seen[peer.Addr] {
+				fail(field+".addr", "duplicate peer %q", peer.Addr)
+			}
+			seen[peer.Addr] = true
+			if peer.Weight < 1 {
+				fail(field+".weight", "must be at least 1, got %v", peer.Weight)
+			}
+		}
+	}
+	if d := c.Discovery; d != nil {
+		switch d.Type {
+		case "file":
+			if d.Path == "" {
+				fail("discovery.path", "is required for file discovery")
+			}
+		case "dns":
+			if d.Name == "" {
+				fail("discovery.name", "is required for dns discovery")
+			}
+			if d.Port <= 0 || d.Port > 65535 {
+				fail("discovery.port", "must be a valid port, got %v", d.Port)
+			}
+		default:
+			fail("discovery.type", "must be file or dns, got %q", d.Type)
+		}
+	}
+
+	if t := c.TLS; t != nil {
+		if t.CertFile == "" || t.KeyFile == "" {
+			fail("tls", "cert_file and key_file are required")
+		}
+		files := []struct{ field, path string }{
+			{"tls.cert_file", t.CertFile}, {"tls.key_file", t.KeyFile}, {"tls.ca_file", t.CAFile},
+		}
+		for _, f := range files {
+			if f.path == "" {
+				continue
+			}
+			if _, err := os.Stat(f.path); err != nil {
+				fail(f.field, "%v", err)
+			}
+		}
+	}
+
+	if a := c.Admin; a != nil && a.Listen != "" {
+		if err := checkHostPort(a.Listen); err != nil {
+			fail("admin.listen", "%v", err)
+		}
+		if a.Token == "" {
+			fail("admin.token", "is required when admin.listen is set")
+		}
+	}
+
+	switch c.LogLevel {
+	case "debug", "info", "error":
+	default:
+		fail("log_level", "must be debug, info or error, got %q", c.LogLevel)
+	}
+	if c.ShutdownTimeout < 0 {
+		fail("shutdown_timeout", "must not be negative")
+	}
+	if l := c.Limits; l != nil {
+		if l.ClientRate < 0 || l.PeerRate < 0 || l.GroupRate < 0 {
+			fail("limits", "rates must not be negative")
+		}
+		if l.ClientBurst < 0 || l.PeerBurst < 0 || l.GroupBurst < 0 || l.MaxPendingLoads < 0 {
+			fail("limits", "bursts and max_pending_loads must not be negative")
+		}
+	}
+
+	if len(c.Groups) == 0 {
+		fail("groups", "at least one group is required")
+	}
+	names := make(map[string]bool)
+	for i, g := range c.Groups {
+		field := fmt.Sprintf("groups[%v]", i)
+		switch {
+		case g.Name == "":
+			fail(field+".name", "is required")
+		case strings.HasPrefix(g.Name, "_"):
+			fail(field+".name", "must not start with _, got %q", g.Name)
+		case strings.Contains(g.Name, "/"):
+			fail(field+".name", "must not contain /, got %q", g.Name)
+		case names[g.Name]:
+			fail(field+".name", "duplicate group %q", g.Name)
+		}
+		names[g.Name] = true
+		if g.Capacity <= 0 {
+			fail(field+".capacity", "must be positive")
+		}
+		if g.TTL < 0 {
+			fail(field+".ttl", "must not be negative")
+		}
+		if g.Lease < 0 {
+			fail(field+".lease", "must not be negative")
+		} else if g.Lease > 0 && c.Transport != "http" {
+			fail(field+".lease", "requires the http transport")
+		} else if g.Lease > 0 && c.PeerToken == "" && (c.TLS == nil || c.TLS.CAFile == "") {
+			// 节点只接受通过校验的租约请求
+			fail(field+".lease", "requires peer_token or tls.ca_file")
+		}
+		if l := g.Load; l != nil {
+			if l.Timeout < 0 || l.Backoff < 0 || l.MaxBackoff < 0 {
+				fail(field+".load", "durations must not be negative")
+			}
+			if l.Retries < 0 || l.MaxConcurrent < 0 || l.MaxQueue < 0 {
+				fail(field+".load", "retries, max_concurrent and max_queue must not be negative")
+			}
+			if l.MaxQueue > 0 && l.MaxConcurrent == 0 {
+				fail(field+".load.max_queue", "requires max_concurrent")
+			}
+		}
+		if g.Eviction != "lru" && g.Eviction != "arena" {
+			fail(field+".eviction", "must be lru or arena, got %q", g.Eviction)
+		}
+		if g.Disk != nil {
+			if g.Disk.Dir == "" {
+				fail(field+".disk.dir", "is required")
+			}
+			if g.Disk.MaxBytes <= 0 {
+				fail(field+".disk.max_bytes", "must be positive")
+			}
+		}
+		if _, ok := c.Pools[g.Pool]; !ok && g.Pool != "" && g.Pool != LocalPool {
+			fail(field+".pool", "must be empty, %q or one of pools, got %q", LocalPool, g.Pool)
+		}
+		switch g.Loader.Type {
+		case "http":
+			if !strings.Contains(g.Loader.URL, "{key}") {
+				fail(field+".loader.url", "must contain {key}, got %q", g.Loader.URL)
+			}
+		case "file":
+			if g.Loader.Dir == "" {
+				fail(field+".loader.dir", "is required for file loader")
+			}
+		case "static":
+		default:
+			fail(field+".loader.type", "must be http, file or static, got %q", g.Loader.Type)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 合法但是可能不符合预期的配置，在Validate之后调用
+func (c *Config) Warnings() []string {
+	var warnings []string
+	if c.Transport == "grpc" {
+		// gRPC没有加入和离开的接口，其他节点直到健康检查失败才不再请求退出的节点
+		warnings = append(warnings, "transport: grpc cannot announce join or leave, peers keep routing to a stopped node until its health checks fail")
+	}
+	return warnings
+}
+
+func (c *Config) hasPeer(addr string) bool {
+	for _, peer := range c.Peers {
+		if peer.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func checkHostPort(addr string) error {
+	if addr == "" {
+		return fmt.Errorf("is required")
+	}
+	if strings.Contains(addr, "://") {
+		return fmt.Errorf("must be host:port without a scheme, got %q", addr)
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("invalid port in %q", addr)
+	}
+	return nil
+}
+
+// 字节数，JSON中可以是数字，或者带单位的字符串，例如 "64MB"、"512KB"、"1GB"
+type ByteSize int64
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("size must be a number or a string like \"64MB\"")
+	}
+	size, err := ParseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+func ParseByteSize(s string) (ByteSize, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			n, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return ByteSize(n * unit.size), nil
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return ByteSize(n), nil
+}
+
+// 时间间隔，JSON中是 "5m" 这样的字符串
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5m\"")
+	}
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+const sample = `{
+	"listen": "127.0.0.1:8001",
+	"peers": [{"addr": "127.0.0.1:8001"}, {"addr": "127.0.0.1:8002", "weight": 3}],
+	"groups": [{
+		"name": "scores",
+		"capacity": "64MB",
+		"ttl": "5m",
+		"loader": {"type": "static", "values": {"zhangsan": "100"}}
+	}]
+}`
+
+func TestParse(t *testing.T) {
+	convey.Convey("TestParse", t, func() {
+		c, err := Parse([]byte(sample))
+		convey.So(err, convey.ShouldBeNil)
+		c.SetDefaults()
+		convey.So(c.Validate(), convey.ShouldBeNil)
+
+		convey.So(c.Advertise, convey.ShouldEqual, "127.0.0.1:8001")
+		convey.So(c.Transport, convey.ShouldEqual, "http")
+		convey.So(c.Replicas, convey.ShouldEqual, 1)
+		convey.So(c.Peers, convey.ShouldResemble, []Peer{{"127.0.0.1:8001", 1}, {"127.0.0.1:8002", 3}})
+		convey.So(c.Groups[0].Capacity, convey.ShouldEqual, 64<<20)
+		convey.So(time.Duration(c.Groups[0].TTL), convey.ShouldEqual, 5*time.Minute)
+		convey.So(c.Groups[0].Eviction, convey.ShouldEqual, "lru")
+
+		convey.Convey("sizes", func() {
+			for s, want := range map[string]ByteSize{"512": 512, "1KB": 1 << 10, "64mb": 64 << 20, "2 GB": 2 << 30, "10B": 10} {
+				got, err := ParseByteSize(s)
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(got, convey.ShouldEqual, want)
+			}
+			_, err := ParseByteSize("lots")
+			convey.So(err, convey.ShouldNotBeNil)
+		})
+
+		convey.Convey("unknown fields and bad values are rejected", func() {
+			_, err := Parse([]byte(`{"listen": "127.0.0.1:8001", "lisen": "x"}`))
+			convey.So(err, convey.ShouldNotBeNil)
+			_, err = Parse([]byte(`{"groups": [{"ttl": "5 minutes"}]}`))
+			convey.So(err, convey.ShouldNotBeNil)
+			_, err = Parse([]byte(`{"groups": [{"capacity": true}]}`))
+			convey.So(err, convey.ShouldNotBeNil)
+		})
+	})
+}
+
+func TestValidate(t *testing.T) {
+	convey.Convey("TestValidate", t, func() {
+		c, _ := Parse([]byte(`{
+			"listen": "http://127.0.0.1:8001",
+			"transport": "udp",
+			"peers": [{"addr": "a:1"}, {"addr": "a:1"}],
+			"discovery": {"type": "dns", "name": "cache.local"},
+			"admin": {"listen": "127.0.0.1:9999"},
+			"log_level": "verbose",
+			"limits": {"client_rate": -1, "max_pending_loads": 10},
+			"pools": {"hot": [{"addr": "b:1"}], "local": []},
+			"groups": [
+				{"name": "_internal", "capacity": 0, "loader": {"type": "http", "url": "http://db/"}},
+				{"name": "scores", "capacity": 10, "eviction": "fifo", "loader": {"type": "ftp"}, "lease": "-1s"},
+				{"name": "scores", "capacity": 10, "loader": {"type": "file"}, "pool": "cold", "load": {"retries": -1, "max_queue": 8}}
+			]
+		}`))
+		c.SetDefaults()
+		err := c.Validate()
+		convey.So(err, convey.ShouldNotBeNil)
+
+		// 每个错误一行，带有配置项的路径
+		for _, want := range []string{
+			"listen: must be host:port without a scheme",
+			"transport: must be http or grpc",
+			"discovery: cannot be used together with peers",
+			"peers[1].addr: duplicate peer",
+			"discovery.port: must be a valid port",
+			"admin.token: is required",
+			"log_level: must be debug, info or error",
+			"limits: rates must not be negative",
+			"pools.hot[0].addr: \"b:1\" is not in peers",
+			"pools.local: name must not be empty or \"local\"",
+			"pools.local: at least one peer is required",
+			"groups[2].pool: must be empty, \"local\" or one of pools",
+			"groups[0].name: must not start with _",
+			"groups[0].capacity: must be positive",
+			"groups[0].loader.url: must contain {key}",
+			"groups[1].lease: must not be negative",
+			"groups[1].eviction: must be lru or arena",
+			"groups[1].loader.type: must be http, file or static",
+			"groups[2].name: duplicate group",
+			"groups[2].loader.dir: is required",
+			"groups[2].load: retries, max_concurrent and max_queue must not be negative",
+			"groups[2].load.max_queue: requires max_concurrent",
+		} {
+			convey.So(err.Error(), convey.ShouldContainSubstring, want)
+		}
+		convey.So(strings.Count(err.Error(), "\n"), convey.ShouldBeGreaterThanOrEqualTo, 13)
+
+		convey.Convey("leases need a way to authenticate peers", func() {
+			c, _ := Parse([]byte(`{
+				"listen": "127.0.0.1:8001",
+				"groups": [{"name": "scores", "capacity": 10, "loader": {"type": "static"}, "lease": "1s"}]
+			}`))
+			c.SetDefaults()
+			err := c.Validate()
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(err.Error(), convey.ShouldContainSubstring, "groups[0].lease: requires peer_token or tls.ca_file")
+
+			c.PeerToken = "peer-secret"
+			convey.So(c.Validate(), convey.ShouldBeNil)
+		})
+
+		convey.Convey("grpc transport warns that leave is not announced", func() {
+			c, _ := Parse([]byte(`{
+				"listen": "127.0.0.1:8001",
+				"groups": [{"name": "scores", "capacity": 10, "loader": {"type": "static"}}]
+			}`))
+			c.SetDefaults()
+			convey.So(c.Warnings(), convey.ShouldBeEmpty)
+
+			c.Transport = "grpc"
+			convey.So(c.Validate(), convey.ShouldBeNil)
+			warnings := c.Warnings()
+			convey.So(warnings, convey.ShouldHaveLength, 1)
+			convey.So(warnings[0], convey.ShouldContainSubstring, "cannot announce join or leave")
+		})
+	})
+}
+
+func TestOverrides(t *testing.T) {
+	convey.Convey("TestOverrides", t, func() {
+		c, _ := Parse([]byte(sample))
+		env := map[string]string{
+			"GOCACHED_LISTEN":      "127.0.0.1:9001",
+			"GOCACHED_PEERS":       "127.0.0.1:9001, 127.0.0.1:9002",
+			"GOCACHED_ADMIN_TOKEN": "from-env",
+			"GOCACHED_PEER_TOKEN":  "peer-from-env",
+		}
+		convey.So(c.ApplyEnv(func(name string) string { return env[name] }), convey.ShouldBeNil)
+		convey.So(c.ApplyFlags(map[string]string{"listen": "127.0.0.1:7001", "admin-listen": "127.0.0.1:7999"}), convey.ShouldBeNil)
+		c.SetDefaults()
+		convey.So(c.Validate(), convey.ShouldBeNil)
+
+		convey.So(c.Listen, convey.ShouldEqual, "127.0.0.1:7001")
+		convey.So(c.Peers, convey.ShouldResemble, []Peer{{"127.0.0.1:9001", 1}, {"127.0.0.1:9002", 1}})
+		convey.So(*c.Admin, convey.ShouldResemble, Admin{Listen: "127.0.0.1:7999", Token: "from-env"})
+		convey.So(c.PeerToken, convey.ShouldEqual, "peer-from-env")
+
+		err := c.ApplyEnv(func(name string) string {
+			if name == "GOCACHED_REPLICAS" {
+				return "two"
+			}
+			return ""
+		})
+		convey.So(err.Error(), convey.ShouldStartWith, "GOCACHED_REPLICAS")
+		convey.So(c.ApplyFlags(map[string]string{"nope": "1"}), convey.ShouldNotBeNil)
+	})
+}
+
+func TestResolvePeers(t *testing.T) {
+	convey.Convey("TestResolvePeers", t, func() {
+		path := filepath.Join(t.TempDir(), "peers")
+		os.WriteFile(path, []byte("# cache nodes\n127.0.0.1:8002 2\n\n127.0.0.1:8003\n"), 0o644)
+
+		c := &Config{Listen: "127.0.0.1:8001", Discovery: &Discovery{Type: "file", Path: path}}
+		c.SetDefaults()
+		peers, err := c.ResolvePeers()
+		convey.So(err, convey.ShouldBeNil)
+		// 本节点不在列表中时自动加入
+		convey.So(peers, convey.ShouldResemble, []Peer{{"127.0.0.1:8002", 2}, {"127.0.0.1:8003", 1}, {"127.0.0.1:8001", 1}})
+
+		os.WriteFile(path, []byte("127.0.0.1:8002 heavy\n"), 0o644)
+		_, err = c.ResolvePeers()
+		convey.So(err.Error(), convey.ShouldContainSubstring, path+":1")
+
+		c = &Config{Listen: "127.0.0.1:8001", Discovery: &Discovery{Type: "dns", Name: "localhost", Port: 8001}}
+		c.SetDefaults()
+		peers, err = c.ResolvePeers()
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(peers, convey.ShouldContain, Peer{"127.0.0.1:8001", 1})
+	})
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 节点列表：静态配置，或者从discovery获取；本节点不在列表中时自动加入，权重为1
+func (c *Config) ResolvePeers() ([]Peer, error) {
+	var peers []Peer
+	var err error
+	switch {
+	case c.Discovery == nil:
+		peers = append(peers, c.Peers...)
+	case c.Discovery.Type == "file":
+		peers, err = readPeersFile(c.Discovery.Path)
+	case c.Discovery.Type == "dns":
+		peers, err = lookupPeers(c.Discovery.Name, c.Discovery.Port)
+	default:
+		err = fmt.Errorf("unknown discovery type %q", c.Discovery.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, peer := range peers {
+		if peer.Addr == c.Advertise {
+			return peers, nil
+		}
+	}
+	return append(peers, Peer{Addr: c.Advertise, Weight: 1}), nil
+}
+
+// 每行一个节点：host:port [weight]，#开头的行是注释
+func readPeersFile(path string) ([]Peer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var peers []Peer
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		peer := Peer{Addr: fields[0], Weight: 1}
+		if err := checkHostPort(peer.Addr); err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", path, line, err)
+		}
+		if len(fields) > 1 {
+			if peer.Weight, err = strconv.Atoi(fields[1]); err != nil || peer.Weight < 1 {
+				return nil, fmt.Errorf("%v:%v: invalid weight %q", path, line, fields[1])
+			}
+		}
+		peers = append(peers, peer)
+	}
+	return peers, scanner.Err()
+}
+
+func lookupPeers(name string, port int) ([]Peer, error) {
+	hosts, err := net.LookupHost(name)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]Peer, 0, len(hosts))
+	for _, host := range hosts {
+		peers = append(peers, Peer{Addr: net.JoinHostPort(host, strconv.Itoa(port)), Weight: 1})
+	}
+	return peers, nil
+}
@@ -1,7 +1,7 @@
 package consistenthash
 
 import (
-	"hash/crc32"
+	"hash/fnv"
 	"sort"
 	"strconv"
 )
@@ -10,25 +10,39 @@ import (
 // 1. add节点、get节点
 
 // hash算法，外部提供
-type HashFunc func(data []byte) uint32
+type HashFunc func(data []byte) uint64
+
+// 32位的hash算法，例如crc32.ChecksumIEEE，转换成HashFunc
+func Hash32(hash func(data []byte) uint32) HashFunc {
+	return func(data []byte) uint64 {
+		return uint64(hash(data))
+	}
+}
+
+// 默认的hash算法：FNV-64a，再经过一次混合使相似的输入分布均匀
+func DefaultHash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return mix64(h.Sum64())
+}
 
 type Map struct {
-	hash    HashFunc       // hash算法，外部提供
-	replics int            // 每个真实节点的虚拟节点数
-	keyring []int          // 哈希环
-	hashMap map[int]string // 虚拟节点与真实节点的对应关系
+	hash    HashFunc          // hash算法，外部提供
+	replics int               // 每个真实节点的虚拟节点数
+	keyring []uint64          // 哈希环
+	hashMap map[uint64]string // 虚拟节点与真实节点的对应关系
 }
 
 func New(replics int, hash HashFunc) *Map {
 	m := &Map{
 		hash:    hash,
 		replics: replics,
-		hashMap: make(map[int]string),
+		hashMap: make(map[uint64]string),
 	}
 
 	if m.hash == nil {
 		// 默认hash算法
-		m.hash = crc32.ChecksumIEEE
+		m.hash = DefaultHash
 	}
 	return m
 }
@@ -36,17 +50,60 @@ func New(replics int, hash HashFunc) *Map {
 // 添加真实的节点
 func (m *Map) Add(keys ...string) {
 	for _, v := range keys {
-		// v是一个真实的节点
-		// 将真实的节点虚拟化
-		for i := 0; i < m.replics; i++ {
-			// 虚拟节点的hash
-			hash := int(m.hash([]byte(strconv.Itoa(i) + v)))
-			m.keyring = append(m.keyring, hash)
+		m.add(v, m.replics)
+	}
+	// 将所有的节点排序
+	m.sort()
+}
+
+// 添加带权重的真实节点，虚拟节点数为replics*weight，权重小于等于0的节点不添加
+// 权重为1时与Add相同
+func (m *Map) AddWeighted(weights map[string]int) {
+	// 按照名称排序，保证结果与map的遍历顺序无关
+	keys := make([]string, 0, len(weights))
+	for v := range weights {
+		keys = append(keys, v)
+	}
+	sort.Strings(keys)
+
+	for _, v := range keys {
+		if weights[v] > 0 {
+			m.add(v, m.replics*weights[v])
+		}
+	}
+	m.sort()
+}
+
+func (m *Map) sort() {
+	sort.Slice(m.keyring, func(i, j int) bool {
+		return m.keyring[i] < m.keyring[j]
+	})
+}
+
+func (m *Map) add(v string, replics int) {
+	// v是一个真实的节点
+	// 将真实的节点虚拟化
+	for i := 0; i < replics; i++ {
+		// 虚拟节点的hash
+		m.place(m.hash([]byte(strconv.Itoa(i)+v)), v)
+	}
+}
+
+// 虚拟节点的hash冲突时，名称较小的真实节点占据这个位置，另一个顺延到下一个空闲的位置
+// 冲突的虚拟节点不会丢失，并且结果与添加的顺序无关
+func (m *Map) place(hash uint64, v string) {
+	for {
+		owner, ok := m.hashMap[hash]
+		if !ok {
 			m.hashMap[hash] = v
+			m.keyring = append(m.keyring, hash)
+			return
+		}
+		if v < owner {
+			m.hashMap[hash], v = v, owner
 		}
+		hash++
 	}
-	// 将所有的节点排序
-	sort.Ints(m.keyring)
 }
 
 // func (m *Map) Get(key string) string {}
@@ -56,7 +113,7 @@ func (m *Map) Get(key string) string {
 	if len(m.keyring) == 0 {
 		return ""
 	}
-	hash := int(m.hash([]byte(key)))
+	hash := m.hash([]byte(key))
 	// 二分法找到第一个比hash大的元素，在keyring中
 	idx := sort.Search(len(m.keyring), func(i int) bool {
 		return m.keyring[i] >= hash
@@ -66,3 +123,60 @@ func (m *Map) Get(key string) string {
 	res := m.keyring[idx%len(m.keyring)]
 	return m.hashMap[res]
 }
+
+// 从key的位置开始，沿着哈希环找到n个不同的真实节点，第一个就是Get返回的节点
+// 真实节点不足n个时，返回所有的真实节点
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.keyring) == 0 || n <= 0 {
+		return nil
+	}
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	m.walk(key, func(node string) bool {
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+		return len(nodes) < n
+	})
+	return nodes
+}
+
+// 哈希环上的一段，(Start, End]属于Node，Start大于等于End时表示跨过0回绕
+type Segment struct {
+	Start uint64
+	End   uint64
+	Node  string
+}
+
+// 长度占整个哈希空间的比例
+func (s Segment) Share() float64 {
+	// 只有一个虚拟节点时，这一段就是整个哈希空间
+	if s.Start == s.End {
+		return 1
+	}
+	return float64(s.End-s.Start) / (1 << 64)
+}
+
+// 按照哈希环的顺序返回所有的段，每个虚拟节点对应一段，即从前一个虚拟节点到它自己
+func (m *Map) Segments() []Segment {
+	if len(m.keyring) == 0 {
+		return nil
+	}
+	segments := make([]Segment, len(m.keyring))
+	for i, hash := range m.keyring {
+		start := m.keyring[(i+len(m.keyring)-1)%len(m.keyring)]
+		segments[i] = Segment{Start: start, End: hash, Node: m.hashMap[hash]}
+	}
+	return segments
+}
+
+// 每个真实节点占整个哈希空间的比例
+func Shares(segments []Segment) map[string]float64 {
+	shares := make(map[string]float64)
+	for _, s := range segments {
+		shares[s.Node] += s.Share()
+	}
+	return shares
+}
@@ -12,10 +12,10 @@ func TestHash(t *testing.T) {
 	convey.Convey("TestHash", t, func() {
 
 		// 假设有三个节点2,4,6，总的节点为[02, 04, 06, 12, 14, 16, 22, 24, 26]
-		m := New(3, func(data []byte) uint32 {
+		m := New(3, func(data []byte) uint64 {
 			// 模拟的哈希算法
 			i, _ := strconv.Atoi(string(data))
-			return uint32(i)
+			return uint64(i)
 		})
 
 		// 先Add
@@ -37,3 +37,166 @@ func TestHash(t *testing.T) {
 		}
 	})
 }
+
+func TestGetN(t *testing.T) {
+	convey.Convey("TestGetN", t, func() {
+		m := New(3, func(data []byte) uint64 {
+			i, _ := strconv.Atoi(string(data))
+			return uint64(i)
+		})
+		// [02, 04, 06, 12, 14, 16, 22, 24, 26]
+		m.Add("2", "4", "6")
+
+		convey.So(m.GetN("11", 2), convey.ShouldResemble, []string{"2", "4"})
+		convey.So(m.GetN("15", 3), convey.ShouldResemble, []string{"6", "2", "4"})
+		// 回绕
+		convey.So(m.GetN("25", 2), convey.ShouldResemble, []string{"6", "2"})
+		// 真实节点不足
+		convey.So(m.GetN("1", 5), convey.ShouldResemble, []string{"2", "4", "6"})
+		convey.So(m.GetN("1", 0), convey.ShouldBeNil)
+
+		for _, key := range []string{"1", "13", "27"} {
+			convey.So(m.GetN(key, 1)[0], convey.ShouldEqual, m.Get(key))
+		}
+	})
+}
+
+func TestAddWeighted(t *testing.T) {
+	convey.Convey("TestAddWeighted", t, func() {
+		weights := map[string]int{
+			"http://10.0.0.1:8001": 1,
+			"http://10.0.0.2:8001": 2,
+			"http://10.0.0.3:8001": 4,
+			"http://10.0.0.4:8001": 8,
+		}
+		m := New(500, nil)
+		m.AddWeighted(weights)
+
+		const keys = 200000
+		counts := make(map[string]int)
+		for i := 0; i < keys; i++ {
+			counts[m.Get("key-"+strconv.Itoa(i))]++
+		}
+
+		total := 0
+		for _, w := range weights {
+			total += w
+		}
+		// 每个节点都分到了key，比例与权重的比例相差不超过10%
+		convey.So(counts, convey.ShouldHaveLength, len(weights))
+		for node, w := range weights {
+			want := float64(keys) * float64(w) / float64(total)
+			convey.So(float64(counts[node]), convey.ShouldAlmostEqual, want, want*0.1)
+		}
+
+		convey.Convey("zero weight nodes are skipped", func() {
+			m := New(10, nil)
+			m.AddWeighted(map[string]int{"a": 1, "b": 0})
+			for i := 0; i < 100; i++ {
+				convey.So(m.Get(strconv.Itoa(i)), convey.ShouldEqual, "a")
+			}
+		})
+
+		convey.Convey("weight 1 matches Add", func() {
+			a := New(50, nil)
+			a.Add("x", "y", "z")
+			b := New(50, nil)
+			b.AddWeighted(map[string]int{"x": 1, "y": 1, "z": 1})
+			for i := 0; i < 1000; i++ {
+				convey.So(b.Get(strconv.Itoa(i)), convey.ShouldEqual, a.Get(strconv.Itoa(i)))
+			}
+		})
+	})
+}
+
+func TestCollision(t *testing.T) {
+	convey.Convey("TestCollision", t, func() {
+		convey.Convey("thousands of nodes keep every virtual node", func() {
+			const nodes, replics = 5000, 100
+			m := New(replics, nil)
+			var keys []string
+			for i := 0; i < nodes; i++ {
+				keys = append(keys, fmt.Sprintf("http://10.%d.%d.%d:8001", i/65536, i/256%256, i%256))
+			}
+			m.Add(keys...)
+
+			convey.So(len(m.keyring), convey.ShouldEqual, nodes*replics)
+			convey.So(len(m.hashMap), convey.ShouldEqual, nodes*replics)
+			counts := make(map[string]int)
+			for i, hash := range m.keyring {
+				if i > 0 && m.keyring[i-1] >= hash {
+					t.Fatalf("keyring not strictly increasing at %v", i)
+				}
+				counts[m.hashMap[hash]]++
+			}
+			convey.So(len(counts), convey.ShouldEqual, nodes)
+			for _, key := range keys {
+				if counts[key] != replics {
+					t.Fatalf("node %v has %v virtual nodes, want %v", key, counts[key], replics)
+				}
+			}
+		})
+
+		convey.Convey("colliding virtual nodes are resolved independent of order", func() {
+			// 只取第一个字节，大量冲突
+			hash := func(data []byte) uint64 {
+				return uint64(data[0])
+			}
+			a := New(10, hash)
+			a.Add("x", "y", "z")
+			b := New(10, hash)
+			b.Add("z", "x")
+			b.Add("y")
+
+			convey.So(len(a.keyring), convey.ShouldEqual, 30)
+			convey.So(b.keyring, convey.ShouldResemble, a.keyring)
+			convey.So(b.hashMap, convey.ShouldResemble, a.hashMap)
+			counts := make(map[string]int)
+			for _, v := range a.hashMap {
+				counts[v]++
+			}
+			convey.So(counts, convey.ShouldResemble, map[string]int{"x": 10, "y": 10, "z": 10})
+		})
+	})
+}
+
+func TestSegments(t *testing.T) {
+	convey.Convey("TestSegments", t, func() {
+		m := New(3, func(data []byte) uint64 {
+			i, _ := strconv.Atoi(string(data))
+			return uint64(i)
+		})
+		// [02, 04, 06, 12, 14, 16, 22, 24, 26]
+		m.Add("2", "4", "6")
+
+		segments := m.Segments()
+		convey.So(len(segments), convey.ShouldEqual, 9)
+		// 第一段跨过0回绕
+		convey.So(segments[0], convey.ShouldResemble, Segment{Start: 26, End: 2, Node: "2"})
+		convey.So(segments[4], convey.ShouldResemble, Segment{Start: 12, End: 14, Node: "4"})
+		convey.So(segments[4].Share(), convey.ShouldEqual, 2.0/(1<<64))
+
+		// key落在的段与Get的结果一致
+		for _, key := range []string{"1", "11", "13", "25", "27"} {
+			hash, _ := strconv.Atoi(key)
+			h := uint64(hash)
+			for _, s := range segments {
+				if (s.Start < s.End && s.Start < h && h <= s.End) || (s.Start >= s.End && (h > s.Start || h <= s.End)) {
+					convey.So(s.Node, convey.ShouldEqual, m.Get(key))
+				}
+			}
+		}
+
+		convey.Convey("shares sum to the whole hash space", func() {
+			m := New(100, nil)
+			m.AddWeighted(map[string]int{"a": 1, "b": 3})
+			shares := Shares(m.Segments())
+			convey.So(shares["a"]+shares["b"], convey.ShouldAlmostEqual, 1, 1e-9)
+			convey.So(shares["b"]/shares["a"], convey.ShouldAlmostEqual, 3, 0.6)
+
+			single := New(1, nil)
+			single.Add("a")
+			convey.So(Shares(single.Segments()), convey.ShouldResemble, map[string]float64{"a": 1})
+		})
+	})
+}
@@ -0,0 +1,291 @@
+package consistenthash
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// 决定key放在哪些节点上，HttpPool依赖这个接口
+type Placement interface {
+	// key所属的节点，没有节点时返回空字符串
+	Get(key string) string
+	// key所属的n个不同的节点，第一个就是Get返回的节点
+	GetN(key string, n int) []string
+}
+
+// 根据节点及其权重构造Placement
+type PlacementFunc func(weights map[string]int) Placement
+
+// 哈希环，每个节点REPLICS个虚拟节点乘以权重
+func Ring(replics int, hash HashFunc) PlacementFunc {
+	return func(weights map[string]int) Placement {
+		m := New(replics, hash)
+		m.AddWeighted(weights)
+		return m
+	}
+}
+
+var _ Placement = (*Map)(nil)
+
+// 沿着哈希环从key的位置开始遍历虚拟节点对应的真实节点，fn返回false时停止
+func (m *Map) walk(key string, fn func(node string) bool) {
+	if len(m.keyring) == 0 {
+		return
+	}
+	hash := m.hash([]byte(key))
+	idx := sort.Search(len(m.keyring), func(i int) bool {
+		return m.keyring[i] >= hash
+	})
+	for i := 0; i < len(m.keyring); i++ {
+		if !fn(m.hashMap[m.keyring[(idx+i)%len(m.keyring)]]) {
+			return
+		}
+	}
+}
+
+func hash64(parts ...string) uint64 {
+	h := fnv.New64a()
+	for _, part := range parts {
+		h.Write([]byte(part))
+	}
+	return mix64(h.Sum64())
+}
+
+// fnv的低位分布不够均匀，再做一次混合（splitmix64的finalizer）
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// 权重大于0的节点，按照名称排序
+func sortedNodes(weights map[string]int) []string {
+	nodes := make([]string, 0, len(weights))
+	for node, weight := range weights {
+		if weight > 0 {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Rendezvous（HRW）哈希：key对每个节点计算一个分数，分数最高的节点胜出
+// 不需要虚拟节点，分布只取决于哈希函数；增删节点时只有属于该节点的key移动
+// 带权重时分数为 -weight/ln(u)，u是(0,1)之间均匀分布的哈希值
+type Rendezvous struct {
+	nodes   []string
+	weights []float64
+}
+
+func NewRendezvous(weights map[string]int) *Rendezvous {
+	r := &Rendezvous{nodes: sortedNodes(weights)}
+	for _, node := range r.nodes {
+		r.weights = append(r.weights, float64(weights[node]))
+	}
+	return r
+}
+
+func (r *Rendezvous) score(key string, i int) float64 {
+	// 取高53位作为浮点数的尾数，加0.5避免u为0
+	u := (float64(hash64(key, "\x00", r.nodes[i])>>11) + 0.5) / (1 << 53)
+	return -r.weights[i] / math.Log(u)
+}
+
+func (r *Rendezvous) Get(key string) string {
+	best, bestScore := "", -1.0
+	for i, node := range r.nodes {
+		if s := r.score(key, i); s > bestScore {
+			best, bestScore = node, s
+		}
+	}
+	return best
+}
+
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if len(r.nodes) == 0 || n <= 0 {
+		return nil
+	}
+	scores := make([]float64, len(r.nodes))
+	order := make([]int, len(r.nodes))
+	for i := range r.nodes {
+		scores[i] = r.score(key, i)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+
+	if n > len(order) {
+		n = len(order)
+	}
+	nodes := make([]string, n)
+	for i := 0; i < n; i++ {
+		nodes[i] = r.nodes[order[i]]
+	}
+	return nodes
+}
+
+// Jump一致性哈希（Lamping & Veach）：不需要额外的内存，分布非常均匀
+// 只支持在末尾增删桶：节点按照名称排序，每个节点占weight个连续的桶，
+// 新节点的名称排在最后时只有1/n的key移动，否则移动的key会多很多，适合节点编号固定递增的场景
+type Jump struct {
+	buckets []string
+	nodes   int
+}
+
+func NewJump(weights map[string]int) *Jump {
+	j := &Jump{}
+	for _, node := range sortedNodes(weights) {
+		for i := 0; i < weights[node]; i++ {
+			j.buckets = append(j.buckets, node)
+		}
+		j.nodes++
+	}
+	return j
+}
+
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+func (j *Jump) Get(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jumpHash(hash64(key), len(j.buckets))]
+}
+
+// 第i个副本使用key和i重新计算哈希，跳过已经选中的节点
+func (j *Jump) GetN(key string, n int) []string {
+	if len(j.buckets) == 0 || n <= 0 {
+		return nil
+	}
+	if n > j.nodes {
+		n = j.nodes
+	}
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	var seed [8]byte
+	for i := uint64(0); len(nodes) < n; i++ {
+		h := hash64(key)
+		if i > 0 {
+			binary.LittleEndian.PutUint64(seed[:], i)
+			h = hash64(key, string(seed[:]))
+		}
+		node := j.buckets[jumpHash(h, len(j.buckets))]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// 有界负载的一致性哈希（Mirrokni et al.）：在哈希环上顺时针查找，
+// 跳过负载已经超过上限的节点；节点的上限为 ceil(factor * (总负载+1) * 权重/总权重)
+// 负载由load提供，例如正在处理的请求数；负载都为0时与哈希环相同
+type BoundedLoad struct {
+	ring    *Map
+	weights map[string]int
+	total   int // 总权重
+	factor  float64
+	load    func(node string) int64
+}
+
+// factor需要大于1，越接近1负载越均匀，但是key的位置越不稳定
+func NewBoundedLoad(replics int, hash HashFunc, weights map[string]int, factor float64, load func(node string) int64) *BoundedLoad {
+	b := &BoundedLoad{
+		ring:    New(replics, hash),
+		weights: make(map[string]int, len(weights)),
+		factor:  factor,
+		load:    load,
+	}
+	b.ring.AddWeighted(weights)
+	for node, weight := range weights {
+		if weight > 0 {
+			b.weights[node] = weight
+			b.total += weight
+		}
+	}
+	if b.factor <= 1 {
+		b.factor = 1.25
+	}
+	return b
+}
+
+// 返回一个判断节点是否还有余量的函数，同一次查找中负载只读取一次
+func (b *BoundedLoad) hasCapacity() func(node string) bool {
+	loads := make(map[string]int64, len(b.weights))
+	var sum int64
+	for node := range b.weights {
+		loads[node] = b.load(node)
+		sum += loads[node]
+	}
+	return func(node string) bool {
+		limit := math.Ceil(b.factor * float64(sum+1) * float64(b.weights[node]) / float64(b.total))
+		return float64(loads[node]) < limit
+	}
+}
+
+func (b *BoundedLoad) Get(key string) string {
+	nodes := b.GetN(key, 1)
+	if len(nodes) == 0 {
+		return ""
+	}
+	return nodes[0]
+}
+
+// 优先返回有余量的节点，不足n个时再按照哈希环的顺序补充超载的节点
+func (b *BoundedLoad) GetN(key string, n int) []string {
+	if b.total == 0 || n <= 0 {
+		return nil
+	}
+	ok := b.hasCapacity()
+
+	var available, overloaded []string
+	seen := make(map[string]bool)
+	b.ring.walk(key, func(node string) bool {
+		if !seen[node] {
+			seen[node] = true
+			if ok(node) {
+				available = append(available, node)
+			} else {
+				overloaded = append(overloaded, node)
+			}
+		}
+		return len(available) < n && len(seen) < len(b.weights)
+	})
+
+	nodes := append(available, overloaded...)
+	if len(nodes) > n {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// 负载都为0时key所在的哈希环的段
+func (b *BoundedLoad) Segments() []Segment {
+	return b.ring.Segments()
+}
+
+// 不依赖负载的放置：BoundedLoad返回底层的哈希环，其他放置返回自身
+// 各节点看到的负载不同，key的归属需要所有节点算出相同的结果
+func Stable(p Placement) Placement {
+	if b, ok := p.(*BoundedLoad); ok {
+		return b.ring
+	}
+	return p
+}
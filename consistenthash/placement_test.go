@@ -0,0 +1,172 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+var placements = map[string]PlacementFunc{
+	"ring":       Ring(100, nil),
+	"rendezvous": func(weights map[string]int) Placement { return NewRendezvous(weights) },
+	"jump":       func(weights map[string]int) Placement { return NewJump(weights) },
+	"bounded": func(weights map[string]int) Placement {
+		return NewBoundedLoad(100, nil, weights, 1.25, func(string) int64 { return 0 })
+	},
+}
+
+func nodeWeights(n int) map[string]int {
+	weights := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		weights[fmt.Sprintf("http://10.0.0.%03d:8001", i)] = 1
+	}
+	return weights
+}
+
+func TestPlacement(t *testing.T) {
+	convey.Convey("TestPlacement", t, func() {
+		weights := nodeWeights(5)
+		for _, newPlacement := range placements {
+			p := newPlacement(weights)
+			for i := 0; i < 1000; i++ {
+				key := "key-" + strconv.Itoa(i)
+				nodes := p.GetN(key, 3)
+				convey.So(len(nodes), convey.ShouldEqual, 3)
+				convey.So(nodes[0], convey.ShouldEqual, p.Get(key))
+				convey.So(nodes[1], convey.ShouldNotEqual, nodes[0])
+				convey.So(nodes[2], convey.ShouldNotBeIn, nodes[:2])
+			}
+			convey.So(len(p.GetN("key", 10)), convey.ShouldEqual, 5)
+			convey.So(newPlacement(nil).Get("key"), convey.ShouldEqual, "")
+			convey.So(newPlacement(nil).GetN("key", 2), convey.ShouldBeNil)
+		}
+
+		convey.Convey("rendezvous and jump follow weights closely", func() {
+			weights := map[string]int{"a": 1, "b": 2, "c": 4, "d": 8}
+			for _, p := range []Placement{NewRendezvous(weights), NewJump(weights)} {
+				counts := make(map[string]int)
+				const keys = 150000
+				for i := 0; i < keys; i++ {
+					counts[p.Get("key-"+strconv.Itoa(i))]++
+				}
+				for node, w := range weights {
+					want := float64(keys) * float64(w) / 15
+					convey.So(float64(counts[node]), convey.ShouldAlmostEqual, want, want*0.05)
+				}
+			}
+		})
+
+		convey.Convey("adding a node only moves keys to the new node", func() {
+			before := nodeWeights(10)
+			after := nodeWeights(11)
+			for _, newPlacement := range []PlacementFunc{placements["rendezvous"], placements["jump"]} {
+				old, cur := newPlacement(before), newPlacement(after)
+				for i := 0; i < 10000; i++ {
+					key := "key-" + strconv.Itoa(i)
+					if o, c := old.Get(key), cur.Get(key); o != c {
+						convey.So(before, convey.ShouldNotContainKey, c)
+					}
+				}
+			}
+		})
+	})
+}
+
+func TestBoundedLoad(t *testing.T) {
+	convey.Convey("TestBoundedLoad", t, func() {
+		weights := nodeWeights(8)
+		loads := make(map[string]int64)
+		b := NewBoundedLoad(100, nil, weights, 1.25, func(node string) int64 { return loads[node] })
+
+		// 每次放置增加节点的负载，最大负载不超过平均负载的1.25倍（向上取整）
+		const keys = 8000
+		for i := 0; i < keys; i++ {
+			loads[b.Get("key-"+strconv.Itoa(i))]++
+		}
+		limit := int64(math.Ceil(1.25 * keys / 8))
+		for node := range weights {
+			convey.So(loads[node], convey.ShouldBeLessThanOrEqualTo, limit)
+		}
+
+		convey.Convey("without load it matches the ring", func() {
+			ring := New(100, nil)
+			ring.AddWeighted(weights)
+			b := NewBoundedLoad(100, nil, weights, 1.25, func(string) int64 { return 0 })
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i)
+				convey.So(b.GetN(key, 2), convey.ShouldResemble, ring.GetN(key, 2))
+			}
+		})
+
+		convey.Convey("overloaded nodes come last", func() {
+			ring := New(100, nil)
+			ring.AddWeighted(weights)
+			hot := ring.Get("hot")
+			b := NewBoundedLoad(100, nil, weights, 1.25, func(node string) int64 {
+				if node == hot {
+					return 100
+				}
+				return 0
+			})
+			nodes := b.GetN("hot", 8)
+			convey.So(nodes[0], convey.ShouldNotEqual, hot)
+			convey.So(nodes[7], convey.ShouldEqual, hot)
+
+			// Stable忽略负载，仍然是哈希环上的顺序
+			convey.So(Stable(b).Get("hot"), convey.ShouldEqual, hot)
+			convey.So(Stable(b).GetN("hot", 8), convey.ShouldResemble, ring.GetN("hot", 8))
+		})
+	})
+}
+
+// 比较不同算法的分布和节点变化时移动的key，运行：
+// go test -run=^$ -bench=Placement ./consistenthash
+// max/mean：最多的节点分到的key与平均值之比；moved%：增加一个节点后移动的key的比例，理想值为1/(n+1)
+// bounded以每个节点已经分到的key数作为负载
+func BenchmarkPlacement(b *testing.B) {
+	const keys = 100000
+	for _, nodes := range []int{10, 100} {
+		for _, name := range []string{"ring", "rendezvous", "jump", "bounded"} {
+			newPlacement := func(weights map[string]int, counts map[string]int) Placement {
+				if name == "bounded" {
+					return NewBoundedLoad(100, nil, weights, 1.25, func(node string) int64 { return int64(counts[node]) })
+				}
+				return placements[name](weights)
+			}
+			b.Run(fmt.Sprintf("%v/nodes=%v", name, nodes), func(b *testing.B) {
+				counts := make(map[string]int)
+				afterCounts := make(map[string]int)
+				before := newPlacement(nodeWeights(nodes), counts)
+				after := newPlacement(nodeWeights(nodes+1), afterCounts)
+
+				moved := 0
+				for i := 0; i < keys; i++ {
+					key := "key-" + strconv.Itoa(i)
+					node := before.Get(key)
+					counts[node]++
+					afterNode := after.Get(key)
+					afterCounts[afterNode]++
+					if afterNode != node {
+						moved++
+					}
+				}
+				max := 0
+				for _, c := range counts {
+					if c > max {
+						max = c
+					}
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					before.Get("key-" + strconv.Itoa(i%keys))
+				}
+				b.ReportMetric(float64(max)/(float64(keys)/float64(nodes)), "max/mean")
+				b.ReportMetric(float64(moved)*100/keys, "moved%")
+			})
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package disk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 本地磁盘缓存，作为内存缓存的第二层，存放被内存淘汰的数据
+// 数据只追加写入segment文件，内存中保存key到文件位置的索引
+// 超出容量时，先压缩垃圾较多的segment，仍然超出则删除最早的segment
+//
+// 记录的布局：| crc32(4) | keyLen(4) | valueLen(4) | key | value |
+// crc32覆盖keyLen之后的所有内容；valueLen为tombstone表示删除
+
+const (
+	recordHeaderSize = 12
+	tombstone        = ^uint32(0)
+	segmentExt       = ".seg"
+	// 垃圾超过一半的segment会被压缩
+	compactRatio = 0.5
+)
+
+var ErrNotFound = errors.New("disk: key not found")
+var ErrCorrupted = errors.New("disk: record corrupted")
+
+type location struct {
+	segment uint64
+	offset  int64
+	size    int64 // 整条记录的大小
+}
+
+type segment struct {
+	id   uint64
+	file *os.File
+	size int64 // 文件大小
+	live int64 // 有效记录的大小
+}
+
+type Store struct {
+	mutex       sync.Mutex
+	dir         string
+	maxBytes    int64
+	segmentSize int64
+
+	segments map[uint64]*segment
+	active   *segment
+	index    map[string]location
+	total    int64 // 所有segment的大小之和
+}
+
+// 打开目录下的磁盘缓存，已有的segment会被加载；maxBytes是磁盘的容量
+// 每个segment的大小为容量的1/8，至少需要两个segment才能淘汰
+func Open(dir string, maxBytes int64) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		segmentSize: maxBytes / 8,
+		segments:    make(map[uint64]*segment),
+		index:       make(map[string]location),
+	}
+	if s.segmentSize < 4096 {
+		s.segmentSize = 4096
+	}
+
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016x%s", id, segmentExt))
+}
+
+// 按照id的顺序加载已有的segment，重建索引
+func (s *Store) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	var ids []uint64
+	for _, entry := range entries {
+		var id uint64
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, segmentExt), "%x", &id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		if err := s.loadSegment(id); err != nil {
+			return err
+		}
+	}
+
+	var next uint64
+	if len(ids) > 0 {
+		next = ids[len(ids)-1] + 1
+	}
+	return s.roll(next)
+}
+
+// 读取segment中的记录，遇到损坏的记录时截断，之后的记录丢弃
+func (s *Store) loadSegment(id uint64) error {
+	file, err := os.OpenFile(s.segmentPath(id), os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	seg := &segment{id: id, file: file}
+	s.segments[id] = seg
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(file)
+	var offset int64
+	for {
+		key, value, size, err := readRecord(r, info.Size()-offset)
+		if err != nil {
+			break
+		}
+		s.drop(key)
+		if value != nil {
+			s.index[key] = location{segment: id, offset: offset, size: size}
+			seg.live += size
+		}
+		offset += size
+	}
+
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+	seg.size = offset
+	s.total += offset
+	return nil
+}
+
+// remaining是r中剩余的字节数，记录的长度超过剩余的字节数时说明记录头已经损坏，不分配内存
+func readRecord(r io.Reader, remaining int64) (key string, value []byte, size int64, err error) {
+	var header [recordHeaderSize]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	sum := binary.LittleEndian.Uint32(header[0:4])
+	keyLen := binary.LittleEndian.Uint32(header[4:8])
+	valueLen := binary.LittleEndian.Uint32(header[8:12])
+
+	bodyLen := int64(keyLen)
+	if valueLen != tombstone {
+		bodyLen += int64(valueLen)
+	}
+	if recordHeaderSize+bodyLen > remaining {
+		err = ErrCorrupted
+		return
+	}
+	body := make([]byte, bodyLen)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	if crc.Sum32() != sum {
+		err = ErrCorrupted
+		return
+	}
+
+	key = string(body[:keyLen])
+	if valueLen != tombstone {
+		value = body[keyLen:]
+	}
+	size = recordHeaderSize + bodyLen
+	return
+}
+
+func encodeRecord(key string, value []byte, deleted bool) []byte {
+	valueLen := uint32(len(value))
+	if deleted {
+		valueLen = tombstone
+		value = nil
+	}
+
+	buf := make([]byte, recordHeaderSize+len(key)+len(value))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[8:12], valueLen)
+	copy(buf[recordHeaderSize:], key)
+	copy(buf[recordHeaderSize+len(key):], value)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// 新建一个segment作为当前写入的segment
+func (s *Store) roll(id uint64) error {
+	file, err := os.OpenFile(s.segmentPath(id), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	seg := &segment{id: id, file: file}
+	s.segments[id] = seg
+	s.active = seg
+	return nil
+}
+
+func (s *Store) append(key string, value []byte, deleted bool) (location, error) {
+	if s.active.size >= s.segmentSize {
+		if err := s.roll(s.active.id + 1); err != nil {
+			return location{}, err
+		}
+	}
+
+	record := encodeRecord(key, value, deleted)
+	seg := s.active
+	if _, err := seg.file.WriteAt(record, seg.size); err != nil {
+		return location{}, err
+	}
+
+	loc := location{segment: seg.id, offset: seg.size, size: int64(len(record))}
+	seg.size += loc.size
+	s.total += loc.size
+	return loc, nil
+}
+
+// 从索引中删除，原来的记录变成垃圾
+func (s *Store) drop(key string) {
+	if loc, ok := s.index[key]; ok {
+		delete(s.index, key)
+		if seg, ok := s.segments[loc.segment]; ok {
+			seg.live -= loc.size
+		}
+	}
+}
+
+// 写入，超出容量时压缩或者淘汰
+func (s *Store) Put(key string, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if int64(recordHeaderSize+len(key)+len(value)) > s.segmentSize {
+		s.drop(key)
+		return nil
+	}
+
+	loc, err := s.append(key, value, false)
+	if err != nil {
+		return err
+	}
+	s.drop(key)
+	s.index[key] = loc
+	s.active.live += loc.size
+
+	return s.enforce()
+}
+
+func (s *Store) Get(key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	loc, ok := s.index[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	value, err := s.read(loc)
+	if err != nil {
+		s.drop(key)
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *Store) read(loc location) ([]byte, error) {
+	seg := s.segments[loc.segment]
+	r := io.NewSectionReader(seg.file, loc.offset, loc.size)
+	_, value, _, err := readRecord(r, loc.size)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ErrCorrupted
+	}
+	return value, nil
+}
+
+// 删除，写入tombstone，重新打开时不会恢复
+func (s *Store) Remove(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.index[key]; !ok {
+		return nil
+	}
+	s.drop(key)
+	if _, err := s.append(key, nil, true); err != nil {
+		return err
+	}
+	return s.enforce()
+}
+
+// 超出容量时，先压缩垃圾较多的segment，再从最早的segment开始删除
+func (s *Store) enforce() error {
+	if s.total <= s.maxBytes {
+		return nil
+	}
+
+	for _, seg := range s.sealed() {
+		if float64(seg.live) < float64(seg.size)*compactRatio {
+			if err := s.compact(seg); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, seg := range s.sealed() {
+		if s.total <= s.maxBytes {
+			break
+		}
+		for key, loc := range s.index {
+			if loc.segment == seg.id {
+				delete(s.index, key)
+			}
+		}
+		if err := s.removeSegment(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 除当前写入之外的segment，按照id排序，即从最早到最新
+func (s *Store) sealed() []*segment {
+	segs := make([]*segment, 0, len(s.segments))
+	for _, seg := range s.segments {
+		if seg != s.active {
+			segs = append(segs, seg)
+		}
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].id < segs[j].id })
+	return segs
+}
+
+// 把segment中有效的记录重新写到当前的segment，然后删除
+// 如果还有更早的segment，tombstone也需要保留，否则重新打开时更早的记录会复活
+func (s *Store) compact(seg *segment) error {
+	older := false
+	for id := range s.segments {
+		if id < seg.id {
+			older = true
+			break
+		}
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(seg.file, 0, seg.size))
+	var offset int64
+	for {
+		key, value, size, err := readRecord(r, seg.size-offset)
+		if err != nil {
+			break
+		}
+		loc, ok := s.index[key]
+		switch {
+		case value == nil && !ok && older:
+			if _, err := s.append(key, nil, true); err != nil {
+				return err
+			}
+		case value != nil && ok && loc.segment == seg.id && loc.offset == offset:
+			newLoc, err := s.append(key, value, false)
+			if err != nil {
+				return err
+			}
+			s.index[key] = newLoc
+			s.segments[newLoc.segment].live += newLoc.size
+		}
+		offset += size
+	}
+	return s.removeSegment(seg)
+}
+
+func (s *Store) removeSegment(seg *segment) error {
+	delete(s.segments, seg.id)
+	s.total -= seg.size
+	seg.file.Close()
+	return os.Remove(s.segmentPath(seg.id))
+}
+
+// 手动压缩所有垃圾较多的segment
+func (s *Store) Compact() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, seg := range s.sealed() {
+		if float64(seg.live) < float64(seg.size)*compactRatio {
+			if err := s.compact(seg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 删除所有的数据和segment文件
+func (s *Store) Clear() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	next := s.active.id + 1
+	for _, seg := range s.segments {
+		if err := s.removeSegment(seg); err != nil {
+			return err
+		}
+	}
+	s.index = make(map[string]location)
+	return s.roll(next)
+}
+
+// 是否存在，只查找索引，不读取文件
+func (s *Store) Has(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.index[key]
+	return ok
+}
+
+func (s *Store) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.index)
+}
+
+// 所有segment文件的大小之和
+func (s *Store) Bytes() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.total
+}
+
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var err error
+	for _, seg := range s.segments {
+		if e := seg.file.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
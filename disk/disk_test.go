@@ -0,0 +1,135 @@
+package disk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestPutAndGet(t *testing.T) {
+	convey.Convey("TestPutAndGet", t, func() {
+		dir := t.TempDir()
+		store, err := Open(dir, 1<<20)
+		convey.So(err, convey.ShouldBeNil)
+		defer store.Close()
+
+		convey.So(store.Put("zhangsan", []byte("100")), convey.ShouldBeNil)
+		convey.So(store.Put("lisi", []byte("200")), convey.ShouldBeNil)
+		convey.So(store.Put("zhangsan", []byte("300")), convey.ShouldBeNil)
+
+		value, err := store.Get("zhangsan")
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(string(value), convey.ShouldEqual, "300")
+		convey.So(store.Len(), convey.ShouldEqual, 2)
+		convey.So(store.Has("lisi"), convey.ShouldBeTrue)
+
+		convey.So(store.Remove("lisi"), convey.ShouldBeNil)
+		convey.So(store.Has("lisi"), convey.ShouldBeFalse)
+		_, err = store.Get("lisi")
+		convey.So(err, convey.ShouldEqual, ErrNotFound)
+
+		convey.Convey("reopen rebuilds the index", func() {
+			store.Close()
+			store, err := Open(dir, 1<<20)
+			convey.So(err, convey.ShouldBeNil)
+			defer store.Close()
+
+			value, err := store.Get("zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(value), convey.ShouldEqual, "300")
+			_, err = store.Get("lisi")
+			convey.So(err, convey.ShouldEqual, ErrNotFound)
+		})
+
+		convey.Convey("clear removes everything", func() {
+			convey.So(store.Clear(), convey.ShouldBeNil)
+			convey.So(store.Len(), convey.ShouldEqual, 0)
+			convey.So(store.Bytes(), convey.ShouldEqual, 0)
+			_, err := store.Get("zhangsan")
+			convey.So(err, convey.ShouldEqual, ErrNotFound)
+
+			convey.So(store.Put("wangwu", []byte("400")), convey.ShouldBeNil)
+			store.Close()
+			store, err := Open(dir, 1<<20)
+			convey.So(err, convey.ShouldBeNil)
+			defer store.Close()
+			convey.So(store.Len(), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("corrupted tail is truncated on reopen", func() {
+			store.Close()
+			matches, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+			convey.So(len(matches), convey.ShouldBeGreaterThan, 0)
+			f, _ := os.OpenFile(matches[0], os.O_WRONLY|os.O_APPEND, 0o644)
+			f.Write([]byte("garbage"))
+			f.Close()
+
+			store, err := Open(dir, 1<<20)
+			convey.So(err, convey.ShouldBeNil)
+			defer store.Close()
+
+			value, err := store.Get("zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(value), convey.ShouldEqual, "300")
+		})
+
+		convey.Convey("torn header with a huge length is truncated without allocating", func() {
+			store.Close()
+			matches, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+			convey.So(len(matches), convey.ShouldBeGreaterThan, 0)
+			header := make([]byte, recordHeaderSize)
+			binary.LittleEndian.PutUint32(header[4:8], 0xfffffff0)
+			binary.LittleEndian.PutUint32(header[8:12], 0xfffffff0)
+			f, _ := os.OpenFile(matches[0], os.O_WRONLY|os.O_APPEND, 0o644)
+			f.Write(header)
+			f.Close()
+
+			store, err := Open(dir, 1<<20)
+			convey.So(err, convey.ShouldBeNil)
+			defer store.Close()
+
+			value, err := store.Get("zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(value), convey.ShouldEqual, "300")
+		})
+	})
+}
+
+func TestBudgetAndCompaction(t *testing.T) {
+	convey.Convey("TestBudgetAndCompaction", t, func() {
+		const maxBytes = 64 << 10
+		store, err := Open(t.TempDir(), maxBytes)
+		convey.So(err, convey.ShouldBeNil)
+		defer store.Close()
+
+		value := make([]byte, 100)
+
+		convey.Convey("oldest entries are dropped when over budget", func() {
+			for i := 0; i < 2000; i++ {
+				convey.So(store.Put(fmt.Sprintf("key-%04d", i), value), convey.ShouldBeNil)
+			}
+			convey.So(store.Bytes(), convey.ShouldBeLessThanOrEqualTo, maxBytes)
+
+			_, err := store.Get("key-0000")
+			convey.So(err, convey.ShouldEqual, ErrNotFound)
+			_, err = store.Get("key-1999")
+			convey.So(err, convey.ShouldBeNil)
+		})
+
+		convey.Convey("overwritten entries are compacted instead of dropped", func() {
+			for i := 0; i < 2000; i++ {
+				convey.So(store.Put(fmt.Sprintf("key-%02d", i%50), value), convey.ShouldBeNil)
+			}
+			convey.So(store.Bytes(), convey.ShouldBeLessThanOrEqualTo, maxBytes)
+			convey.So(store.Len(), convey.ShouldEqual, 50)
+			for i := 0; i < 50; i++ {
+				_, err := store.Get(fmt.Sprintf("key-%02d", i))
+				convey.So(err, convey.ShouldBeNil)
+			}
+		})
+	})
+}
@@ -2,6 +2,7 @@ package lru
 
 import (
 	"container/list"
+	"unsafe"
 )
 
 // LRU缓存策略
@@ -12,6 +13,14 @@ type Value interface {
 	Len() int
 }
 
+// 每个元素除了key和value之外的内存开销：list.Element、node，以及map中的一个槽位
+// 前两项是准确的大小，map槽位是估计值，实际占用取决于map的实现和扩容时机
+const EntryOverhead = int64(unsafe.Sizeof(list.Element{})+unsafe.Sizeof(node{})) + mapSlotOverhead
+
+// map中一个槽位的估计值，没有经过测量：string header(16) + 指针(8) + tophash(1)，
+// 按照平均装载因子 6.5/8 折算后约为31字节，取32
+const mapSlotOverhead = 32
+
 // 存储到队列中的节点
 type node struct {
 	key   string
@@ -20,6 +29,9 @@ type node struct {
 
 type HandleFunc func(string, Value)
 
+// 准入回调，admitted为false表示value过大被拒绝
+type AdmitFunc func(key string, value Value, admitted bool)
+
 // 缓存
 type Cache struct {
 	// map真正存储数据的
@@ -33,6 +45,10 @@ type Cache struct {
 	availableCapacity int64
 	// 已经使用的容量
 	usedCapacity int64
+	// 单个元素的最大容量，为0时以maxCapacity为准
+	maxItemSize int64
+	// 每个元素额外计入的开销，为0时只统计key和value的长度
+	entryOverhead int64
 
 	// 记录删除时，回调
 	delete HandleFunc
@@ -40,6 +56,8 @@ type Cache struct {
 	add HandleFunc
 	// 记录更新时，回调
 	update HandleFunc
+	// 记录准入结果，回调
+	admit AdmitFunc
 }
 
 func New(maxCapacity int64) *Cache {
@@ -47,6 +65,8 @@ func New(maxCapacity int64) *Cache {
 		cache:       make(map[string]*list.Element),
 		queue:       list.New(),
 		maxCapacity: maxCapacity,
+
+		availableCapacity: maxCapacity,
 	}
 }
 
@@ -63,38 +83,112 @@ func (c *Cache) Get(key string) (value Value, ok bool) {
 	return nil, false
 }
 
+// 查找，但不移动节点，即不影响淘汰顺序
+func (c *Cache) Peek(key string) (value Value, ok bool) {
+	if element, ok := c.cache[key]; ok {
+		return element.Value.(*node).value, true
+	}
+	return nil, false
+}
+
+// 是否存在，不影响淘汰顺序
+func (c *Cache) Contains(key string) bool {
+	_, ok := c.cache[key]
+	return ok
+}
+
 // 删除最近最少使用的元素，即队头元素
 func (c *Cache) RemoveOldElement() {
 	// 1. 找到队头元素； 2. 从map中删除； 3. 更新所占内存； 4. 回调删除方法
 	oldElement := c.queue.Front()
 	if oldElement != nil {
-		c.queue.Remove(oldElement)
+		c.removeElement(oldElement)
+	}
+}
 
-		node := oldElement.Value.(*node)
-		delete(c.cache, node.key)
+// 删除指定的key，返回key是否存在
+func (c *Cache) Remove(key string) bool {
+	if element, ok := c.cache[key]; ok {
+		c.removeElement(element)
+		return true
+	}
+	return false
+}
 
-		length := int64(len(node.key) + node.value.Len())
-		c.usedCapacity -= length
-		c.availableCapacity = c.maxCapacity - c.usedCapacity
+func (c *Cache) removeElement(element *list.Element) {
+	c.queue.Remove(element)
 
-		if c.delete != nil {
-			c.delete(node.key, node.value)
-		}
+	node := element.Value.(*node)
+	delete(c.cache, node.key)
+
+	c.usedCapacity -= c.entrySize(node.key, node.value)
+	c.availableCapacity = c.maxCapacity - c.usedCapacity
+
+	if c.delete != nil {
+		c.delete(node.key, node.value)
 	}
 }
 
-// 新增、修改
-func (c *Cache) Add(key string, value Value) {
-	// log.Printf("lru Add | key: %v, value: %v\n", key, value)
+// 清空缓存，每个元素都会回调删除方法
+func (c *Cache) Clear() {
+	for c.queue.Len() > 0 {
+		c.RemoveOldElement()
+	}
+}
 
-	// log.Println("lru Add | c is nil? ", c == nil)
+// 调整最大容量，如果已使用的容量超出新的限制，淘汰最近最少使用的节点
+func (c *Cache) Resize(maxCapacity int64) {
+	c.maxCapacity = maxCapacity
+	c.availableCapacity = c.maxCapacity - c.usedCapacity
 
-	// element := c.cache[key]
-	// log.Printf("lru Add | key: %v, element: %v\n", key, element)
+	for c.maxCapacity > 0 && c.usedCapacity > c.maxCapacity && c.queue.Len() > 0 {
+		c.RemoveOldElement()
+	}
+}
+
+// 元素个数
+func (c *Cache) Len() int {
+	return c.queue.Len()
+}
+
+// 所有的key，按照最近最少使用到最近使用的顺序，即队头到队尾
+func (c *Cache) Keys() []string {
+	keys := make([]string, 0, c.queue.Len())
+	for element := c.queue.Front(); element != nil; element = element.Next() {
+		keys = append(keys, element.Value.(*node).key)
+	}
+	return keys
+}
+
+// 已经使用的容量
+func (c *Cache) UsedCapacity() int64 {
+	return c.usedCapacity
+}
+
+// 可用容量，maxCapacity为0时表示不限制，此时返回值没有意义
+func (c *Cache) AvailableCapacity() int64 {
+	return c.availableCapacity
+}
+
+// 最大容量
+func (c *Cache) MaxCapacity() int64 {
+	return c.maxCapacity
+}
+
+// 新增、修改，返回是否被缓存
+func (c *Cache) Add(key string, value Value) bool {
+	// 0. 判断value是否过大，过大则拒绝，并删除旧值，避免读到过期的数据；
+	// 1. 先判断有没有；
+	// 2. 添加元素到map； 3. 将节点插入到队尾； 4. 更新所占内存； 5. 回调添加方法；
+	// 6. 如果内存超出最大限制，循环将最近最少使用的节点删除，直到满足限制
+	if !c.admissible(key, value) {
+		c.Remove(key)
+		if c.admit != nil {
+			c.admit(key, value, false)
+		}
+		return false
+	}
 
-	// 0. 先判断有没有；
-	// 1. 添加元素到map； 2. 将节点插入到队尾； 3. 更新所占内存； 4. 回调添加方法；
-	// 5. 如果内存超出最大限制，需要将最近最少使用的节点删除
 	if element, ok := c.cache[key]; ok {
 		c.queue.MoveToBack(element)
 
@@ -115,7 +209,7 @@ func (c *Cache) Add(key string, value Value) {
 
 		c.cache[key] = element
 
-		c.usedCapacity += int64(len(node.key) + node.value.Len())
+		c.usedCapacity += c.entrySize(node.key, node.value)
 		c.availableCapacity = c.maxCapacity - c.usedCapacity
 
 		if c.add != nil {
@@ -124,10 +218,49 @@ func (c *Cache) Add(key string, value Value) {
 
 	}
 
-	if c.maxCapacity > 0 && c.usedCapacity > c.maxCapacity {
+	// 新增的节点在队尾，且不超过maxCapacity，因此不会被自己淘汰
+	for c.maxCapacity > 0 && c.usedCapacity > c.maxCapacity {
 		c.RemoveOldElement()
 	}
 
+	if c.admit != nil {
+		c.admit(key, value, true)
+	}
+	return true
+}
+
+// 元素所占的容量
+func (c *Cache) entrySize(key string, value Value) int64 {
+	return int64(len(key)+value.Len()) + c.entryOverhead
+}
+
+// 单个元素不能超过maxItemSize，也不能超过maxCapacity
+func (c *Cache) admissible(key string, value Value) bool {
+	size := c.entrySize(key, value)
+	if c.maxItemSize > 0 && size > c.maxItemSize {
+		return false
+	}
+	if c.maxCapacity > 0 && size > c.maxCapacity {
+		return false
+	}
+	return true
+}
+
+// 设置单个元素的最大容量，为0时以maxCapacity为准
+func (c *Cache) SetMaxItemSize(maxItemSize int64) {
+	c.maxItemSize = maxItemSize
+}
+
+// 设置每个元素额外计入的开销，用于让容量接近真实的内存占用，例如EntryOverhead
+// 会重新计算已经使用的容量，超出限制时淘汰
+func (c *Cache) SetEntryOverhead(overhead int64) {
+	c.usedCapacity += int64(c.queue.Len()) * (overhead - c.entryOverhead)
+	c.entryOverhead = overhead
+	c.Resize(c.maxCapacity)
+}
+
+func (c *Cache) SetAdmitHandler(handler AdmitFunc) {
+	c.admit = handler
 }
 
 func (c *Cache) SetDeleteHandler(handler HandleFunc) {
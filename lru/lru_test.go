@@ -1,8 +1,11 @@
 package lru
 
 import (
+	"fmt"
 	"log"
+	"strings"
 	"testing"
+	"testing/quick"
 
 	"github.com/smartystreets/goconvey/convey"
 )
@@ -40,6 +43,210 @@ func TestAddAndGet(t *testing.T) {
 	})
 }
 
+func TestRemoveAndPeek(t *testing.T) {
+	convey.Convey("TestRemoveAndPeek", t, func() {
+		cache := New(MAX_CAPACITY)
+		cache.Add("k1", String("v1"))
+		cache.Add("k2", String("v2"))
+		cache.Add("k3", String("v3"))
+
+		convey.Convey("Peek does not promote", func() {
+			data, ok := cache.Peek("k1")
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(data, convey.ShouldEqual, String("v1"))
+			convey.So(cache.Keys(), convey.ShouldResemble, []string{"k1", "k2", "k3"})
+			convey.So(cache.Contains("k2"), convey.ShouldBeTrue)
+			convey.So(cache.Contains("k4"), convey.ShouldBeFalse)
+		})
+
+		convey.Convey("Get promotes", func() {
+			cache.Get("k1")
+			convey.So(cache.Keys(), convey.ShouldResemble, []string{"k2", "k3", "k1"})
+		})
+
+		convey.Convey("Remove", func() {
+			var deleted []string
+			cache.SetDeleteHandler(func(s string, v Value) {
+				deleted = append(deleted, s)
+			})
+
+			convey.So(cache.Remove("k2"), convey.ShouldBeTrue)
+			convey.So(cache.Remove("k2"), convey.ShouldBeFalse)
+			convey.So(deleted, convey.ShouldResemble, []string{"k2"})
+			convey.So(cache.Len(), convey.ShouldEqual, 2)
+			convey.So(cache.UsedCapacity(), convey.ShouldEqual, 8)
+			convey.So(cache.AvailableCapacity(), convey.ShouldEqual, MAX_CAPACITY-8)
+		})
+
+		convey.Convey("Clear", func() {
+			var deleted []string
+			cache.SetDeleteHandler(func(s string, v Value) {
+				deleted = append(deleted, s)
+			})
+
+			cache.Clear()
+			convey.So(deleted, convey.ShouldResemble, []string{"k1", "k2", "k3"})
+			convey.So(cache.Len(), convey.ShouldEqual, 0)
+			convey.So(cache.UsedCapacity(), convey.ShouldEqual, 0)
+		})
+	})
+}
+
+func TestResize(t *testing.T) {
+	convey.Convey("TestResize", t, func() {
+		cache := New(MAX_CAPACITY)
+		cache.Add("k1", String("v1"))
+		cache.Add("k2", String("v2"))
+		cache.Add("k3", String("v3"))
+
+		cache.Resize(8)
+		convey.So(cache.MaxCapacity(), convey.ShouldEqual, 8)
+		convey.So(cache.Keys(), convey.ShouldResemble, []string{"k2", "k3"})
+		convey.So(cache.UsedCapacity(), convey.ShouldEqual, 8)
+		convey.So(cache.AvailableCapacity(), convey.ShouldEqual, 0)
+
+		cache.Resize(0)
+		cache.Add("k4", String("v4"))
+		convey.So(cache.Len(), convey.ShouldEqual, 3)
+	})
+}
+
+func TestAddEvictUntilFit(t *testing.T) {
+	convey.Convey("TestAddEvictUntilFit", t, func() {
+		cache := New(20)
+		cache.Add("k1", String("v1"))
+		cache.Add("k2", String("v2"))
+		cache.Add("k3", String("v3"))
+
+		// 一次插入需要淘汰多个节点
+		ok := cache.Add("k4", String("0123456789abcd"))
+		convey.So(ok, convey.ShouldBeTrue)
+		convey.So(cache.Keys(), convey.ShouldResemble, []string{"k3", "k4"})
+		convey.So(cache.UsedCapacity(), convey.ShouldBeLessThanOrEqualTo, 20)
+	})
+}
+
+func TestAddOversize(t *testing.T) {
+	convey.Convey("TestAddOversize", t, func() {
+		cache := New(20)
+		cache.SetMaxItemSize(10)
+
+		var rejected []string
+		cache.SetAdmitHandler(func(key string, value Value, admitted bool) {
+			if !admitted {
+				rejected = append(rejected, key)
+			}
+		})
+
+		cache.Add("k1", String("v1"))
+		cache.Add("k2", String("v2"))
+
+		convey.Convey("value bigger than max item size is rejected", func() {
+			ok := cache.Add("k3", String("0123456789"))
+			convey.So(ok, convey.ShouldBeFalse)
+			convey.So(rejected, convey.ShouldResemble, []string{"k3"})
+			convey.So(cache.Keys(), convey.ShouldResemble, []string{"k1", "k2"})
+		})
+
+		convey.Convey("rejected update removes the stale value", func() {
+			ok := cache.Add("k1", String("0123456789"))
+			convey.So(ok, convey.ShouldBeFalse)
+			convey.So(cache.Contains("k1"), convey.ShouldBeFalse)
+			convey.So(cache.UsedCapacity(), convey.ShouldEqual, 4)
+		})
+
+		convey.Convey("value bigger than the whole cache is rejected", func() {
+			cache.SetMaxItemSize(0)
+			ok := cache.Add("k3", String(strings.Repeat("x", 30)))
+			convey.So(ok, convey.ShouldBeFalse)
+			convey.So(cache.Len(), convey.ShouldEqual, 2)
+		})
+	})
+}
+
+func TestEntryOverhead(t *testing.T) {
+	convey.Convey("TestEntryOverhead", t, func() {
+		cache := New(100)
+		cache.Add("k1", String("v1"))
+		cache.Add("k2", String("v2"))
+		cache.Add("k3", String("v3"))
+		convey.So(cache.UsedCapacity(), convey.ShouldEqual, 12)
+
+		convey.Convey("switching accounting recomputes and evicts", func() {
+			cache.SetEntryOverhead(40)
+			convey.So(cache.Keys(), convey.ShouldResemble, []string{"k2", "k3"})
+			convey.So(cache.UsedCapacity(), convey.ShouldEqual, 88)
+
+			cache.SetEntryOverhead(0)
+			convey.So(cache.UsedCapacity(), convey.ShouldEqual, 8)
+		})
+
+		convey.Convey("overhead counts toward item size", func() {
+			cache.SetEntryOverhead(EntryOverhead)
+			convey.So(cache.Add("k4", String("v4")), convey.ShouldBeFalse)
+		})
+	})
+}
+
+// 随机的操作序列，检查容量不变式
+type op struct {
+	Kind  uint8
+	Key   uint8
+	Value uint8
+}
+
+func checkInvariant(c *Cache) error {
+	if c.maxCapacity > 0 && c.usedCapacity > c.maxCapacity {
+		return fmt.Errorf("usedCapacity %v > maxCapacity %v", c.usedCapacity, c.maxCapacity)
+	}
+	if len(c.cache) != c.queue.Len() {
+		return fmt.Errorf("map len %v != queue len %v", len(c.cache), c.queue.Len())
+	}
+	var used int64
+	for element := c.queue.Front(); element != nil; element = element.Next() {
+		node := element.Value.(*node)
+		used += int64(len(node.key)+node.value.Len()) + c.entryOverhead
+	}
+	if used != c.usedCapacity {
+		return fmt.Errorf("usedCapacity %v != sum of entries %v", c.usedCapacity, used)
+	}
+	if c.availableCapacity != c.maxCapacity-c.usedCapacity {
+		return fmt.Errorf("availableCapacity %v is stale", c.availableCapacity)
+	}
+	return nil
+}
+
+func TestCapacityInvariant(t *testing.T) {
+	property := func(maxCapacity uint8, maxItemSize uint8, overhead uint8, ops []op) bool {
+		cache := New(int64(maxCapacity))
+		cache.SetMaxItemSize(int64(maxItemSize % 64))
+		cache.SetEntryOverhead(int64(overhead % 16))
+
+		for _, o := range ops {
+			key := fmt.Sprintf("k%d", o.Key%32)
+			switch o.Kind % 5 {
+			case 0, 1:
+				cache.Add(key, String(strings.Repeat("x", int(o.Value%128))))
+			case 2:
+				cache.Get(key)
+			case 3:
+				cache.Remove(key)
+			case 4:
+				cache.Resize(int64(o.Value))
+			}
+			if err := checkInvariant(cache); err != nil {
+				t.Log(err)
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 type String string
 
 func (str String) Len() int {
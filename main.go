@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/gy0117/gocache/cache"
+	"github.com/gy0117/gocache/consistenthash"
+	"github.com/gy0117/gocache/server"
 )
 
 var db = map[string]string{
@@ -21,8 +29,22 @@ func main() {
 	// 测试
 	var port int
 	var api bool
+	var snapshotDir string
+	var warmup bool
+	var replicas int
+	var weights string
+	var placement string
+	var adminToken string
+	var peerToken string
 	flag.IntVar(&port, "port", 8001, "marscache server port")
 	flag.BoolVar(&api, "api", false, "Start api server?")
+	flag.StringVar(&snapshotDir, "snapshot", "", "dump cache to this dir on SIGTERM and reload on startup")
+	flag.BoolVar(&warmup, "warmup", false, "fetch owned hot entries from the other peers after joining")
+	flag.IntVar(&replicas, "replicas", 1, "number of replicas for each key")
+	flag.StringVar(&weights, "weights", "", "peer weights by port, e.g. 8001=1,8002=8")
+	flag.StringVar(&placement, "placement", "ring", "key placement: ring, rendezvous, jump or bounded")
+	flag.StringVar(&adminToken, "admin-token", "", "bearer token for the admin api on the api server, empty disables it")
+	flag.StringVar(&peerToken, "peer-token", "", "token shared by the peers, required by -warmup and replica writes")
 	flag.Parse()
 
 	apiAddr := "http://127.0.0.1:9999"
@@ -32,32 +54,113 @@ func main() {
 		8003: "http://127.0.0.1:8003",
 	}
 
-	var addrs []string
-	for _, v := range addrMap {
-		addrs = append(addrs, v)
+	// 节点的权重，默认都为1
+	peerWeights, err := parseWeights(weights)
+	if err != nil {
+		log.Fatalf("invalid -weights: %v", err)
+	}
+	addrs := make(map[string]int, len(addrMap))
+	for k, v := range addrMap {
+		addrs[v] = 1
+		if w, ok := peerWeights[k]; ok {
+			addrs[v] = w
+		}
 	}
 
 	group := createGroup()
 
 	if api {
-		go startApiServer(apiAddr, group)
+		go startApiServer(apiAddr, group, adminToken)
 	}
 
-	startCacheServer(addrMap[port], []string(addrs), group)
+	startCacheServer(addrMap[port], addrs, placement, group, snapshotDir, warmup, replicas, peerToken)
 
 }
 
+// 解析 8001=1,8002=8 形式的权重
+func parseWeights(s string) (map[int]int, error) {
+	weights := make(map[int]int)
+	if s == "" {
+		return weights, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		var port, weight int
+		if _, err := fmt.Sscanf(item, "%d=%d", &port, &weight); err != nil {
+			return nil, fmt.Errorf("%q: %w", item, err)
+		}
+		if weight <= 0 {
+			return nil, fmt.Errorf("%q: weight must be positive", item)
+		}
+		weights[port] = weight
+	}
+	return weights, nil
+}
+
 // 缓存服务器走的是addr这个请求
-// 存在好几个节点addrs，但是这个服务走的是addr
-func startCacheServer(addr string, addrs []string, group *cache.Group) {
+// 存在好几个节点addrs（节点到权重），但是这个服务走的是addr
+// snapshotDir不为空时，启动时从快照恢复，收到SIGTERM时写入快照
+// warmup为true时，从其他节点拉取现在属于本节点的缓存，其他节点需要使用相同的peerToken
+func startCacheServer(addr string, addrs map[string]int, placement string, group *cache.Group, snapshotDir string, warmup bool, replicas int, peerToken string) {
 	peers := cache.NewHttpPool(addr)
-	peers.Set(addrs...)
+	peers.SetPeerToken(peerToken)
+	switch placement {
+	case "ring":
+	case "rendezvous":
+		peers.SetPlacement(func(weights map[string]int) consistenthash.Placement {
+			return consistenthash.NewRendezvous(weights)
+		})
+	case "jump":
+		peers.SetPlacement(func(weights map[string]int) consistenthash.Placement {
+			return consistenthash.NewJump(weights)
+		})
+	case "bounded":
+		peers.SetPlacement(peers.BoundedLoad(1.25))
+	default:
+		log.Fatalf("unknown placement: %v", placement)
+	}
+	peers.SetWeighted(addrs)
+	peers.SetReplicas(replicas)
 	group.RegisterPeerPicker(peers)
-	log.Println("marscache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+
+	if warmup {
+		go func() {
+			if err := peers.Warmup(context.Background(), group, 0); err != nil {
+				log.Printf("warmup failed: %v\n", err)
+			}
+		}()
+	}
+
+	if snapshotDir == "" {
+		log.Println("marscache is running at", addr)
+		log.Fatal(http.ListenAndServe(addr[7:], peers))
+		return
+	}
+
+	// 每个节点一个快照文件
+	path := filepath.Join(snapshotDir, fmt.Sprintf("%v-%v.snap", group.Name(), addr[7:]))
+	server.RestoreSnapshot(path, group)
+
+	go func() {
+		log.Println("marscache is running at", addr)
+		log.Fatal(http.ListenAndServe(addr[7:], peers))
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	<-sig
+
+	if err := server.DumpSnapshot(path, group); err != nil {
+		log.Fatalf("dump snapshot failed: %v", err)
+	}
+	log.Println("snapshot saved to", path)
 }
 
-func startApiServer(apiAddr string, group *cache.Group) {
+// adminToken不为空时，同时提供/_admin/管理接口
+func startApiServer(apiAddr string, group *cache.Group, adminToken string) {
+	if adminToken != "" {
+		http.Handle(cache.ADMIN_BASE_PATH, cache.NewAdmin(cache.TokenAuth(adminToken)))
+	}
+
 	http.Handle("/api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		key := r.URL.Query().Get("key")
 		log.Printf("startApiServer | query api | key: %v:\n", key)
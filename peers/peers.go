@@ -1,6 +1,10 @@
 package peers
 
-import "github.com/gy0117/gocache/pb"
+import (
+	"time"
+
+	"github.com/gy0117/gocache/pb"
+)
 
 // 根据key获取对应的节点(节点能力)
 type PeerPicker interface {
@@ -12,3 +16,28 @@ type PeerGetter interface {
 	// Get(group string, key string) ([]byte, error)
 	Get(in *pb.Request, out *pb.Response) error
 }
+
+// 多副本：根据key找到所有副本所在的节点，第一个是主节点，健康的节点排在前面
+// 本节点也是副本时，对应的位置为nil
+type ReplicaPicker interface {
+	PickReplicas(key string) []PeerGetter
+}
+
+// 写入、删除远程节点的本地缓存
+type PeerWriter interface {
+	Set(in *pb.Request, value *pb.Response) error
+	Delete(in *pb.Request) error
+}
+
+// 节点加入后的预热期间，根据key找到加入之前的节点，只查找该节点的本地缓存
+type PreviousPeerPicker interface {
+	PickPreviousPeer(key string) (getter PeerGetter, ok bool)
+}
+
+// 分布式租约：租约期间同一个key只有持有者从数据源加载，其他节点等待或者使用过期的数据
+type LeaseGetter interface {
+	// 为holder申请key的租约，已经被其他节点持有时返回false和持有者；同一个holder可以重复申请
+	Acquire(in *pb.Request, holder string, ttl time.Duration) (granted bool, current string, err error)
+	// 释放holder持有的租约
+	Release(in *pb.Request, holder string) error
+}
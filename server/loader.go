@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gy0117/gocache/cache"
+	"github.com/gy0117/gocache/config"
+)
+
+// 根据配置构造Group的Getter
+func newGetter(loader config.Loader) (cache.Getter, error) {
+	switch loader.Type {
+	case "http":
+		return httpLoader(loader.URL), nil
+	case "file":
+		return fileLoader(loader.Dir), nil
+	case "static":
+		values := loader.Values
+		return cache.GetterFunc(func(key string) ([]byte, error) {
+			if v, ok := values[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown loader type %q", loader.Type)
+}
+
+// GET url，url中的{key}替换为转义后的key；网络错误和5xx可以重试
+func httpLoader(template string) cache.Getter {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return cache.ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		u := strings.ReplaceAll(template, "{key}", url.PathEscape(key))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, cache.Transient(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, cache.Transient(fmt.Errorf("loader returned: %v", resp.Status))
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("loader returned: %v", resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	})
+}
+
+// 读取 dir/<key>，key不能跳出dir
+func fileLoader(dir string) cache.Getter {
+	return cache.GetterFunc(func(key string) ([]byte, error) {
+		if !filepath.IsLocal(key) {
+			return nil, fmt.Errorf("invalid key %q", key)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, key))
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return b, err
+	})
+}
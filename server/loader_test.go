@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gy0117/gocache/cache"
+	"github.com/gy0117/gocache/config"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoaders(t *testing.T) {
+	convey.Convey("TestLoaders", t, func() {
+		convey.Convey("http", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/users/busy" {
+					http.Error(w, "busy", http.StatusServiceUnavailable)
+					return
+				}
+				if r.URL.Path != "/users/zhang san" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte("100"))
+			}))
+			defer server.Close()
+
+			getter, err := newGetter(config.Loader{Type: "http", URL: server.URL + "/users/{key}"})
+			convey.So(err, convey.ShouldBeNil)
+			v, err := getter.Get("zhang san")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(v), convey.ShouldEqual, "100")
+			_, err = getter.Get("lisi")
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(cache.IsTransient(err), convey.ShouldBeFalse)
+			// 5xx可以重试
+			_, err = getter.Get("busy")
+			convey.So(cache.IsTransient(err), convey.ShouldBeTrue)
+		})
+
+		convey.Convey("file", func() {
+			dir := t.TempDir()
+			os.WriteFile(filepath.Join(dir, "zhangsan"), []byte("100"), 0o644)
+			getter, _ := newGetter(config.Loader{Type: "file", Dir: dir})
+			v, err := getter.Get("zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(string(v), convey.ShouldEqual, "100")
+			_, err = getter.Get("../etc/passwd")
+			convey.So(err, convey.ShouldNotBeNil)
+		})
+
+		convey.Convey("groups are created from the config", func() {
+			cfg := &config.Config{Groups: []config.GroupEntry{{
+				Name:     "gocached-arena",
+				Capacity: 1 << 20,
+				Eviction: "arena",
+				Disk:     &config.Disk{Dir: t.TempDir(), MaxBytes: 1 << 20},
+				Loader:   config.Loader{Type: "static", Values: map[string]string{"zhangsan": "100"}},
+			}}}
+			s := &Server{cfg: cfg, registry: cache.NewRegistry()}
+			convey.So(s.newGroups(), convey.ShouldBeNil)
+			defer s.closeStores()
+			v, err := s.groups[0].Get("zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(v.String(), convey.ShouldEqual, "100")
+			convey.So(s.groups[0].Capacity(), convey.ShouldEqual, 1<<20)
+		})
+	})
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gy0117/gocache/cache"
+	"github.com/gy0117/gocache/config"
+)
+
+// 一次重新加载的结果，Group的配置项以名称表示，例如 groups.scores.capacity
+type ReloadResult struct {
+	Applied []string // 已经生效的配置项
+	Restart []string // 发生了变化，但是需要重启才能生效的配置项，仍然使用原来的值
+}
+
+// 应用新的配置，可以在运行时修改的有：节点列表、节点子集、限流、副本数、Group的容量、过期时间和使用的节点子集、
+// 日志级别、退出超时时间
+// 其他配置项的变化记录在Restart中。next不合法或者节点列表解析失败时返回错误，不修改任何配置
+func (s *Server) Reload(next *config.Config) (ReloadResult, error) {
+	var result ReloadResult
+	if err := next.Validate(); err != nil {
+		return result, fmt.Errorf("invalid config:\n%w", err)
+	}
+
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	// 先完成所有可能失败的步骤，再修改
+	peerList, err := next.ResolvePeers()
+	if err != nil {
+		return result, fmt.Errorf("resolve peers: %w", err)
+	}
+	level, err := cache.ParseLogLevel(next.LogLevel)
+	if err != nil {
+		return result, err
+	}
+
+	cur := s.Config()
+	running := *cur
+	applied := func(field string) { result.Applied = append(result.Applied, field) }
+	restart := func(field string, changed bool) {
+		if changed {
+			result.Restart = append(result.Restart, field)
+		}
+	}
+	restart("listen", next.Listen != cur.Listen)
+	restart("advertise", next.Advertise != cur.Advertise)
+	restart("transport", next.Transport != cur.Transport)
+	restart("placement", next.Placement != cur.Placement)
+	restart("tls", !reflect.DeepEqual(next.TLS, cur.TLS))
+	restart("peer_token", next.PeerToken != cur.PeerToken)
+	restart("admin", !reflect.DeepEqual(next.Admin, cur.Admin))
+	restart("snapshot_dir", next.SnapshotDir != cur.SnapshotDir)
+
+	// Group只能修改容量、过期时间和使用的节点子集，增加和删除Group、修改存储方式、租约和加载选项需要重启
+	type groupChange struct {
+		group    *cache.Group
+		capacity int64
+		ttl      time.Duration
+		pool     *string // 不为nil时替换PeerPicker
+	}
+	var changes []groupChange
+	running.Groups = append([]config.GroupEntry(nil), cur.Groups...)
+	nextGroups := make(map[string]config.GroupEntry, len(next.Groups))
+	for _, entry := range next.Groups {
+		nextGroups[entry.Name] = entry
+	}
+	for i, entry := range running.Groups {
+		field := "groups." + entry.Name
+		n, ok := nextGroups[entry.Name]
+		if !ok {
+			restart(field, true)
+			continue
+		}
+		restart(field+".eviction", n.Eviction != entry.Eviction)
+		restart(field+".disk", !reflect.DeepEqual(n.Disk, entry.Disk))
+		restart(field+".loader", !reflect.DeepEqual(n.Loader, entry.Loader))
+		restart(field+".lease", n.Lease != entry.Lease)
+		restart(field+".load", !reflect.DeepEqual(n.Load, entry.Load))
+		if n.Capacity != entry.Capacity {
+			applied(field + ".capacity")
+		}
+		if n.TTL != entry.TTL {
+			applied(field + ".ttl")
+		}
+		change := groupChange{s.groups[i], int64(n.Capacity), time.Duration(n.TTL), nil}
+		if n.Pool != entry.Pool {
+			applied(field + ".pool")
+			change.pool = &n.Pool
+		}
+		if n.Capacity != entry.Capacity || n.TTL != entry.TTL || change.pool != nil {
+			changes = append(changes, change)
+			running.Groups[i].Capacity, running.Groups[i].TTL, running.Groups[i].Pool = n.Capacity, n.TTL, n.Pool
+		}
+	}
+	for _, entry := range next.Groups {
+		if s.group(entry.Name) == nil {
+			restart("groups."+entry.Name, true)
+		}
+	}
+
+	// 节点列表可能来自文件或者DNS，内容变化时配置不变，所以比较解析后的节点列表
+	if !reflect.DeepEqual(peerList, s.peers) {
+		if err := s.setPeers(peerList); err != nil {
+			return ReloadResult{}, err
+		}
+		s.peers = peerList
+		running.Peers, running.Discovery = next.Peers, next.Discovery
+		applied("peers")
+	}
+	// 先更新节点子集，再切换Group使用的节点子集
+	if !reflect.DeepEqual(next.Pools, cur.Pools) {
+		s.setPools(next.Pools)
+		running.Pools = next.Pools
+		applied("pools")
+	}
+	if !reflect.DeepEqual(next.Limits, cur.Limits) {
+		s.limiter.SetLimits(limits(next.Limits))
+		running.Limits = next.Limits
+		applied("limits")
+	}
+	if next.Replicas != cur.Replicas {
+		if s.httpPool != nil {
+			s.httpPool.SetReplicas(next.Replicas)
+		}
+		if s.grpcPool != nil {
+			s.grpcPool.SetReplicas(next.Replicas)
+		}
+		running.Replicas = next.Replicas
+		applied("replicas")
+	}
+	for _, change := range changes {
+		if change.capacity != change.group.Capacity() {
+			change.group.Resize(change.capacity)
+		}
+		change.group.SetTTL(change.ttl)
+		if change.pool != nil {
+			change.group.SetPeerPicker(s.picker(*change.pool))
+		}
+	}
+	if next.LogLevel != cur.LogLevel {
+		cache.SetLogLevel(level)
+		running.LogLevel = next.LogLevel
+		applied("log_level")
+	}
+	if next.ShutdownTimeout != cur.ShutdownTimeout {
+		running.ShutdownTimeout = next.ShutdownTimeout
+		applied("shutdown_timeout")
+	}
+
+	s.mutex.Lock()
+	s.cfg = &running
+	s.mutex.Unlock()
+	return result, nil
+}
+
+func (s *Server) group(name string) *cache.Group {
+	for _, g := range s.groups {
+		if g.Name() == name {
+			return g
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gy0117/gocache/cache"
+	"github.com/gy0117/gocache/config"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+func TestReload(t *testing.T) {
+	convey.Convey("TestReload", t, func() {
+		defer cache.SetLogLevel(cache.LogDebug)
+		srv, lis, cfg := newTestServer("server-reload", config.Loader{Type: "static"})
+		convey.So(srv.StartListener(lis), convey.ShouldBeNil)
+		defer srv.Shutdown(context.Background())
+		g := srv.Groups()[0]
+
+		// 复制一份配置，修改之后重新加载
+		next := func() *config.Config {
+			c := *cfg
+			c.Peers = append([]config.Peer(nil), cfg.Peers...)
+			c.Groups = append([]config.GroupEntry(nil), cfg.Groups...)
+			return &c
+		}
+
+		convey.Convey("runtime settings are applied, others need a restart", func() {
+			c := next()
+			c.Peers = append(c.Peers, config.Peer{Addr: "127.0.0.1:1", Weight: 1})
+			c.Replicas = 2
+			c.Limits = &config.Limits{ClientRate: 100}
+			c.Groups[0].Capacity = 2 << 20
+			c.Groups[0].TTL = config.Duration(time.Minute)
+			c.LogLevel = "error"
+			c.Listen = "127.0.0.1:1"
+			c.Groups[0].Eviction = "arena"
+			c.Groups[0].Lease = config.Duration(time.Second)
+
+			result, err := srv.Reload(c)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(result.Applied, convey.ShouldResemble, []string{
+				"groups.server-reload.capacity", "groups.server-reload.ttl", "peers", "limits", "replicas", "log_level",
+			})
+			convey.So(result.Restart, convey.ShouldResemble, []string{"listen", "groups.server-reload.eviction", "groups.server-reload.lease"})
+
+			convey.So(g.Capacity(), convey.ShouldEqual, 2<<20)
+			convey.So(g.TTL(), convey.ShouldEqual, time.Minute)
+			convey.So(cache.GetLogLevel(), convey.ShouldEqual, cache.LogError)
+			convey.So(srv.httpPool.Peers(), convey.ShouldHaveLength, 2)
+			convey.So(srv.Config().Listen, convey.ShouldEqual, cfg.Listen)
+			convey.So(srv.Config().Replicas, convey.ShouldEqual, 2)
+			convey.So(srv.limiter.Limits().Client.Rate, convey.ShouldEqual, 100)
+
+			// 再次加载相同的配置，只有需要重启的配置项
+			result, err = srv.Reload(c)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(result.Applied, convey.ShouldBeEmpty)
+			convey.So(result.Restart, convey.ShouldHaveLength, 3)
+		})
+
+		convey.Convey("groups can move between pools", func() {
+			c := next()
+			c.Pools = map[string][]config.Peer{"self-only": {{Addr: cfg.Listen, Weight: 1}}}
+			c.Groups[0].Pool = "self-only"
+			result, err := srv.Reload(c)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(result.Applied, convey.ShouldResemble, []string{"groups.server-reload.pool", "pools"})
+			subset, ok := g.PeerPicker().(*cache.Subset)
+			convey.So(ok, convey.ShouldBeTrue)
+			convey.So(subset.Name(), convey.ShouldEqual, "self-only")
+
+			c = next()
+			c.Groups[0].Pool = config.LocalPool
+			_, err = srv.Reload(c)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(g.PeerPicker(), convey.ShouldBeNil)
+		})
+
+		convey.Convey("invalid configs are rejected without applying anything", func() {
+			c := next()
+			c.Groups[0].Capacity = 2 << 20
+			c.Replicas = 0
+			_, err := srv.Reload(c)
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(err.Error(), convey.ShouldContainSubstring, "replicas: must be at least 1")
+
+			// 校验通过但是节点列表解析失败
+			c = next()
+			c.Groups[0].Capacity = 2 << 20
+			c.Peers = nil
+			c.Discovery = &config.Discovery{Type: "file", Path: t.TempDir() + "/missing"}
+			_, err = srv.Reload(c)
+			convey.So(err, convey.ShouldNotBeNil)
+
+			convey.So(g.Capacity(), convey.ShouldEqual, 1<<20)
+			convey.So(srv.httpPool.Peers(), convey.ShouldHaveLength, 1)
+			convey.So(srv.Config(), convey.ShouldEqual, cfg)
+		})
+	})
+}
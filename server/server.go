@@ -0,0 +1,501 @@
+// Package server 根据配置运行一个gocache节点，负责启动、重新加载配置和优雅退出
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gy0117/gocache/cache"
+	"github.com/gy0117/gocache/config"
+	"github.com/gy0117/gocache/consistenthash"
+	"github.com/gy0117/gocache/disk"
+	"github.com/gy0117/gocache/pb"
+	"github.com/gy0117/gocache/peers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var (
+	ErrStarted    = errors.New("server: already started")
+	ErrNotStarted = errors.New("server: not started")
+)
+
+// 启动后通知其他节点本节点重新加入的超时时间
+const joinTimeout = 10 * time.Second
+
+// 一个节点，包括所有的Group、节点间通信的服务和管理接口
+type Server struct {
+	cfg      *config.Config  // 正在使用的配置，Reload时整体替换
+	registry *cache.Registry // 每个Server一个，同一个进程中可以运行多个Server
+	groups   []*cache.Group
+	stores   []*disk.Store // 各个Group的磁盘缓存，退出时关闭
+	peers    []config.Peer // 解析后的节点列表
+
+	reloadMutex sync.Mutex
+
+	httpPool *cache.HttpPool // transport为http时使用
+	grpcPool *cache.GrpcPool // transport为grpc时使用
+	limiter  *cache.Limiter  // 节点间通信的服务的限流
+	peerHTTP *http.Server
+	peerGRPC *grpc.Server
+	admin    *http.Server
+
+	mutex   sync.Mutex
+	started bool
+	stopped bool
+	addr    net.Addr
+	errc    chan error
+	joined  chan struct{} // 加入的通知完成后关闭，退出时先等待，避免通知离开之后又被加回
+}
+
+// 根据配置创建Group和节点间通信的客户端，不监听端口；cfg需要已经校验过
+func New(cfg *config.Config) (*Server, error) {
+	s := &Server{
+		cfg:      cfg,
+		registry: cache.NewRegistry(),
+		limiter:  cache.NewLimiter(limits(cfg.Limits)),
+		errc:     make(chan error, 2),
+	}
+	level, err := cache.ParseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	cache.SetLogLevel(level)
+	if err := s.newGroups(); err != nil {
+		s.closeStores()
+		return nil, err
+	}
+	if s.peers, err = cfg.ResolvePeers(); err != nil {
+		s.closeStores()
+		return nil, fmt.Errorf("resolve peers: %w", err)
+	}
+
+	var serverTLS, clientTLS *tls.Config
+	if cfg.TLS != nil {
+		if serverTLS, err = cfg.TLS.ServerConfig(); err != nil {
+			s.closeStores()
+			return nil, err
+		}
+		if clientTLS, err = cfg.TLS.ClientConfig(); err != nil {
+			s.closeStores()
+			return nil, err
+		}
+	}
+
+	switch cfg.Transport {
+	case "http":
+		scheme := "http://"
+		if serverTLS != nil {
+			scheme = "https://"
+		}
+		pool := cache.NewHttpPool(scheme + cfg.Advertise)
+		if clientTLS != nil {
+			pool.SetClient(&http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}})
+		}
+		pool.SetRegistry(s.registry)
+		pool.SetPeerToken(cfg.PeerToken)
+		pool.SetPlacement(placementFunc(cfg.Placement, pool))
+		pool.SetReplicas(cfg.Replicas)
+		pool.SetLimiter(s.limiter)
+		s.httpPool = pool
+		s.setPeers(s.peers)
+		s.peerHTTP = &http.Server{Handler: pool, TLSConfig: serverTLS}
+
+	case "grpc":
+		creds := insecure.NewCredentials()
+		var serverOpts []grpc.ServerOption
+		if serverTLS != nil {
+			creds = credentials.NewTLS(clientTLS)
+			serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(serverTLS)))
+		}
+		pool := cache.NewGrpcPool(cfg.Advertise, grpc.WithTransportCredentials(creds))
+		pool.SetPeerToken(cfg.PeerToken)
+		pool.SetPlacement(placementFunc(cfg.Placement, nil))
+		pool.SetReplicas(cfg.Replicas)
+		s.grpcPool = pool
+		if err := s.setPeers(s.peers); err != nil {
+			s.closeStores()
+			return nil, err
+		}
+		s.peerGRPC = grpc.NewServer(serverOpts...)
+		grpcServer := cache.NewGrpcServer()
+		grpcServer.SetRegistry(s.registry)
+		grpcServer.SetPeerToken(cfg.PeerToken)
+		grpcServer.SetLimiter(s.limiter, pool.IsPeer)
+		pb.RegisterGroupCacheServer(s.peerGRPC, grpcServer)
+	}
+
+	s.setPools(cfg.Pools)
+	for i, g := range s.groups {
+		if picker := s.picker(cfg.Groups[i].Pool); picker != nil {
+			g.RegisterPeerPicker(picker)
+		}
+	}
+
+	if cfg.Admin != nil && cfg.Admin.Listen != "" {
+		admin := cache.NewAdmin(cache.TokenAuth(cfg.Admin.Token))
+		admin.SetRegistry(s.registry)
+		mux := http.NewServeMux()
+		mux.Handle(cache.ADMIN_BASE_PATH, admin)
+		s.admin = &http.Server{Addr: cfg.Admin.Listen, Handler: mux}
+	}
+	return s, nil
+}
+
+// 配置中的限流，为nil时不限制
+func limits(l *config.Limits) cache.Limits {
+	if l == nil {
+		return cache.Limits{}
+	}
+	return cache.Limits{
+		Client:          cache.RateLimit{Rate: l.ClientRate, Burst: l.ClientBurst},
+		Peer:            cache.RateLimit{Rate: l.PeerRate, Burst: l.PeerBurst},
+		Group:           cache.RateLimit{Rate: l.GroupRate, Burst: l.GroupBurst},
+		MaxPendingLoads: l.MaxPendingLoads,
+	}
+}
+
+// 创建所有的Group
+func (s *Server) newGroups() error {
+	for _, entry := range s.cfg.Groups {
+		getter, err := newGetter(entry.Loader)
+		if err != nil {
+			return fmt.Errorf("group %v: %w", entry.Name, err)
+		}
+
+		var opts []cache.GroupOption
+		if entry.TTL > 0 {
+			opts = append(opts, cache.WithTTL(time.Duration(entry.TTL)))
+		}
+		if entry.Lease > 0 {
+			opts = append(opts, cache.WithLease(time.Duration(entry.Lease)))
+		}
+		if l := entry.Load; l != nil {
+			opts = append(opts, cache.WithLoadOptions(cache.LoadOptions{
+				Timeout: time.Duration(l.Timeout),
+				Retry: cache.RetryPolicy{
+					Retries:    l.Retries,
+					Backoff:    time.Duration(l.Backoff),
+					MaxBackoff: time.Duration(l.MaxBackoff),
+				},
+				MaxConcurrent: l.MaxConcurrent,
+				MaxQueue:      l.MaxQueue,
+			}))
+		}
+		if entry.Eviction == "arena" {
+			opts = append(opts, cache.WithStorage(cache.StorageArena))
+		}
+		if entry.Disk != nil {
+			store, err := disk.Open(entry.Disk.Dir, int64(entry.Disk.MaxBytes))
+			if err != nil {
+				return fmt.Errorf("group %v: open disk tier: %w", entry.Name, err)
+			}
+			s.stores = append(s.stores, store)
+			opts = append(opts, cache.WithDiskTier(store))
+		}
+		g, err := s.registry.CreateGroup(entry.Name, int64(entry.Capacity), getter, opts...)
+		if err != nil {
+			return fmt.Errorf("group %v: %w", entry.Name, err)
+		}
+		s.groups = append(s.groups, g)
+	}
+	return nil
+}
+
+func placementFunc(name string, pool *cache.HttpPool) consistenthash.PlacementFunc {
+	switch name {
+	case "rendezvous":
+		return func(weights map[string]int) consistenthash.Placement {
+			return consistenthash.NewRendezvous(weights)
+		}
+	case "jump":
+		return func(weights map[string]int) consistenthash.Placement {
+			return consistenthash.NewJump(weights)
+		}
+	case "bounded":
+		if pool != nil {
+			return pool.BoundedLoad(1.25)
+		}
+		// gRPC没有统计请求数，退化为哈希环
+		return func(weights map[string]int) consistenthash.Placement {
+			return consistenthash.NewBoundedLoad(cache.REPLICS_PEERS, nil, weights, 1.25, func(string) int64 { return 0 })
+		}
+	}
+	return nil
+}
+
+// 节点及其权重，http的节点地址带有协议
+func (s *Server) weights(peerList []config.Peer) map[string]int {
+	prefix := ""
+	if s.httpPool != nil {
+		prefix = "http://"
+		if s.cfg.TLS != nil {
+			prefix = "https://"
+		}
+	}
+	weights := make(map[string]int, len(peerList))
+	for _, peer := range peerList {
+		weights[prefix+peer.Addr] = peer.Weight
+	}
+	return weights
+}
+
+// 更新节点列表
+func (s *Server) setPeers(peerList []config.Peer) error {
+	if s.httpPool != nil {
+		s.httpPool.SetWeighted(s.weights(peerList))
+		return nil
+	}
+	return s.grpcPool.SetWeighted(s.weights(peerList))
+}
+
+// 创建或者更新命名的节点子集
+func (s *Server) setPools(pools map[string][]config.Peer) {
+	for name, peerList := range pools {
+		if s.httpPool != nil {
+			s.httpPool.SetSubset(name, s.weights(peerList))
+		} else {
+			s.grpcPool.SetSubset(name, s.weights(peerList))
+		}
+	}
+}
+
+// Group使用的PeerPicker，只在本地缓存时返回nil
+func (s *Server) picker(pool string) peers.PeerPicker {
+	var subset *cache.Subset
+	switch {
+	case pool == config.LocalPool:
+		return nil
+	case pool == "" && s.httpPool != nil:
+		return s.httpPool
+	case pool == "":
+		return s.grpcPool
+	case s.httpPool != nil:
+		subset = s.httpPool.Subset(pool)
+	default:
+		subset = s.grpcPool.Subset(pool)
+	}
+	if subset == nil {
+		return nil
+	}
+	return subset
+}
+
+// 正在使用的配置，不能修改
+func (s *Server) Config() *config.Config {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.cfg
+}
+
+// 本节点的Group所在的Registry
+func (s *Server) Registry() *cache.Registry {
+	return s.registry
+}
+
+func (s *Server) Groups() []*cache.Group {
+	return s.groups
+}
+
+// 节点间通信实际监听的地址，启动之前为nil
+func (s *Server) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.addr
+}
+
+// 服务过程中出现的错误，例如管理接口的端口被占用，Shutdown引起的退出不会出现在这里
+func (s *Server) Err() <-chan error {
+	return s.errc
+}
+
+// 监听cfg.Listen并开始服务，不阻塞
+func (s *Server) Start() error {
+	lis, err := net.Listen("tcp", s.Config().Listen)
+	if err != nil {
+		return err
+	}
+	if err := s.StartListener(lis); err != nil {
+		lis.Close()
+		return err
+	}
+	return nil
+}
+
+// 在lis上开始节点间通信的服务，不阻塞，lis由Server关闭
+// 配置了snapshot_dir时，先从快照恢复，再开始服务
+func (s *Server) StartListener(lis net.Listener) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.started {
+		return ErrStarted
+	}
+
+	var adminLis net.Listener
+	if s.admin != nil {
+		var err error
+		if adminLis, err = net.Listen("tcp", s.admin.Addr); err != nil {
+			return err
+		}
+	}
+	s.started = true
+	s.addr = lis.Addr()
+
+	if s.cfg.SnapshotDir != "" {
+		for _, g := range s.groups {
+			RestoreSnapshot(snapshotPath(s.cfg.SnapshotDir, g), g)
+		}
+	}
+
+	switch {
+	case s.peerHTTP != nil:
+		if s.peerHTTP.TLSConfig != nil {
+			lis = tls.NewListener(lis, s.peerHTTP.TLSConfig)
+		}
+		go s.serve(func() error { return s.peerHTTP.Serve(lis) })
+		// 本节点之前退出时通知过其他节点离开，重启后通知它们把本节点加回
+		s.joined = make(chan struct{})
+		go func() {
+			defer close(s.joined)
+			ctx, cancel := context.WithTimeout(context.Background(), joinTimeout)
+			defer cancel()
+			if err := s.httpPool.Join(ctx); err != nil {
+				log.Printf("Server.Start | join: %v\n", err)
+			}
+		}()
+	case s.peerGRPC != nil:
+		go s.serve(func() error { return s.peerGRPC.Serve(lis) })
+	}
+	log.Printf("Server.Start | %v peer server listening on %v\n", s.cfg.Transport, s.addr)
+
+	if adminLis != nil {
+		go s.serve(func() error { return s.admin.Serve(adminLis) })
+		log.Printf("Server.Start | admin api listening on %v\n", adminLis.Addr())
+	}
+	return nil
+}
+
+// 忽略Shutdown引起的退出
+func (s *Server) serve(fn func() error) {
+	err := fn()
+	if err == nil || errors.Is(err, http.ErrServerClosed) {
+		return
+	}
+	select {
+	case s.errc <- err:
+	default:
+	}
+}
+
+// 优雅退出，依次：
+//  1. 通知其他节点本节点离开，之后其他节点不再把key分配给本节点
+//  2. 停止接收新的连接，等待正在处理的请求结束
+//  3. 等待正在进行的加载结束，例如本进程内直接调用Group.Get触发的加载
+//  4. 配置了snapshot_dir时写入快照
+//  5. 关闭到其他节点的连接和磁盘缓存
+//
+// ctx结束时不再等待，强制关闭连接，仍然写入快照；返回所有的错误
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	if !s.started || s.stopped {
+		s.mutex.Unlock()
+		return ErrNotStarted
+	}
+	s.stopped = true
+	snapshotDir := s.cfg.SnapshotDir
+	joined := s.joined
+	s.mutex.Unlock()
+
+	var errs []error
+	if s.httpPool != nil {
+		if joined != nil {
+			select {
+			case <-joined:
+			case <-ctx.Done():
+			}
+		}
+		// 通知失败只是其他节点会继续请求本节点，直到请求失败被标记为不健康
+		if err := s.httpPool.Leave(ctx); err != nil {
+			log.Printf("Server.Shutdown | leave: %v\n", err)
+		}
+	} else {
+		log.Println("Server.Shutdown | grpc transport cannot announce leave, peers will mark this node unhealthy")
+	}
+
+	if s.peerHTTP != nil {
+		if err := s.peerHTTP.Shutdown(ctx); err != nil {
+			s.peerHTTP.Close()
+			errs = append(errs, fmt.Errorf("shutdown peer server: %w", err))
+		}
+	}
+	if s.peerGRPC != nil {
+		if err := gracefulStop(ctx, s.peerGRPC); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown peer server: %w", err))
+		}
+	}
+	if s.admin != nil {
+		if err := s.admin.Shutdown(ctx); err != nil {
+			s.admin.Close()
+			errs = append(errs, fmt.Errorf("shutdown admin server: %w", err))
+		}
+	}
+
+	for _, g := range s.groups {
+		if err := g.WaitLoads(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("group %v: %v loads still in flight: %w", g.Name(), g.Inflight(), err))
+		}
+	}
+
+	if snapshotDir != "" {
+		for _, g := range s.groups {
+			if err := DumpSnapshot(snapshotPath(snapshotDir, g), g); err != nil {
+				errs = append(errs, fmt.Errorf("group %v: snapshot: %w", g.Name(), err))
+			}
+		}
+	}
+
+	if s.grpcPool != nil {
+		s.grpcPool.Close()
+	}
+	if err := s.closeStores(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// GracefulStop不支持超时，ctx结束时强制关闭
+func gracefulStop(ctx context.Context, server *grpc.Server) error {
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (s *Server) closeStores() error {
+	var errs []error
+	for _, store := range s.stores {
+		if err := store.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.stores = nil
+	return errors.Join(errs...)
+}
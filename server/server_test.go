@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gy0117/gocache/cache"
+	"github.com/gy0117/gocache/config"
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// 监听随机端口，配置中的listen和peers使用实际的地址
+func newTestServer(group string, loader config.Loader, peers ...string) (*Server, net.Listener, *config.Config) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	convey.So(err, convey.ShouldBeNil)
+
+	cfg := &config.Config{
+		Listen:    lis.Addr().String(),
+		Peers:     []config.Peer{{Addr: lis.Addr().String()}},
+		Groups:    []config.GroupEntry{{Name: group, Capacity: 1 << 20, Loader: loader}},
+		PeerToken: "peer-secret",
+	}
+	for _, peer := range peers {
+		cfg.Peers = append(cfg.Peers, config.Peer{Addr: peer})
+	}
+	cfg.SetDefaults()
+	convey.So(cfg.Validate(), convey.ShouldBeNil)
+
+	srv, err := New(cfg)
+	convey.So(err, convey.ShouldBeNil)
+	return srv, lis, cfg
+}
+
+// 加载时阻塞，直到release被关闭
+func slowOrigin() (origin *httptest.Server, started chan string, release chan struct{}) {
+	started = make(chan string, 10)
+	release = make(chan struct{})
+	origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- r.URL.Path
+		<-release
+		w.Write([]byte("100"))
+	}))
+	return origin, started, release
+}
+
+func TestShutdown(t *testing.T) {
+	convey.Convey("TestShutdown", t, func() {
+		convey.Convey("in-flight loads finish before shutdown returns", func() {
+			origin, started, release := slowOrigin()
+			defer origin.Close()
+			srv, lis, cfg := newTestServer("server-slow", config.Loader{Type: "http", URL: origin.URL + "/{key}"})
+			convey.So(srv.StartListener(lis), convey.ShouldBeNil)
+
+			// 一个来自其他节点的请求，一个本进程内的调用
+			var wg sync.WaitGroup
+			var status int
+			var body string
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				resp, err := http.Get(fmt.Sprintf("http://%v/_marscache/server-slow/zhangsan", cfg.Listen))
+				if err == nil {
+					b, _ := io.ReadAll(resp.Body)
+					resp.Body.Close()
+					status, body = resp.StatusCode, string(b)
+				}
+			}()
+			var value string
+			go func() {
+				defer wg.Done()
+				if v, err := srv.Groups()[0].Get("lisi"); err == nil {
+					value = v.String()
+				}
+			}()
+			<-started
+			<-started
+
+			done := make(chan error)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				done <- srv.Shutdown(ctx)
+			}()
+			select {
+			case <-done:
+				t.Fatal("shutdown returned before loads finished")
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			close(release)
+			convey.So(<-done, convey.ShouldBeNil)
+			wg.Wait()
+			convey.So(status, convey.ShouldEqual, http.StatusOK)
+			convey.So(body, convey.ShouldContainSubstring, "100")
+			convey.So(value, convey.ShouldEqual, "100")
+
+			// 不再接收新的请求
+			_, err := http.Get(fmt.Sprintf("http://%v/_marscache/_peers", cfg.Listen))
+			convey.So(err, convey.ShouldNotBeNil)
+			convey.So(srv.Shutdown(context.Background()), convey.ShouldEqual, ErrNotStarted)
+		})
+
+		convey.Convey("shutdown gives up when ctx expires", func() {
+			origin, started, release := slowOrigin()
+			defer origin.Close()
+			defer close(release)
+			srv, lis, _ := newTestServer("server-stuck", config.Loader{Type: "http", URL: origin.URL + "/{key}"})
+			convey.So(srv.StartListener(lis), convey.ShouldBeNil)
+
+			go srv.Groups()[0].Get("zhangsan")
+			<-started
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := srv.Shutdown(ctx)
+			convey.So(errors.Is(err, context.DeadlineExceeded), convey.ShouldBeTrue)
+			convey.So(err.Error(), convey.ShouldContainSubstring, "1 loads still in flight")
+		})
+
+		convey.Convey("peers are told to join and leave", func() {
+			var mutex sync.Mutex
+			var notices []string
+			peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				notices = append(notices, r.Method+" "+r.URL.Path+" "+r.URL.Query().Get("peer")+" "+r.Header.Get("Authorization"))
+				mutex.Unlock()
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer peer.Close()
+			peerAddr := peer.Listener.Addr().String()
+
+			srv, lis, cfg := newTestServer("server-leave", config.Loader{Type: "static"}, peerAddr)
+			convey.So(srv.StartListener(lis), convey.ShouldBeNil)
+			self := "http://" + cfg.Listen
+
+			// 其他节点离开时，从节点列表中移除
+			getPeers := func() int {
+				resp, err := http.Get(self + "/_marscache/_peers")
+				convey.So(err, convey.ShouldBeNil)
+				defer resp.Body.Close()
+				var peers []cache.PeerInfo
+				convey.So(json.NewDecoder(resp.Body).Decode(&peers), convey.ShouldBeNil)
+				return len(peers)
+			}
+			notify := func(path, caller, token string) int {
+				req, _ := http.NewRequest(http.MethodPost, self+"/_marscache/"+path+"?peer=http://"+peerAddr, nil)
+				req.Header.Set(cache.PEER_HEADER, caller)
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				convey.So(err, convey.ShouldBeNil)
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			convey.So(getPeers(), convey.ShouldEqual, 2)
+
+			// 只接受离开的节点自己发起的、带有令牌的通知
+			convey.So(notify("_leave", "http://"+peerAddr, ""), convey.ShouldEqual, http.StatusForbidden)
+			convey.So(notify("_leave", self, "peer-secret"), convey.ShouldEqual, http.StatusForbidden)
+			convey.So(getPeers(), convey.ShouldEqual, 2)
+			convey.So(notify("_leave", "http://"+peerAddr, "peer-secret"), convey.ShouldEqual, http.StatusNoContent)
+			convey.So(getPeers(), convey.ShouldEqual, 1)
+
+			// 离开的节点重启后重新加入
+			convey.So(notify("_join", "http://"+peerAddr, ""), convey.ShouldEqual, http.StatusForbidden)
+			convey.So(getPeers(), convey.ShouldEqual, 1)
+			convey.So(notify("_join", "http://"+peerAddr, "peer-secret"), convey.ShouldEqual, http.StatusNoContent)
+			convey.So(getPeers(), convey.ShouldEqual, 2)
+
+			// 启动时通知其他节点加入，退出时通知离开
+			convey.So(srv.Shutdown(context.Background()), convey.ShouldBeNil)
+			convey.So(notices, convey.ShouldResemble, []string{
+				"POST /_marscache/_join " + self + " Bearer peer-secret",
+				"POST /_marscache/_leave " + self + " Bearer peer-secret",
+			})
+		})
+
+		convey.Convey("a snapshot is written on shutdown and restored on start", func() {
+			dir := t.TempDir()
+			loader := config.Loader{Type: "static", Values: map[string]string{"zhangsan": "100"}}
+			srv, lis, cfg := newTestServer("server-snapshot", loader)
+			cfg.SnapshotDir = dir
+			convey.So(srv.StartListener(lis), convey.ShouldBeNil)
+			_, err := srv.Groups()[0].Get("zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(srv.Shutdown(context.Background()), convey.ShouldBeNil)
+
+			_, err = os.Stat(filepath.Join(dir, "server-snapshot.snap"))
+			convey.So(err, convey.ShouldBeNil)
+
+			// 新的节点中数据源已经没有该key，只能从快照中读到
+			restarted, lis, cfg := newTestServer("server-snapshot", config.Loader{Type: "static"})
+			cfg.SnapshotDir = dir
+			convey.So(restarted.StartListener(lis), convey.ShouldBeNil)
+			defer restarted.Shutdown(context.Background())
+			v, err := restarted.Groups()[0].Get("zhangsan")
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(v.String(), convey.ShouldEqual, "100")
+		})
+	})
+}
+
+// 同一个进程中运行两个节点，Group的名称相同
+func TestCluster(t *testing.T) {
+	convey.Convey("TestCluster", t, func() {
+		var listeners []net.Listener
+		var peers []config.Peer
+		for i := 0; i < 2; i++ {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			convey.So(err, convey.ShouldBeNil)
+			listeners = append(listeners, lis)
+			peers = append(peers, config.Peer{Addr: lis.Addr().String()})
+		}
+
+		var servers []*Server
+		for i, lis := range listeners {
+			cfg := &config.Config{
+				Listen: lis.Addr().String(),
+				Peers:  peers,
+				Groups: []config.GroupEntry{{Name: "scores", Capacity: 1 << 20, Loader: config.Loader{
+					Type:   "static",
+					Values: map[string]string{"zhangsan": fmt.Sprintf("node%d", i), "lisi": fmt.Sprintf("node%d", i)},
+				}}},
+			}
+			cfg.SetDefaults()
+			convey.So(cfg.Validate(), convey.ShouldBeNil)
+			srv, err := New(cfg)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(srv.StartListener(lis), convey.ShouldBeNil)
+			defer srv.Shutdown(context.Background())
+			servers = append(servers, srv)
+		}
+		convey.So(cache.GetGroup("scores"), convey.ShouldBeNil)
+
+		// 两个节点读到的是key所属的节点加载的值
+		for _, key := range []string{"zhangsan", "lisi"} {
+			v0, err := servers[0].Registry().GetGroup("scores").Get(key)
+			convey.So(err, convey.ShouldBeNil)
+			v1, err := servers[1].Registry().GetGroup("scores").Get(key)
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(v0.String(), convey.ShouldEqual, v1.String())
+		}
+	})
+}
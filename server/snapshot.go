@@ -0,0 +1,52 @@
+package server
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/gy0117/gocache/cache"
+)
+
+// 每个Group一个快照文件
+func snapshotPath(dir string, g *cache.Group) string {
+	return filepath.Join(dir, g.Name()+".snap")
+}
+
+// 从path恢复group，文件不存在时忽略，其他错误只记录日志，已经恢复的数据保留
+func RestoreSnapshot(path string, group *cache.Group) {
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("RestoreSnapshot | open %v: %v\n", path, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	if _, err := group.Restore(f); err != nil {
+		log.Printf("RestoreSnapshot | restore %v: %v\n", path, err)
+	}
+}
+
+// 先写临时文件再重命名，避免写了一半的快照覆盖之前的快照
+func DumpSnapshot(path string, group *cache.Group) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := group.Snapshot(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
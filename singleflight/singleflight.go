@@ -1,6 +1,11 @@
 package singleflight
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
 	"sync"
 )
 
@@ -8,20 +13,46 @@ import (
 
 type CallValue interface{}
 
+// doFunc调用了runtime.Goexit
+var errGoexit = errors.New("singleflight: doFunc called runtime.Goexit")
+
+// doFunc发生panic时，所有等待者都会收到PanicError
+// Do和DoContext重新抛出panic，DoChan在结果的Err中返回
+type PanicError struct {
+	Value interface{} // recover()的返回值
+	Stack []byte      // 发生panic时的调用栈
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: panic: %v\n\n%s", p.Value, p.Stack)
+}
+
+// DoChan和DoContext的结果，Shared表示结果是否同时返回给了多个调用者
+type Result struct {
+	Val    CallValue
+	Err    error
+	Shared bool
+}
+
 // call 表示一个请求
 type call struct {
 	wg  sync.WaitGroup
 	val CallValue
 	err error
+
+	dups  int             // 等待这个call的调用者个数，不包括发起者
+	chans []chan<- Result // DoChan的调用者
 }
 
 type Group struct {
-	mutex sync.Mutex
-	calls map[string]*call // 一个key对应一个call
+	mutex   sync.Mutex
+	calls   map[string]*call // 一个key对应一个call
+	waiters []chan struct{}  // 等待所有call结束，在calls变为空时通知
 }
 
 type DoFunc func() (CallValue, error)
 
+// 相同的key同时只执行一次doFunc，其他调用者等待并返回相同的结果
 func (g *Group) Do(key string, doFunc DoFunc) (CallValue, error) {
 	g.mutex.Lock()
 
@@ -29,11 +60,12 @@ func (g *Group) Do(key string, doFunc DoFunc) (CallValue, error) {
 		g.calls = make(map[string]*call)
 	}
 
-	if call, ok := g.calls[key]; ok {
+	if c, ok := g.calls[key]; ok {
+		c.dups++
 		g.mutex.Unlock()
 
-		call.wg.Wait()
-		return call.val, call.err
+		c.wg.Wait()
+		return c.result()
 	}
 
 	c := new(call)
@@ -42,15 +74,141 @@ func (g *Group) Do(key string, doFunc DoFunc) (CallValue, error) {
 
 	g.mutex.Unlock()
 
-	c.val, c.err = doFunc()
-	c.wg.Done()
+	g.doCall(c, key, doFunc)
+	return c.result()
+}
 
-	// 删除call
-	// 首先不同的key可能每次的doFunc不一样，因此值是不一样的，所以这里没必要存储；
-	// 另外这里也不应该存储数据
+// 和Do相同，但是不阻塞，结果写入返回的channel
+func (g *Group) DoChan(key string, doFunc DoFunc) <-chan Result {
+	ch := make(chan Result, 1)
 	g.mutex.Lock()
-	delete(g.calls, key)
+
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mutex.Unlock()
+		return ch
+	}
+
+	c := &call{chans: []chan<- Result{ch}}
+	c.wg.Add(1)
+	g.calls[key] = c
+
 	g.mutex.Unlock()
 
+	go g.doCall(c, key, doFunc)
+	return ch
+}
+
+// 和Do相同，但是ctx结束时不再等待，返回ctx.Err()
+// doFunc在单独的goroutine中执行，调用者放弃等待后仍然会继续执行，结果返回给其他等待者
+func (g *Group) DoContext(ctx context.Context, key string, doFunc DoFunc) Result {
+	if err := ctx.Err(); err != nil {
+		return Result{Err: err}
+	}
+
+	select {
+	case res := <-g.DoChan(key, doFunc):
+		if pe, ok := res.Err.(*PanicError); ok {
+			panic(pe)
+		}
+		return res
+	case <-ctx.Done():
+		return Result{Err: ctx.Err()}
+	}
+}
+
+// 不再合并key正在进行的call，之后的调用会重新执行doFunc
+// 已经在等待的调用者仍然等待原来的call，被忘记的call不再计入Inflight和Wait
+func (g *Group) Forget(key string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if _, ok := g.calls[key]; ok {
+		g.remove(key)
+	}
+}
+
+// 执行doFunc，doFunc发生panic或者调用runtime.Goexit时也要通知所有等待者
+func (g *Group) doCall(c *call, key string, doFunc DoFunc) {
+	normalReturn := false
+	defer func() {
+		if !normalReturn {
+			// panic(nil)在go1.21之后会变成*runtime.PanicNilError，所以recover返回nil说明是Goexit
+			if r := recover(); r != nil {
+				c.err = &PanicError{Value: r, Stack: debug.Stack()}
+			} else {
+				c.err = errGoexit
+			}
+		}
+
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		c.wg.Done()
+
+		// 删除call
+		// 首先不同的key可能每次的doFunc不一样，因此值是不一样的，所以这里没必要存储；
+		// 另外这里也不应该存储数据
+		if g.calls[key] == c {
+			g.remove(key)
+		}
+		for _, ch := range c.chans {
+			ch <- Result{c.val, c.err, c.dups > 0}
+		}
+	}()
+
+	c.val, c.err = doFunc()
+	normalReturn = true
+}
+
+// 删除key对应的call，calls变为空时通知所有等待者，调用者持有锁
+func (g *Group) remove(key string) {
+	delete(g.calls, key)
+	if len(g.calls) == 0 {
+		for _, waiter := range g.waiters {
+			close(waiter)
+		}
+		g.waiters = nil
+	}
+}
+
+// Do的返回值，doFunc发生panic时在当前goroutine中重新抛出
+func (c *call) result() (CallValue, error) {
+	if pe, ok := c.err.(*PanicError); ok {
+		panic(pe)
+	}
+	if c.err == errGoexit {
+		runtime.Goexit()
+	}
 	return c.val, c.err
 }
+
+// 正在进行的call的个数
+func (g *Group) Inflight() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return len(g.calls)
+}
+
+// 等待所有正在进行的call结束，ctx结束时返回ctx.Err()
+// 等待期间新开始的call也需要结束
+func (g *Group) Wait(ctx context.Context) error {
+	g.mutex.Lock()
+	if len(g.calls) == 0 {
+		g.mutex.Unlock()
+		return nil
+	}
+	waiter := make(chan struct{})
+	g.waiters = append(g.waiters, waiter)
+	g.mutex.Unlock()
+
+	select {
+	case <-waiter:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
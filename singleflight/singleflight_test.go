@@ -0,0 +1,224 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/smartystreets/goconvey/convey"
+)
+
+// 返回一个阻塞到release关闭的doFunc，started在开始执行时关闭
+func blocking(calls *int32, val CallValue) (DoFunc, chan struct{}, chan struct{}) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	return func() (CallValue, error) {
+		atomic.AddInt32(calls, 1)
+		once.Do(func() { close(started) })
+		<-release
+		return val, nil
+	}, started, release
+}
+
+func TestDo(t *testing.T) {
+	convey.Convey("TestDo", t, func() {
+		g := &Group{}
+
+		convey.Convey("concurrent calls share one result", func() {
+			var calls int32
+			fn, started, release := blocking(&calls, "bar")
+
+			var wg sync.WaitGroup
+			results := make([]CallValue, 5)
+			for i := range results {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					results[i], _ = g.Do("foo", fn)
+				}(i)
+			}
+			<-started
+			// 等待其他调用者加入
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			convey.So(atomic.LoadInt32(&calls), convey.ShouldEqual, 1)
+			for _, v := range results {
+				convey.So(v, convey.ShouldEqual, "bar")
+			}
+			convey.So(g.Inflight(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("errors are returned", func() {
+			want := errors.New("boom")
+			_, err := g.Do("foo", func() (CallValue, error) { return nil, want })
+			convey.So(err, convey.ShouldEqual, want)
+		})
+
+		convey.Convey("panics are re-raised and the key is released", func() {
+			var calls int32
+			started, release := make(chan struct{}), make(chan struct{})
+			fn := func() (CallValue, error) {
+				atomic.AddInt32(&calls, 1)
+				close(started)
+				<-release
+				panic("boom")
+			}
+
+			var wg sync.WaitGroup
+			panics := make([]interface{}, 3)
+			for i := range panics {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					defer func() { panics[i] = recover() }()
+					g.Do("foo", fn)
+				}(i)
+				if i == 0 {
+					<-started
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			convey.So(atomic.LoadInt32(&calls), convey.ShouldEqual, 1)
+			for _, p := range panics {
+				pe, ok := p.(*PanicError)
+				convey.So(ok, convey.ShouldBeTrue)
+				convey.So(pe.Value, convey.ShouldEqual, "boom")
+				convey.So(string(pe.Stack), convey.ShouldNotBeEmpty)
+			}
+
+			// 之后的调用不会死锁
+			v, err := g.Do("foo", func() (CallValue, error) { return "ok", nil })
+			convey.So(err, convey.ShouldBeNil)
+			convey.So(v, convey.ShouldEqual, "ok")
+		})
+	})
+}
+
+func TestDoChan(t *testing.T) {
+	convey.Convey("TestDoChan", t, func() {
+		g := &Group{}
+
+		convey.Convey("results report whether they were shared", func() {
+			var calls int32
+			fn, started, release := blocking(&calls, "bar")
+			ch1 := g.DoChan("foo", fn)
+			<-started
+			ch2 := g.DoChan("foo", fn)
+			close(release)
+
+			res1, res2 := <-ch1, <-ch2
+			convey.So(res1, convey.ShouldResemble, Result{Val: "bar", Shared: true})
+			convey.So(res2, convey.ShouldResemble, Result{Val: "bar", Shared: true})
+			convey.So(atomic.LoadInt32(&calls), convey.ShouldEqual, 1)
+
+			res := <-g.DoChan("foo", func() (CallValue, error) { return "baz", nil })
+			convey.So(res, convey.ShouldResemble, Result{Val: "baz"})
+		})
+
+		convey.Convey("panics are returned as PanicError", func() {
+			res := <-g.DoChan("foo", func() (CallValue, error) { panic("boom") })
+			var pe *PanicError
+			convey.So(errors.As(res.Err, &pe), convey.ShouldBeTrue)
+			convey.So(pe.Value, convey.ShouldEqual, "boom")
+			convey.So(g.Inflight(), convey.ShouldEqual, 0)
+		})
+	})
+}
+
+func TestDoContext(t *testing.T) {
+	convey.Convey("TestDoContext", t, func() {
+		g := &Group{}
+
+		convey.Convey("waiters can abandon the call", func() {
+			var calls int32
+			fn, started, release := blocking(&calls, "bar")
+			done := make(chan Result)
+			go func() { done <- g.DoContext(context.Background(), "foo", fn) }()
+			<-started
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			res := g.DoContext(ctx, "foo", fn)
+			convey.So(res.Err, convey.ShouldResemble, context.DeadlineExceeded)
+
+			// 放弃等待不影响其他等待者
+			close(release)
+			res = <-done
+			convey.So(res.Err, convey.ShouldBeNil)
+			convey.So(res.Val, convey.ShouldEqual, "bar")
+			convey.So(res.Shared, convey.ShouldBeTrue)
+			convey.So(atomic.LoadInt32(&calls), convey.ShouldEqual, 1)
+		})
+
+		convey.Convey("a done context does not start a call", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			res := g.DoContext(ctx, "foo", func() (CallValue, error) { panic("not reached") })
+			convey.So(res.Err, convey.ShouldEqual, context.Canceled)
+			convey.So(g.Inflight(), convey.ShouldEqual, 0)
+		})
+
+		convey.Convey("panics are re-raised", func() {
+			convey.So(func() {
+				g.DoContext(context.Background(), "foo", func() (CallValue, error) { panic("boom") })
+			}, convey.ShouldPanic)
+		})
+	})
+}
+
+func TestForget(t *testing.T) {
+	convey.Convey("TestForget", t, func() {
+		g := &Group{}
+		var calls int32
+		fn, started, release := blocking(&calls, "first")
+		ch1 := g.DoChan("foo", fn)
+		<-started
+
+		// 忘记之后重新执行doFunc，原来的调用者仍然得到原来的结果
+		g.Forget("foo")
+		convey.So(g.Inflight(), convey.ShouldEqual, 0)
+		convey.So(g.Wait(context.Background()), convey.ShouldBeNil)
+
+		v, err := g.Do("foo", func() (CallValue, error) { return "second", nil })
+		convey.So(err, convey.ShouldBeNil)
+		convey.So(v, convey.ShouldEqual, "second")
+
+		close(release)
+		convey.So((<-ch1).Val, convey.ShouldEqual, "first")
+
+		// 被忘记的call结束时不会删除之后的call
+		fn2, started2, release2 := blocking(&calls, "third")
+		ch2 := g.DoChan("foo", fn2)
+		<-started2
+		convey.So(g.Inflight(), convey.ShouldEqual, 1)
+		close(release2)
+		convey.So((<-ch2).Val, convey.ShouldEqual, "third")
+	})
+}
+
+func TestWait(t *testing.T) {
+	convey.Convey("TestWait", t, func() {
+		g := &Group{}
+		var calls int32
+		fn, started, release := blocking(&calls, "bar")
+		ch := g.DoChan("foo", fn)
+		<-started
+		convey.So(g.Inflight(), convey.ShouldEqual, 1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		convey.So(g.Wait(ctx), convey.ShouldResemble, context.DeadlineExceeded)
+
+		close(release)
+		<-ch
+		convey.So(g.Wait(context.Background()), convey.ShouldBeNil)
+	})
+}